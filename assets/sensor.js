@@ -1,5 +1,53 @@
 console.log('Starting sensor.js');
 
+// Pointer and key timing samples for the server's behaviour analysis. Only
+// positions and timings are kept, never which keys were pressed.
+const behavior = { pointer: [], keys: [] };
+const behaviorStart = performance.now();
+const maxPointerSamples = 500;
+const maxKeySamples = 100;
+const keysDown = {};
+
+function behaviorTime() {
+    return Math.round(performance.now() - behaviorStart);
+}
+
+function recordPointer(x, y) {
+    if (behavior.pointer.length >= maxPointerSamples) return;
+    behavior.pointer.push({ x: Math.round(x), y: Math.round(y), t: behaviorTime() });
+}
+
+window.addEventListener('mousemove', (e) => recordPointer(e.clientX, e.clientY), { passive: true });
+window.addEventListener('touchmove', (e) => {
+    const t = e.touches[0];
+    if (t) recordPointer(t.clientX, t.clientY);
+}, { passive: true });
+window.addEventListener('keydown', (e) => {
+    if (!e.repeat) keysDown[e.code] = behaviorTime();
+});
+window.addEventListener('keyup', (e) => {
+    const down = keysDown[e.code];
+    if (down === undefined || behavior.keys.length >= maxKeySamples) return;
+    delete keysDown[e.code];
+    behavior.keys.push({ t: down, d: behaviorTime() - down });
+});
+
+// waitForBehavior gives the visitor a moment to move before the fingerprint
+// is posted: it resolves once enough pointer samples arrived or after
+// maxWait milliseconds, whichever comes first.
+function waitForBehavior(minSamples, maxWait) {
+    return new Promise((resolve) => {
+        const started = performance.now();
+        (function check() {
+            if (behavior.pointer.length >= minSamples || performance.now() - started >= maxWait) {
+                resolve();
+                return;
+            }
+            setTimeout(check, 100);
+        })();
+    });
+}
+
 async function collectFingerprint() {
     console.log('collectFingerprint: Starting fingerprint collection');
 
@@ -25,6 +73,7 @@ async function collectFingerprint() {
         return gl.getParameter(gl.RENDERER);
     })();
     const isMobile = /Mobi|Android/i.test(navigator.userAgent);
+    await waitForBehavior(30, 1500);
     const fingerprint = {
         plugins: plugins,
         hardwareCon: navigator.hardwareConcurrency || 0,
@@ -39,12 +88,14 @@ async function collectFingerprint() {
         screen: { width: screen.width, height: screen.height },
         timezone: Intl.DateTimeFormat().resolvedOptions().timeZone,
         jsEnabled: true,
-        isMobile: isMobile
+        isMobile: isMobile,
+        behavior: behavior
     };
 
     console.log('collectFingerprint: Canvas hash generated: ' + fingerprint.canvasHash);
     console.log('collectFingerprint: Fonts detected: ' + fingerprint.fonts);
     console.log('collectFingerprint: WebGL renderer: ' + fingerprint.webglRenderer);
+    console.log('collectFingerprint: Behaviour samples: ' + behavior.pointer.length + ' pointer, ' + behavior.keys.length + ' keys');
 
     try {
         console.log('collectFingerprint: Sending fingerprint to /janus/fingerprint');
@@ -58,27 +109,21 @@ async function collectFingerprint() {
         console.log('collectFingerprint: Fingerprint submitted successfully');
 
         console.log('collectFingerprint: Fetching challenge from /janus/challenge');
-        response = await fetch('/janus/challenge');
+        response = await fetch('/janus/challenge?path=' + encodeURIComponent(location.pathname));
         if (!response.ok) throw new Error('Challenge fetch failed: ' + response.status);
         const challenge = await response.json();
         console.log('collectFingerprint: Received challenge: ' + JSON.stringify(challenge));
 
         const challengeUI = document.getElementById('challenge-ui');
-        if (challenge.type === 'image') {
-            challengeUI.innerHTML = '<b>Image Puzzle:</b> Click the cat image to continue.<br><img id="cat-img" src="https://cataas.com/cat?width=120" style="cursor:pointer;max-width:120px;">';
-            document.getElementById('cat-img').onclick = async function() {
-                await verifyProof('image-solved');
-            };
-            return;
-        } else if (challenge.type === 'logic') {
-            challengeUI.innerHTML = '<b>Logic Question:</b> What is 2 + 2? <input id="logic-answer" type="text" size="4"> <button id="logic-btn">Submit</button>';
-            document.getElementById('logic-btn').onclick = async function() {
-                const answer = document.getElementById('logic-answer').value;
-                if (answer.trim() === '4') {
-                    await verifyProof('logic-4');
-                } else {
-                    alert('Try again!');
-                }
+        const { nonce, iterations, seed, clientIP, difficulty } = challenge;
+        if (challenge.type === 'image' || challenge.type === 'logic') {
+            challengeUI.innerHTML = '<b>Puzzle:</b> <span id="puzzle-prompt"></span><br><img id="puzzle-image" alt="Puzzle"><br><input id="puzzle-answer" type="text" size="8" autocomplete="off"> <button id="puzzle-btn">Submit</button>';
+            document.getElementById('puzzle-prompt').textContent = challenge.prompt;
+            document.getElementById('puzzle-image').src = challenge.image;
+            document.getElementById('puzzle-btn').onclick = function() {
+                const answer = document.getElementById('puzzle-answer').value.trim();
+                challengeUI.innerHTML = '<b>Puzzle:</b> Verifying...';
+                submitAnswer(answer);
             };
             return;
         } else if (challenge.difficulty === 0) {
@@ -87,33 +132,44 @@ async function collectFingerprint() {
             challengeUI.innerHTML = '<b>Proof-of-Work Challenge:</b> Solving...';
         }
 
-        const { nonce, iterations, seed, clientIP, difficulty } = challenge;
-        const timestamp = new Date().toISOString();
-        let proof;
-        const maxIterations = Math.min(iterations, isMobile ? 1000 : 5000);
-        for (let i = 0; i < maxIterations; i++) {
-            proof = `${nonce}|${i}|${timestamp}|${clientIP}|${seed}`;
-            if (!isMobile) {
-                proof += `|${canvasHash}`;
-            }
-            const hash = await crypto.subtle.digest('SHA-256', new TextEncoder().encode(proof));
-            const hashArray = new Uint8Array(hash);
-            if (hasLeadingZeroBits(hashArray, difficulty)) {
-                console.log('collectFingerprint: Computed proof: ' + proof);
-                break;
+        await verifyProof(await solveProof());
+
+        async function solveProof() {
+            const timestamp = new Date().toISOString();
+            let proof;
+            for (let i = 0; i < iterations; i++) {
+                proof = `${nonce}|${i}|${timestamp}|${clientIP}|${seed}`;
+                if (!isMobile) {
+                    proof += `|${canvasHash}`;
+                }
+                const hash = await crypto.subtle.digest('SHA-256', new TextEncoder().encode(proof));
+                const hashArray = new Uint8Array(hash);
+                if (hasLeadingZeroBits(hashArray, difficulty)) {
+                    console.log('collectFingerprint: Computed proof: ' + proof);
+                    return proof;
+                }
             }
-            if (i === maxIterations - 1) {
-                throw new Error('Failed to compute valid proof within iteration limit');
+            throw new Error('Failed to compute valid proof within iteration limit');
+        }
+
+        // submitAnswer verifies a puzzle answer. The server checks it, and a
+        // wrong answer uses up the challenge, so the page starts over.
+        async function submitAnswer(answer) {
+            try {
+                await verifyProof(await solveProof(), answer);
+            } catch (error) {
+                console.error('collectFingerprint: Puzzle verification failed: ' + error.message);
+                alert('Wrong answer, try again!');
+                location.reload();
             }
         }
-        await verifyProof(proof);
 
-        async function verifyProof(proofVal) {
+        async function verifyProof(proofVal, answer) {
             console.log('collectFingerprint: Sending proof to /janus/verify: ' + proofVal);
             let response = await fetch('/janus/verify', {
                 method: 'POST',
                 headers: { 'Content-Type': 'application/json' },
-                body: JSON.stringify({ nonce, proof: proofVal })
+                body: JSON.stringify({ nonce, proof: proofVal, answer: answer || '' })
             });
             if (!response.ok) throw new Error('Verification failed: ' + response.status);
             const verifyResult = await response.json();
@@ -0,0 +1,73 @@
+// Janus behavioural telemetry for verified pages. Include with
+// <script src="/janus/telemetry.js" defer></script>. Only event types,
+// timings and pointer positions are sent - never keys or page content.
+(function () {
+    const endpoint = '/janus/telemetry';
+    const flushInterval = 5000;
+    const maxEvents = 500;
+    const start = performance.now();
+    let events = [];
+    let lastMove = 0;
+    const keysDown = {};
+
+    function now() {
+        return Math.round(performance.now() - start);
+    }
+
+    function push(type, x, y) {
+        if (events.length >= maxEvents) return;
+        const ev = { type: type, t: now() };
+        if (x !== undefined) {
+            ev.x = Math.round(x);
+            ev.y = Math.round(y);
+        }
+        events.push(ev);
+    }
+
+    window.addEventListener('scroll', () => push('scroll'), { passive: true });
+    window.addEventListener('mousemove', (e) => {
+        // Sample at most every 20ms to keep batches small.
+        const now = performance.now();
+        if (now - lastMove < 20) return;
+        lastMove = now;
+        push('mousemove', e.clientX, e.clientY);
+    }, { passive: true });
+    window.addEventListener('touchmove', (e) => {
+        const t = e.touches[0];
+        if (t) push('touchmove', t.clientX, t.clientY);
+    }, { passive: true });
+    window.addEventListener('click', (e) => push('click', e.clientX, e.clientY));
+    window.addEventListener('keydown', (e) => {
+        if (!e.repeat) keysDown[e.code] = now();
+    });
+    window.addEventListener('keyup', (e) => {
+        // Key events carry timing only: when the key went down and how
+        // long it was held.
+        const down = keysDown[e.code];
+        if (down === undefined || events.length >= maxEvents) return;
+        delete keysDown[e.code];
+        events.push({ type: 'key', t: down, d: now() - down });
+    });
+
+    function flush(useBeacon) {
+        if (events.length === 0) return;
+        const body = JSON.stringify({ path: location.pathname, events: events });
+        events = [];
+        if (useBeacon && navigator.sendBeacon) {
+            navigator.sendBeacon(endpoint, body);
+            return;
+        }
+        fetch(endpoint, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: body,
+            keepalive: true
+        }).catch((err) => console.log('telemetry: flush failed: ' + err.message));
+    }
+
+    setInterval(() => flush(false), flushInterval);
+    window.addEventListener('pagehide', () => flush(true));
+    document.addEventListener('visibilitychange', () => {
+        if (document.visibilityState === 'hidden') flush(true);
+    });
+})();
@@ -1,39 +1,82 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 
-	"janus/internal/middleware"
+	"janus/internal/config"
+	"janus/internal/h2fp"
+	"janus/internal/janus"
+	"janus/internal/proxy"
+	"janus/internal/proxyproto"
+	"janus/internal/tlsfp"
 
 	"github.com/go-chi/chi/v5"
 )
 
 func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves until a server fails. It returns instead of exiting so the
+// deferred Close calls release Janus and the proxy.
+func run() error {
+	cfg, err := config.LoadConfig("config.yaml")
+	if err != nil {
+		log.Printf("Failed to load config: %v, using default config", err)
+		cfg = config.DefaultConfig()
+	}
+
+	j, err := janus.New(janus.Options{Config: cfg})
+	if err != nil {
+		return fmt.Errorf("Failed to initialise Janus: %w", err)
+	}
+	defer j.Close()
+
 	r := chi.NewRouter()
-	r.Use(middleware.JanusMiddleware)
-	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("Welcome to your protected site!"))
-	})
+	r.Use(j.Middleware)
 	r.Get("/sensor.js", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "assets/sensor.js")
 	})
+	if cfg.Proxy.Enabled {
+		p, err := proxy.New(cfg.Proxy, nil)
+		if err != nil {
+			return fmt.Errorf("Failed to configure proxy: %w", err)
+		}
+		defer p.Close()
+		r.Handle("/*", p)
+		log.Printf("Proxy mode enabled with %d upstream(s)", len(cfg.Proxy.Upstreams))
+	} else {
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("Welcome to your protected site!"))
+		})
+	}
 
 	cert, err := tls.LoadX509KeyPair("cert.pem", "key.pem")
 	if err != nil {
-		log.Fatalf("Failed to load certificates: %v. Generate with: openssl req -x509 -newkey rsa:2048 -keyout key.pem -out cert.pem -days 365 -nodes", err)
+		return fmt.Errorf("Failed to load certificates: %w. Generate with: openssl req -x509 -newkey rsa:2048 -keyout key.pem -out cert.pem -days 365 -nodes", err)
 	}
 
 	httpsServer := &http.Server{
 		Addr:    ":8080",
 		Handler: r,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return proxyproto.ConnContext(tlsfp.ConnContext(ctx, c), c)
+		},
 		TLSConfig: &tls.Config{
 			Certificates: []tls.Certificate{cert},
 			MinVersion:   tls.VersionTLS12,
 		},
 	}
 
+	h2fp.ConfigureServer(httpsServer, nil)
+
 	httpServer := &http.Server{
 		Addr: ":8081",
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -45,15 +88,46 @@ func main() {
 		}),
 	}
 
+	redirectLn, err := listen(httpServer.Addr, cfg.ProxyProtocol)
+	if err != nil {
+		return fmt.Errorf("HTTP listen failed: %w", err)
+	}
+	defer httpServer.Close()
+	errs := make(chan error, 2)
 	go func() {
 		log.Println("Starting HTTP redirect server on :8081")
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("HTTP server failed: %v", err)
+		if err := httpServer.Serve(redirectLn); err != nil && err != http.ErrServerClosed {
+			errs <- fmt.Errorf("HTTP server failed: %w", err)
 		}
 	}()
 
-	log.Println("Starting JANUS server on https://localhost:8080")
-	if err := httpsServer.ListenAndServeTLS("", ""); err != nil {
-		log.Fatalf("HTTPS server failed: %v", err)
+	ln, err := listen(httpsServer.Addr, cfg.ProxyProtocol)
+	if err != nil {
+		return fmt.Errorf("HTTPS listen failed: %w", err)
+	}
+	defer httpsServer.Close()
+	go func() {
+		log.Println("Starting JANUS server on https://localhost:8080")
+		errs <- fmt.Errorf("HTTPS server failed: %w", httpsServer.ServeTLS(tlsfp.NewListener(ln), "", ""))
+	}()
+	return <-errs
+}
+
+// listen opens a TCP listener, accepting PROXY protocol headers from the
+// configured load balancers when enabled.
+func listen(addr string, pp config.ProxyProtocolConfig) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if !pp.Enabled {
+		return ln, nil
+	}
+	wrapped, err := proxyproto.NewListener(ln, pp)
+	if err != nil {
+		ln.Close()
+		return nil, err
 	}
+	log.Printf("PROXY protocol enabled on %s for %v", addr, pp.TrustedCIDRs)
+	return wrapped, nil
 }
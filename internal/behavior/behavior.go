@@ -0,0 +1,250 @@
+// Package behavior scores how human a visitor's pointer and keyboard input
+// looks. Scripts tend to replay evenly timed, constant-speed, straight or
+// perfectly smooth paths and synthetic keystrokes with no dwell; people
+// don't.
+package behavior
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"janus/internal/types"
+)
+
+// Sample caps applied by Trim; anything beyond them adds nothing to the
+// analysis.
+const (
+	MaxPointer = 500
+	MaxKeys    = 100
+)
+
+// Minimum samples before a trace is scored at all.
+const (
+	minPointer = 8
+	minKeys    = 5
+)
+
+// Result is the outcome of Analyze.
+type Result struct {
+	// Score is the humanness from 0 (scripted) to 100 (human); it is only
+	// meaningful when Scored is set.
+	Score  int
+	Scored bool
+	// Pointer and Keys count the samples that were analysed.
+	Pointer int
+	Keys    int
+	// Reasons name the traits that lowered the score.
+	Reasons []string
+}
+
+func (r Result) String() string {
+	if !r.Scored {
+		return fmt.Sprintf("not enough input (%d pointer, %d key samples)", r.Pointer, r.Keys)
+	}
+	s := fmt.Sprintf("humanness %d/100 from %d pointer, %d key samples", r.Score, r.Pointer, r.Keys)
+	if len(r.Reasons) > 0 {
+		s += ": " + strings.Join(r.Reasons, ", ")
+	}
+	return s
+}
+
+// Trim caps b at MaxPointer and MaxKeys samples.
+func Trim(b *types.Behavior) {
+	if b == nil {
+		return
+	}
+	if len(b.Pointer) > MaxPointer {
+		b.Pointer = b.Pointer[:MaxPointer]
+	}
+	if len(b.Keys) > MaxKeys {
+		b.Keys = b.Keys[:MaxKeys]
+	}
+}
+
+// Analyze scores b. Pointer traces weigh more than key timings, which are
+// scarce on a challenge page; a trace with too few samples is left out.
+func Analyze(b *types.Behavior) Result {
+	var res Result
+	if b == nil {
+		return res
+	}
+	var total, weight float64
+	if len(b.Pointer) >= minPointer {
+		score, reasons := pointerScore(b.Pointer)
+		total += 0.7 * score
+		weight += 0.7
+		res.Pointer = len(b.Pointer)
+		res.Reasons = append(res.Reasons, reasons...)
+	}
+	if len(b.Keys) >= minKeys {
+		score, reasons := keyScore(b.Keys)
+		total += 0.3 * score
+		weight += 0.3
+		res.Keys = len(b.Keys)
+		res.Reasons = append(res.Reasons, reasons...)
+	}
+	if weight == 0 {
+		res.Pointer, res.Keys = len(b.Pointer), len(b.Keys)
+		return res
+	}
+	res.Scored = true
+	res.Score = int(math.Round(100 * total / weight))
+	return res
+}
+
+// pointerScore rates a trace on sample timing, velocity profile, curvature
+// and jitter, each from 0 to 1, and penalises impossible input.
+func pointerScore(points []types.PointerSample) (float64, []string) {
+	var (
+		dts, speeds     []float64
+		path, maxSpeed  float64
+		repeated        int
+		prevAngle       float64
+		haveAngle       bool
+		turns, flips    int
+		prevTurnSign    float64
+		directionalSegs int
+	)
+	for i := 1; i < len(points); i++ {
+		dx := points[i].X - points[i-1].X
+		dy := points[i].Y - points[i-1].Y
+		dt := points[i].T - points[i-1].T
+		if dt <= 0 {
+			repeated++
+			continue
+		}
+		dist := math.Hypot(dx, dy)
+		path += dist
+		dts = append(dts, dt)
+		speeds = append(speeds, dist/dt)
+		maxSpeed = math.Max(maxSpeed, dist/dt)
+		if dist == 0 {
+			continue
+		}
+		angle := math.Atan2(dy, dx)
+		directionalSegs++
+		if haveAngle {
+			turn := math.Remainder(angle-prevAngle, 2*math.Pi)
+			if math.Abs(turn) > 1e-3 {
+				turns++
+				sign := math.Copysign(1, turn)
+				if prevTurnSign != 0 && sign != prevTurnSign {
+					flips++
+				}
+				prevTurnSign = sign
+			}
+		}
+		prevAngle, haveAngle = angle, true
+	}
+	if len(dts) < 2 || path == 0 {
+		return 0, []string{"no pointer movement"}
+	}
+
+	var reasons []string
+	component := func(v float64, reason string) float64 {
+		v = clamp01(v)
+		if v < 0.5 {
+			reasons = append(reasons, reason)
+		}
+		return v
+	}
+
+	// Event timing: browsers deliver pointer events with irregular gaps;
+	// replayed traces tick like a timer.
+	timing := component(cv(dts)/0.3, "even sample timing")
+	// Velocity: hands accelerate and decelerate; interpolation doesn't.
+	speed := component(cv(speeds)/0.5, "constant speed")
+	// Curvature: a straight chord over the whole path is a script's
+	// shortest route.
+	first, last := points[0], points[len(points)-1]
+	efficiency := math.Hypot(last.X-first.X, last.Y-first.Y) / path
+	curve := component((1-efficiency)/0.1, "straight path")
+	// Jitter: tremor makes the direction of turning flip; smooth splines
+	// keep turning one way.
+	flipRate := 0.0
+	if directionalSegs > 2 {
+		flipRate = float64(flips) / float64(directionalSegs-2)
+	}
+	jitter := component(flipRate/0.2, "no jitter")
+
+	score := (timing + speed + curve + jitter) / 4
+	if float64(repeated) > 0.2*float64(len(points)-1) {
+		reasons = append(reasons, "repeated timestamps")
+		score *= 0.5
+	}
+	// 10 px/ms is faster than any flick of the wrist.
+	if maxSpeed > 10 {
+		reasons = append(reasons, "pointer jumps")
+		score *= 0.5
+	}
+	return score, reasons
+}
+
+// keyScore rates key timings on rhythm and dwell, each from 0 to 1.
+func keyScore(keys []types.KeySample) (float64, []string) {
+	var intervals, dwells []float64
+	for i, k := range keys {
+		dwells = append(dwells, k.D)
+		if i > 0 {
+			if dt := k.T - keys[i-1].T; dt > 0 {
+				intervals = append(intervals, dt)
+			}
+		}
+	}
+	if len(intervals) < 2 {
+		return 0, []string{"simultaneous keystrokes"}
+	}
+
+	var reasons []string
+	rhythm := clamp01(cv(intervals) / 0.25)
+	if rhythm < 0.5 {
+		reasons = append(reasons, "even key rhythm")
+	}
+	var dwell float64
+	if mean(dwells) < 5 {
+		// Dispatched key events have no time between down and up.
+		reasons = append(reasons, "no key dwell")
+	} else if dwell = clamp01(cv(dwells) / 0.15); dwell < 0.5 {
+		reasons = append(reasons, "constant key dwell")
+	}
+
+	score := (rhythm + dwell) / 2
+	if median(intervals) < 30 {
+		reasons = append(reasons, "superhuman typing speed")
+		score *= 0.3
+	}
+	return score, reasons
+}
+
+func mean(xs []float64) float64 {
+	var sum float64
+	for _, x := range xs {
+		sum += x
+	}
+	return sum / float64(len(xs))
+}
+
+// cv is the coefficient of variation: standard deviation over mean.
+func cv(xs []float64) float64 {
+	m := mean(xs)
+	if m == 0 {
+		return 0
+	}
+	var ss float64
+	for _, x := range xs {
+		ss += (x - m) * (x - m)
+	}
+	return math.Sqrt(ss/float64(len(xs))) / m
+}
+
+func median(xs []float64) float64 {
+	s := append([]float64(nil), xs...)
+	sort.Float64s(s)
+	return s[len(s)/2]
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
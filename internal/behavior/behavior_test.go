@@ -0,0 +1,116 @@
+package behavior
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"janus/internal/types"
+)
+
+// scripted is a replayed drag: a straight line at constant speed, one
+// sample every 16 ms, and keystrokes dispatched 10 ms apart with no dwell.
+func scripted() *types.Behavior {
+	b := &types.Behavior{}
+	for i := 0; i < 40; i++ {
+		b.Pointer = append(b.Pointer, types.PointerSample{X: float64(10 * i), Y: float64(5 * i), T: float64(16 * i)})
+	}
+	for i := 0; i < 10; i++ {
+		b.Keys = append(b.Keys, types.KeySample{T: float64(10 * i)})
+	}
+	return b
+}
+
+// human is a hand-drawn arc with tremor and irregular timing, and typing
+// with uneven rhythm and dwell.
+func human() *types.Behavior {
+	rng := rand.New(rand.NewSource(1))
+	b := &types.Behavior{}
+	t := 0.0
+	for i := 0; i < 60; i++ {
+		a := math.Pi * float64(i) / 60
+		// Ease in and out along the arc.
+		r := 200 * (1 - math.Cos(a)) / 2
+		x := r*math.Cos(a) + rng.NormFloat64()*1.5
+		y := r*math.Sin(a) + rng.NormFloat64()*1.5
+		t += 8 + rng.Float64()*20
+		b.Pointer = append(b.Pointer, types.PointerSample{X: x, Y: y, T: t})
+	}
+	t = 0
+	for i := 0; i < 12; i++ {
+		t += 90 + rng.Float64()*150
+		b.Keys = append(b.Keys, types.KeySample{T: t, D: 60 + rng.Float64()*60})
+	}
+	return b
+}
+
+func TestAnalyze(t *testing.T) {
+	bot := Analyze(scripted())
+	if !bot.Scored || bot.Score > 30 {
+		t.Errorf("scripted input scored %v", bot)
+	}
+	for _, reason := range []string{"even sample timing", "constant speed", "straight path", "no key dwell", "superhuman typing speed"} {
+		if !strings.Contains(bot.String(), reason) {
+			t.Errorf("scripted input not flagged for %s: %v", reason, bot)
+		}
+	}
+
+	person := Analyze(human())
+	if !person.Scored || person.Score < 70 {
+		t.Errorf("human input scored %v", person)
+	}
+}
+
+func TestAnalyzePenalties(t *testing.T) {
+	b := human()
+	b.Keys = nil
+	base := Analyze(b).Score
+
+	jumpy := human()
+	jumpy.Keys = nil
+	jumpy.Pointer[30].X += 5000
+	if got := Analyze(jumpy); got.Score >= base || !strings.Contains(got.String(), "pointer jumps") {
+		t.Errorf("teleporting pointer scored %v, base %d", got, base)
+	}
+
+	stamped := human()
+	stamped.Keys = nil
+	for i := range stamped.Pointer {
+		stamped.Pointer[i].T = float64(i / 4)
+	}
+	if got := Analyze(stamped); !strings.Contains(got.String(), "repeated timestamps") {
+		t.Errorf("repeated timestamps not flagged: %v", got)
+	}
+}
+
+func TestAnalyzeNeedsEnoughInput(t *testing.T) {
+	if res := Analyze(nil); res.Scored {
+		t.Error("nil behaviour scored")
+	}
+	b := scripted()
+	b.Pointer = b.Pointer[:minPointer-1]
+	b.Keys = b.Keys[:minKeys-1]
+	res := Analyze(b)
+	if res.Scored || res.Pointer != minPointer-1 || res.Keys != minKeys-1 {
+		t.Errorf("short traces: %+v", res)
+	}
+	// Keys alone are enough.
+	b = human()
+	b.Pointer = nil
+	if res := Analyze(b); !res.Scored || res.Pointer != 0 {
+		t.Errorf("keys only: %+v", res)
+	}
+}
+
+func TestTrim(t *testing.T) {
+	b := &types.Behavior{
+		Pointer: make([]types.PointerSample, MaxPointer+10),
+		Keys:    make([]types.KeySample, MaxKeys+10),
+	}
+	Trim(b)
+	if len(b.Pointer) != MaxPointer || len(b.Keys) != MaxKeys {
+		t.Errorf("Trim left %d pointer, %d key samples", len(b.Pointer), len(b.Keys))
+	}
+	Trim(nil)
+}
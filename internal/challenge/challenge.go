@@ -3,8 +3,11 @@ package challenge
 import (
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
-	"log"
+	"errors"
+	"fmt"
+	"math/big"
 	"strconv"
 	"strings"
 	"time"
@@ -13,16 +16,21 @@ import (
 	"janus/internal/types"
 )
 
-func GenerateChallenge(cfg *config.JanusConfig, isMobile bool, riskScore int, history int) (*types.Challenge, int) {
-	nonce, err := generateNonce()
-	if err != nil {
-		log.Printf("GenerateChallenge: Failed to generate nonce: %v", err)
-		return nil, 0
-	}
+// MaxDifficulty is the most leading zero bits a challenge asks for. A
+// solver needs 2^difficulty hashes on average, about a million at 20,
+// which is already slow in a phone's browser.
+const MaxDifficulty = 20
+
+// GenerateChallenge builds a proof-of-work challenge around nonce, which the
+// caller registers with the store so it can be consumed exactly once.
+// Low-risk visitors with a history of passing, or whose input looked human,
+// get difficulty 0 unless minDifficulty says otherwise. Interactive
+// challenges additionally carry a puzzle from cfg.Puzzles; its answer stays
+// on the server and is checked by CheckAnswer.
+func GenerateChallenge(cfg *config.JanusConfig, nonce string, isMobile bool, riskScore int, history int, human bool, interactive bool, minDifficulty int) (*types.Challenge, error) {
 	seed, err := generateSeed()
 	if err != nil {
-		log.Printf("GenerateChallenge: Failed to generate seed: %v", err)
-		return nil, 0
+		return nil, fmt.Errorf("challenge: generating seed: %w", err)
 	}
 	baseIterations := cfg.DesktopIterations
 	baseDifficulty := cfg.DesktopDifficulty
@@ -32,87 +40,180 @@ func GenerateChallenge(cfg *config.JanusConfig, isMobile bool, riskScore int, hi
 		baseDifficulty = cfg.MobileDifficulty
 	}
 	difficulty := baseDifficulty
-	if riskScore < 20 && history > 2 {
+	if riskScore < 20 && (history > 2 || human) {
 		difficulty = 0
 	} else if riskScore > 80 {
 		difficulty = baseDifficulty + 2
 	}
-	if riskScore > 60 {
-		if riskScore%2 == 0 {
-			challengeType = "image"
-		} else {
-			challengeType = "logic"
-		}
-	}
-	return &types.Challenge{
+	difficulty = min(max(difficulty, minDifficulty), MaxDifficulty)
+	chal := &types.Challenge{
 		Nonce:      nonce,
-		Iterations: baseIterations,
+		Iterations: iterations(baseIterations, difficulty),
 		Seed:       seed,
 		Type:       challengeType,
 		Difficulty: difficulty,
-	}, difficulty
+	}
+	if interactive {
+		if err := puzzle(chal, cfg.Puzzles); err != nil {
+			return nil, fmt.Errorf("challenge: generating %s puzzle: %w", chal.Type, err)
+		}
+	}
+	return chal, nil
+}
+
+// Puzzle types for interactive challenges.
+const (
+	PuzzleImage = "image"
+	PuzzleLogic = "logic"
+)
+
+// puzzles generate each puzzle type.
+var puzzles = map[string]func(*types.Challenge) error{
+	PuzzleImage: imagePuzzle,
+	PuzzleLogic: logicPuzzle,
+}
+
+// ValidatePuzzles checks the puzzles config option.
+func ValidatePuzzles(names []string) error {
+	for _, name := range names {
+		if puzzles[name] == nil {
+			return fmt.Errorf("challenge: unknown puzzle %q (want %s or %s)", name, PuzzleImage, PuzzleLogic)
+		}
+	}
+	return nil
+}
+
+// puzzle adds one of names, picked at random, to chal; no names means any
+// type. Both types draw their question into a picture, so the answer is
+// not in the challenge's text and stays on the server.
+func puzzle(chal *types.Challenge, names []string) error {
+	if len(names) == 0 {
+		names = []string{PuzzleImage, PuzzleLogic}
+	}
+	i, err := randInt(len(names))
+	if err != nil {
+		return err
+	}
+	chal.Type = names[i]
+	gen := puzzles[chal.Type]
+	if gen == nil {
+		return fmt.Errorf("unknown puzzle %q", chal.Type)
+	}
+	return gen(chal)
+}
+
+// codeAlphabet leaves out characters that are easily mistaken for others
+// (0/O, 1/I, 2/Z, 5/S, 8/B, G, Q, V).
+const codeAlphabet = "ACDEFHJKLMNPRTUWXY34679"
+
+// codeLength is how many characters the image puzzle asks for.
+const codeLength = 5
+
+// imagePuzzle asks the visitor to type the characters in a distorted
+// picture.
+func imagePuzzle(chal *types.Challenge) error {
+	code := make([]byte, codeLength)
+	for i := range code {
+		n, err := randInt(len(codeAlphabet))
+		if err != nil {
+			return err
+		}
+		code[i] = codeAlphabet[n]
+	}
+	img, err := renderPNG(string(code))
+	if err != nil {
+		return err
+	}
+	chal.Prompt = "Type the characters shown in the picture."
+	chal.Image = img
+	chal.Answer = string(code)
+	return nil
+}
+
+// logicPuzzle asks for the sum of two small numbers drawn in a picture.
+func logicPuzzle(chal *types.Challenge) error {
+	a, err := randInt(20)
+	if err != nil {
+		return err
+	}
+	b, err := randInt(20)
+	if err != nil {
+		return err
+	}
+	img, err := renderPNG(fmt.Sprintf("%d + %d = ?", a+1, b+1))
+	if err != nil {
+		return err
+	}
+	chal.Prompt = "Type the result of the sum shown in the picture."
+	chal.Image = img
+	chal.Answer = strconv.Itoa(a + b + 2)
+	return nil
+}
+
+// CheckAnswer reports whether answer solves chal's puzzle, ignoring case
+// and surrounding space. Challenges without a puzzle accept any answer.
+func CheckAnswer(chal *types.Challenge, answer string) bool {
+	if chal.Answer == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.ToUpper(strings.TrimSpace(answer))), []byte(chal.Answer)) == 1
+}
+
+func randInt(n int) (int, error) {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(v.Int64()), nil
+}
+
+// iterations is the attempt budget for a challenge: at least base, and
+// enough that a solver runs out before finding a proof less than once in
+// e^8 (about 3000) challenges.
+func iterations(base, difficulty int) int {
+	return max(base, 8<<difficulty)
 }
 
-func VerifyChallenge(proof, expectedNonce, expectedClientIP, expectedSeed string, isMobile bool, canvasHash string, cfg *config.JanusConfig) bool {
+// VerifyChallenge checks proof against the issued challenge chal and says
+// why it was rejected.
+func VerifyChallenge(proof string, chal *types.Challenge, expectedClientIP string, isMobile bool, canvasHash string) error {
 	parts := strings.Split(proof, "|")
 	if isMobile {
 		if len(parts) != 5 {
-			log.Printf("VerifyChallenge: Invalid proof length for mobile: got %d, expected 5", len(parts))
-			return false
+			return fmt.Errorf("invalid proof length for mobile: got %d, expected 5", len(parts))
 		}
 	} else {
 		if len(parts) != 6 {
-			log.Printf("VerifyChallenge: Invalid proof length for desktop: got %d, expected 6", len(parts))
-			return false
+			return fmt.Errorf("invalid proof length for desktop: got %d, expected 6", len(parts))
 		}
 		if parts[5] != canvasHash {
-			log.Printf("VerifyChallenge: Canvas hash mismatch")
-			return false
+			return errors.New("canvas hash mismatch")
 		}
 	}
 	nonce, iteration, timestamp, clientIP, seed := parts[0], parts[1], parts[2], parts[3], parts[4]
 
-	if nonce != expectedNonce || clientIP != expectedClientIP || seed != expectedSeed {
-		log.Printf("VerifyChallenge: Component mismatch")
-		return false
-	}
-	log.Printf("DEBUG: Canvas hash from PROOF  : %s", parts[5])
-	log.Printf("DEBUG: Canvas hash from STORAGE: %s", canvasHash)
-	log.Printf("DEBUG: Are they equal? %v", parts[5] == canvasHash)
-
-	if !isMobile && parts[5] != canvasHash {
-		log.Printf("VerifyChallenge: Canvas hash mismatch")
-		return false
+	if nonce != chal.Nonce || clientIP != expectedClientIP || seed != chal.Seed {
+		return errors.New("component mismatch")
 	}
 
 	iter, err := strconv.Atoi(iteration)
-	maxIter := cfg.MobileIterations
-	if !isMobile {
-		maxIter = cfg.DesktopIterations
-	}
-	if err != nil || iter < 0 || iter > maxIter {
-		log.Printf("VerifyChallenge: Invalid iteration %s", iteration)
-		return false
+	if err != nil || iter < 0 || iter >= chal.Iterations {
+		return fmt.Errorf("invalid iteration %s", iteration)
 	}
 
 	ts, err := time.Parse(time.RFC3339, timestamp)
 	if err != nil || time.Since(ts) > 5*time.Minute || ts.After(time.Now().Add(1*time.Minute)) {
-		log.Printf("VerifyChallenge: Invalid timestamp: %s", timestamp)
-		return false
+		return fmt.Errorf("invalid timestamp %s", timestamp)
 	}
 
-	zeroBits := cfg.MobileDifficulty
-	if !isMobile {
-		zeroBits = cfg.DesktopDifficulty
-	}
+	zeroBits := chal.Difficulty
 
 	hash := sha256.Sum256([]byte(proof))
 	if !hasLeadingZeroBits(hash[:], zeroBits) {
-		log.Printf("VerifyChallenge: Hash does not have %d leading zero bits", zeroBits)
-		return false
+		return fmt.Errorf("hash does not have %d leading zero bits", zeroBits)
 	}
 
-	return true
+	return nil
 }
 
 func hasLeadingZeroBits(hash []byte, zeroBits int) bool {
@@ -130,14 +231,6 @@ func hasLeadingZeroBits(hash []byte, zeroBits int) bool {
 	return true
 }
 
-func generateNonce() (string, error) {
-	b := make([]byte, 16)
-	if _, err := rand.Read(b); err != nil {
-		return "", err
-	}
-	return base64.StdEncoding.EncodeToString(b), nil
-}
-
 func generateSeed() (string, error) {
 	b := make([]byte, 8)
 	if _, err := rand.Read(b); err != nil {
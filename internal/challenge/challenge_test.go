@@ -0,0 +1,211 @@
+package challenge
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"janus/internal/config"
+	"janus/internal/types"
+)
+
+func proof(parts ...string) string {
+	return strings.Join(parts, "|")
+}
+
+func generate(t *testing.T, cfg *config.JanusConfig, nonce string, isMobile bool, riskScore int, history int, human bool, interactive bool, minDifficulty int) *types.Challenge {
+	t.Helper()
+	chal, err := GenerateChallenge(cfg, nonce, isMobile, riskScore, history, human, interactive, minDifficulty)
+	if err != nil {
+		t.Fatalf("GenerateChallenge: %v", err)
+	}
+	return chal
+}
+
+func TestVerifyChallenge(t *testing.T) {
+	chal := &types.Challenge{Nonce: "n", Seed: "s", Iterations: 10}
+	now := time.Now().UTC().Format(time.RFC3339)
+	cases := []struct {
+		name   string
+		proof  string
+		mobile bool
+		want   bool
+	}{
+		{"desktop", proof("n", "0", now, "1.2.3.4", "s", "canvas"), false, true},
+		{"mobile", proof("n", "0", now, "1.2.3.4", "s"), true, true},
+		{"mobile with canvas", proof("n", "0", now, "1.2.3.4", "s", "canvas"), true, false},
+		{"desktop without canvas", proof("n", "0", now, "1.2.3.4", "s"), false, false},
+		{"wrong canvas", proof("n", "0", now, "1.2.3.4", "s", "other"), false, false},
+		{"wrong nonce", proof("x", "0", now, "1.2.3.4", "s", "canvas"), false, false},
+		{"wrong ip", proof("n", "0", now, "5.6.7.8", "s", "canvas"), false, false},
+		{"stale", proof("n", "0", time.Now().Add(-time.Hour).UTC().Format(time.RFC3339), "1.2.3.4", "s", "canvas"), false, false},
+		{"bad iteration", proof("n", "-1", now, "1.2.3.4", "s", "canvas"), false, false},
+		{"iteration past budget", proof("n", "10", now, "1.2.3.4", "s", "canvas"), false, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := VerifyChallenge(c.proof, chal, "1.2.3.4", c.mobile, "canvas"); (err == nil) != c.want {
+				t.Errorf("VerifyChallenge(%q) = %v, want ok %v", c.proof, err, c.want)
+			}
+		})
+	}
+}
+
+func TestHasLeadingZeroBits(t *testing.T) {
+	hash := []byte{0x00, 0x0f, 0xff}
+	for bits, want := range map[int]bool{0: true, 8: true, 12: true, 13: false, 16: false} {
+		if got := hasLeadingZeroBits(hash, bits); got != want {
+			t.Errorf("hasLeadingZeroBits(%d) = %v, want %v", bits, got, want)
+		}
+	}
+}
+
+func TestGenerateChallengeDifficulty(t *testing.T) {
+	cfg := config.DefaultConfig()
+	for _, c := range []struct {
+		min, want int
+	}{
+		{0, cfg.MobileDifficulty},
+		{10, 10},
+		{MaxDifficulty + 5, MaxDifficulty},
+	} {
+		chal := generate(t, cfg, "n", true, 50, 0, false, false, c.min)
+		if chal.Difficulty != c.want {
+			t.Errorf("min %d: difficulty %d, want %d", c.min, chal.Difficulty, c.want)
+		}
+		if chal.Iterations < cfg.MobileIterations || chal.Iterations < 8<<chal.Difficulty {
+			t.Errorf("min %d: %d iterations for difficulty %d", c.min, chal.Iterations, chal.Difficulty)
+		}
+	}
+}
+
+// TestRouteDifficultySolvable solves a raised mobile challenge the way
+// sensor.js does, within the iterations it is given.
+func TestRouteDifficultySolvable(t *testing.T) {
+	chal := generate(t, config.DefaultConfig(), "n", true, 50, 0, false, false, 10)
+	ts := time.Now().UTC().Format(time.RFC3339)
+	for i := 0; i < chal.Iterations; i++ {
+		p := proof(chal.Nonce, strconv.Itoa(i), ts, "1.2.3.4", chal.Seed)
+		sum := sha256.Sum256([]byte(p))
+		if hasLeadingZeroBits(sum[:], chal.Difficulty) {
+			if err := VerifyChallenge(p, chal, "1.2.3.4", true, ""); err != nil {
+				t.Fatalf("solved proof %q rejected: %v", p, err)
+			}
+			return
+		}
+	}
+	t.Fatalf("no proof within %d iterations at difficulty %d", chal.Iterations, chal.Difficulty)
+}
+
+func TestPuzzles(t *testing.T) {
+	for _, kind := range []string{PuzzleImage, PuzzleLogic} {
+		cfg := config.DefaultConfig()
+		cfg.Puzzles = []string{kind}
+		chal := generate(t, cfg, "n", false, 50, 0, false, true, 0)
+		if chal.Type != kind || chal.Prompt == "" || chal.Answer == "" {
+			t.Fatalf("%s: got %+v, want a %s puzzle", kind, chal, kind)
+		}
+		// The answer is only in the picture's pixels.
+		if strings.Contains(chal.Prompt, chal.Answer) {
+			t.Errorf("%s: prompt %q gives the answer away", kind, chal.Prompt)
+		}
+		img := decodeImage(t, chal.Image)
+		if b := img.Bounds(); b.Dy() != imageHeight || b.Dx() < cellWidth*len(chal.Answer) {
+			t.Errorf("%s: picture is %v", kind, b)
+		}
+		if !CheckAnswer(chal, " "+strings.ToLower(chal.Answer)+" ") {
+			t.Errorf("%s: right answer rejected", kind)
+		}
+		if CheckAnswer(chal, "") || CheckAnswer(chal, chal.Answer+"0") {
+			t.Errorf("%s: wrong answer accepted", kind)
+		}
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.Puzzles = []string{PuzzleLogic}
+	chal := generate(t, cfg, "n", false, 50, 0, false, true, 0)
+	if n, err := strconv.Atoi(chal.Answer); err != nil || n < 2 || n > 40 {
+		t.Errorf("logic puzzle answer %q, want a sum of two numbers from 1 to 20", chal.Answer)
+	}
+	cfg.Puzzles = []string{PuzzleImage}
+	chal = generate(t, cfg, "n", false, 50, 0, false, true, 0)
+	if len(chal.Answer) != codeLength || strings.Trim(chal.Answer, codeAlphabet) != "" {
+		t.Errorf("image puzzle answer %q, want %d characters from %s", chal.Answer, codeLength, codeAlphabet)
+	}
+
+	if plain := generate(t, config.DefaultConfig(), "n", false, 50, 0, false, false, 0); !CheckAnswer(plain, "") || plain.Image != "" {
+		t.Error("challenge without a puzzle needs an answer")
+	}
+}
+
+// TestPuzzleChoice checks the type is picked at random, not from the risk
+// score.
+func TestPuzzleChoice(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 64; i++ {
+		seen[generate(t, config.DefaultConfig(), "n", false, 50, 0, false, true, 0).Type] = true
+	}
+	if !seen[PuzzleImage] || !seen[PuzzleLogic] {
+		t.Errorf("puzzle types for one risk score: %v, want both", seen)
+	}
+}
+
+func TestValidatePuzzles(t *testing.T) {
+	if err := ValidatePuzzles([]string{PuzzleImage, PuzzleLogic}); err != nil {
+		t.Error(err)
+	}
+	if err := ValidatePuzzles([]string{"slider"}); err == nil {
+		t.Error("unknown puzzle accepted")
+	}
+}
+
+func TestRenderPNGDiffers(t *testing.T) {
+	a, err := renderPNG("ACDEF")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := renderPNG("ACDEF")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Error("the same text rendered to the same picture twice")
+	}
+	for ch := range glyphs {
+		if strings.ContainsRune(codeAlphabet, ch) {
+			continue
+		}
+		if !strings.ContainsRune("0123456789+=? ", ch) {
+			t.Errorf("glyph %q is never drawn", ch)
+		}
+	}
+	for _, ch := range codeAlphabet {
+		if _, ok := glyphs[ch]; !ok {
+			t.Errorf("no glyph for %q", ch)
+		}
+	}
+}
+
+// decodeImage decodes a data: URL holding a PNG.
+func decodeImage(t *testing.T, url string) image.Image {
+	t.Helper()
+	data, ok := strings.CutPrefix(url, "data:image/png;base64,")
+	if !ok {
+		t.Fatalf("picture %.40q is not a PNG data: URL", url)
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
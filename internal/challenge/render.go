@@ -0,0 +1,151 @@
+package challenge
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+)
+
+// glyphs is a 5x7 bitmap font covering the characters puzzles draw.
+var glyphs = map[rune][7]string{
+	'0': {".###.", "#...#", "#..##", "#.#.#", "##..#", "#...#", ".###."},
+	'1': {"..#..", ".##..", "..#..", "..#..", "..#..", "..#..", ".###."},
+	'2': {".###.", "#...#", "....#", "...#.", "..#..", ".#...", "#####"},
+	'3': {"#####", "...#.", "..#..", "...#.", "....#", "#...#", ".###."},
+	'4': {"...#.", "..##.", ".#.#.", "#..#.", "#####", "...#.", "...#."},
+	'5': {"#####", "#....", "####.", "....#", "....#", "#...#", ".###."},
+	'6': {"..##.", ".#...", "#....", "####.", "#...#", "#...#", ".###."},
+	'7': {"#####", "....#", "...#.", "..#..", ".#...", ".#...", ".#..."},
+	'8': {".###.", "#...#", "#...#", ".###.", "#...#", "#...#", ".###."},
+	'9': {".###.", "#...#", "#...#", ".####", "....#", "...#.", ".##.."},
+	'A': {".###.", "#...#", "#...#", "#####", "#...#", "#...#", "#...#"},
+	'C': {".###.", "#...#", "#....", "#....", "#....", "#...#", ".###."},
+	'D': {"####.", "#...#", "#...#", "#...#", "#...#", "#...#", "####."},
+	'E': {"#####", "#....", "#....", "####.", "#....", "#....", "#####"},
+	'F': {"#####", "#....", "#....", "####.", "#....", "#....", "#...."},
+	'H': {"#...#", "#...#", "#...#", "#####", "#...#", "#...#", "#...#"},
+	'J': {"..###", "...#.", "...#.", "...#.", "...#.", "#..#.", ".##.."},
+	'K': {"#...#", "#..#.", "#.#..", "##...", "#.#..", "#..#.", "#...#"},
+	'L': {"#....", "#....", "#....", "#....", "#....", "#....", "#####"},
+	'M': {"#...#", "##.##", "#.#.#", "#.#.#", "#...#", "#...#", "#...#"},
+	'N': {"#...#", "#...#", "##..#", "#.#.#", "#..##", "#...#", "#...#"},
+	'P': {"####.", "#...#", "#...#", "####.", "#....", "#....", "#...."},
+	'R': {"####.", "#...#", "#...#", "####.", "#.#..", "#..#.", "#...#"},
+	'T': {"#####", "..#..", "..#..", "..#..", "..#..", "..#..", "..#.."},
+	'U': {"#...#", "#...#", "#...#", "#...#", "#...#", "#...#", ".###."},
+	'W': {"#...#", "#...#", "#...#", "#.#.#", "#.#.#", "#.#.#", ".#.#."},
+	'X': {"#...#", "#...#", ".#.#.", "..#..", ".#.#.", "#...#", "#...#"},
+	'Y': {"#...#", "#...#", ".#.#.", "..#..", "..#..", "..#..", "..#.."},
+	'+': {".....", "..#..", "..#..", "#####", "..#..", "..#..", "....."},
+	'=': {".....", ".....", "#####", ".....", "#####", ".....", "....."},
+	'?': {".###.", "#...#", "....#", "...#.", "..#..", ".....", "..#.."},
+	' ': {".....", ".....", ".....", ".....", ".....", ".....", "....."},
+}
+
+// Picture layout, in pixels.
+const (
+	cellWidth   = 34
+	imageHeight = 64
+	imageMargin = 12
+)
+
+// renderPNG draws text as a distorted PNG and returns it as a data: URL.
+// Each character is scaled, rotated and shifted at random, the whole line
+// is bent along a random wave and crossed with noise, so the text is only
+// in the pixels and differs on every call.
+func renderPNG(text string) (string, error) {
+	w := 2*imageMargin + cellWidth*len(text)
+	img := image.NewNRGBA(image.Rect(0, 0, w, imageHeight))
+	bg := color.NRGBA{uint8(225 + mustRand(30)), uint8(225 + mustRand(30)), uint8(225 + mustRand(30)), 255}
+	for y := 0; y < imageHeight; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, bg)
+		}
+	}
+
+	amplitude := 2 + float64(mustRand(4))
+	period := 20 + float64(mustRand(20))
+	phase := float64(mustRand(628)) / 100
+	for i, ch := range text {
+		g, ok := glyphs[ch]
+		if !ok || ch == ' ' {
+			continue
+		}
+		scale := 4 + float64(mustRand(15))/10
+		angle := float64(mustRand(60)-30) / 100
+		sin, cos := math.Sincos(angle)
+		cx := float64(imageMargin+cellWidth*i+cellWidth/2) + float64(mustRand(7)-3)
+		cy := float64(imageHeight/2) + float64(mustRand(9)-4)
+		ink := darkColor()
+		for row, line := range g {
+			for col, c := range line {
+				if c != '#' {
+					continue
+				}
+				// Glyph pixel centre relative to the glyph centre.
+				fx := (float64(col) - 2) * scale
+				fy := (float64(row) - 3) * scale
+				x := cx + fx*cos - fy*sin
+				y := cy + fx*sin + fy*cos
+				y += amplitude * math.Sin(x/period+phase)
+				fill(img, x, y, scale*0.55, ink)
+			}
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		line(img, mustRand(w), mustRand(imageHeight), mustRand(w), mustRand(imageHeight), darkColor())
+	}
+	for i := 0; i < w*imageHeight/40; i++ {
+		img.SetNRGBA(mustRand(w), mustRand(imageHeight), darkColor())
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// fill paints the square of half-width r around (x, y).
+func fill(img *image.NRGBA, x, y, r float64, c color.NRGBA) {
+	for py := int(math.Floor(y - r)); py <= int(math.Ceil(y+r)); py++ {
+		for px := int(math.Floor(x - r)); px <= int(math.Ceil(x+r)); px++ {
+			if image.Pt(px, py).In(img.Rect) {
+				img.SetNRGBA(px, py, c)
+			}
+		}
+	}
+}
+
+// line draws a one-pixel line from (x0, y0) to (x1, y1).
+func line(img *image.NRGBA, x0, y0, x1, y1 int, c color.NRGBA) {
+	steps := max(abs(x1-x0), abs(y1-y0), 1)
+	for i := 0; i <= steps; i++ {
+		img.SetNRGBA(x0+(x1-x0)*i/steps, y0+(y1-y0)*i/steps, c)
+	}
+}
+
+func darkColor() color.NRGBA {
+	return color.NRGBA{uint8(mustRand(140)), uint8(mustRand(140)), uint8(mustRand(140)), 255}
+}
+
+// mustRand is randInt for picture noise, where crypto/rand failing (which
+// it does not on supported platforms) would only make the picture plainer.
+func mustRand(n int) int {
+	v, err := randInt(n)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
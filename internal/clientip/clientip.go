@@ -0,0 +1,205 @@
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const SourceRemoteAddr = "remote_addr"
+
+// Info is the outcome of resolving a request's client address.
+type Info struct {
+	// IP is the resolved client address.
+	IP string
+	// Peer is the address of the immediate TCP peer.
+	Peer string
+	// Chain lists the trusted proxies the request passed through, nearest
+	// first. It is empty when the peer is not a trusted proxy.
+	Chain []string
+	// Source is the header the IP was taken from, or SourceRemoteAddr.
+	Source string
+}
+
+// TrustedPeer reports whether the immediate peer is a trusted proxy.
+func (i *Info) TrustedPeer() bool {
+	return len(i.Chain) > 0
+}
+
+// Resolver derives client addresses, believing forwarding headers only
+// when they were added by a trusted proxy.
+type Resolver struct {
+	trusted []netip.Prefix
+	headers []string
+}
+
+// New builds a Resolver. trustedProxies holds addresses or CIDRs; headers
+// are consulted in order, e.g. "X-Forwarded-For", "Forwarded",
+// "CF-Connecting-IP" or "X-Real-IP".
+func New(trustedProxies, headers []string) (*Resolver, error) {
+	res := &Resolver{}
+	for _, e := range trustedProxies {
+		if p, err := netip.ParsePrefix(e); err == nil {
+			res.trusted = append(res.trusted, p.Masked())
+		} else if a, err := netip.ParseAddr(e); err == nil {
+			a = a.Unmap()
+			res.trusted = append(res.trusted, netip.PrefixFrom(a, a.BitLen()))
+		} else {
+			return nil, fmt.Errorf("clientip: invalid trusted proxy %q", e)
+		}
+	}
+	for _, h := range headers {
+		res.headers = append(res.headers, http.CanonicalHeaderKey(h))
+	}
+	return res, nil
+}
+
+func (res *Resolver) isTrusted(a netip.Addr) bool {
+	for _, p := range res.trusted {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve determines the client address of r.
+func (res *Resolver) Resolve(r *http.Request) *Info {
+	peer, ok := parseHostPort(r.RemoteAddr)
+	if !ok {
+		return &Info{IP: "unknown", Peer: r.RemoteAddr, Source: SourceRemoteAddr}
+	}
+	info := &Info{IP: peer.String(), Peer: peer.String(), Source: SourceRemoteAddr}
+	if !res.isTrusted(peer) {
+		return info
+	}
+
+	for _, h := range res.headers {
+		var hops []string
+		switch h {
+		case "X-Forwarded-For":
+			hops = splitList(r.Header.Values(h))
+		case "Forwarded":
+			hops = forwardedFor(r.Header.Values(h))
+		default:
+			if v := strings.TrimSpace(r.Header.Get(h)); v != "" {
+				hops = []string{v}
+			}
+		}
+		if len(hops) == 0 {
+			continue
+		}
+
+		// Walk right to left: every hop added by a trusted proxy is part of
+		// the chain, the first untrusted one is the client.
+		chain := []string{peer.String()}
+		client := ""
+		for i := len(hops) - 1; i >= 0; i-- {
+			a, ok := parseHop(hops[i])
+			if !ok {
+				break
+			}
+			if !res.isTrusted(a) || i == 0 {
+				client = a.String()
+				break
+			}
+			chain = append(chain, a.String())
+		}
+		if client == "" {
+			// Garbage after trusted hops: the nearest trusted proxy is the
+			// best address we have.
+			client = chain[len(chain)-1]
+			chain = chain[:len(chain)-1]
+		}
+		info.IP = client
+		info.Chain = chain
+		info.Source = h
+		return info
+	}
+	return info
+}
+
+// parseHostPort parses a RemoteAddr-style "host:port" (or bare host),
+// handling bracketed IPv6 and zones.
+func parseHostPort(s string) (netip.Addr, bool) {
+	host := s
+	if h, _, err := net.SplitHostPort(s); err == nil {
+		host = h
+	}
+	return parseAddr(host)
+}
+
+// parseHop parses one forwarding-header entry, which may carry a port.
+func parseHop(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if a, ok := parseAddr(s); ok {
+		return a, true
+	}
+	return parseHostPort(s)
+}
+
+func parseAddr(s string) (netip.Addr, bool) {
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return a.Unmap().WithZone(""), true
+}
+
+func splitList(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				out = append(out, part)
+			}
+		}
+	}
+	return out
+}
+
+// forwardedFor extracts the for= parameters of RFC 7239 Forwarded headers
+// in order. Obfuscated and "unknown" identifiers are kept so the walk stops
+// at them.
+func forwardedFor(values []string) []string {
+	var out []string
+	for _, elem := range splitList(values) {
+		for _, pair := range strings.Split(elem, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || !strings.EqualFold(k, "for") {
+				continue
+			}
+			out = append(out, strings.Trim(v, `"`))
+		}
+	}
+	return out
+}
+
+type contextKey struct{}
+
+// WithInfo attaches info to ctx.
+func WithInfo(ctx context.Context, info *Info) context.Context {
+	return context.WithValue(ctx, contextKey{}, info)
+}
+
+// FromContext returns the resolved client address info, if any.
+func FromContext(ctx context.Context) (*Info, bool) {
+	info, ok := ctx.Value(contextKey{}).(*Info)
+	return info, ok
+}
+
+// FromRequest returns the resolved client IP for r, falling back to the TCP
+// peer when no resolver has run.
+func FromRequest(r *http.Request) string {
+	if info, ok := FromContext(r.Context()); ok {
+		return info.IP
+	}
+	if a, ok := parseHostPort(r.RemoteAddr); ok {
+		return a.String()
+	}
+	return "unknown"
+}
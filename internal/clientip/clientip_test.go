@@ -0,0 +1,79 @@
+package clientip
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	res, err := New([]string{"10.0.0.0/8", "2001:db8::1"}, []string{"x-forwarded-for", "Forwarded", "CF-Connecting-IP"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		ip      string
+		chain   []string
+		source  string
+	}{
+		{"untrusted peer's headers ignored", "192.0.2.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.5"},
+			"192.0.2.1", nil, SourceRemoteAddr},
+		{"trusted chain", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.5, 10.0.0.2"},
+			"203.0.113.5", []string{"10.0.0.1", "10.0.0.2"}, "X-Forwarded-For"},
+		{"spoofed leftmost hop", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.1, 203.0.113.5"},
+			"203.0.113.5", []string{"10.0.0.1"}, "X-Forwarded-For"},
+		{"only trusted hops", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"},
+			"10.0.0.3", []string{"10.0.0.1", "10.0.0.2"}, "X-Forwarded-For"},
+		{"hop with port", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.5:5555"},
+			"203.0.113.5", []string{"10.0.0.1"}, "X-Forwarded-For"},
+		{"garbage hop", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "not-an-ip"},
+			"10.0.0.1", []string{}, "X-Forwarded-For"},
+		{"Forwarded", "[2001:db8::1]:443", map[string]string{"Forwarded": `for="[2001:db8::5]:4711";proto=https`},
+			"2001:db8::5", []string{"2001:db8::1"}, "Forwarded"},
+		{"Forwarded unknown", "10.0.0.1:1234", map[string]string{"Forwarded": "for=unknown"},
+			"10.0.0.1", []string{}, "Forwarded"},
+		{"single-value header", "10.0.0.1:1234", map[string]string{"CF-Connecting-IP": "203.0.113.7"},
+			"203.0.113.7", []string{"10.0.0.1"}, "Cf-Connecting-Ip"},
+		{"header order", "10.0.0.1:1234", map[string]string{"CF-Connecting-IP": "203.0.113.7", "X-Forwarded-For": "203.0.113.5"},
+			"203.0.113.5", []string{"10.0.0.1"}, "X-Forwarded-For"},
+		{"mapped peer", "[::ffff:10.0.0.1]:1234", map[string]string{"X-Forwarded-For": "203.0.113.5"},
+			"203.0.113.5", []string{"10.0.0.1"}, "X-Forwarded-For"},
+		{"trusted peer without headers", "10.0.0.1:1234", nil,
+			"10.0.0.1", nil, SourceRemoteAddr},
+		{"bad remote addr", "pipe", nil, "unknown", nil, SourceRemoteAddr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			info := res.Resolve(r)
+			if info.IP != tt.ip || info.Source != tt.source || !reflect.DeepEqual(info.Chain, tt.chain) {
+				t.Errorf("Resolve = %+v, want IP %s, chain %v, source %s", info, tt.ip, tt.chain, tt.source)
+			}
+		})
+	}
+}
+
+func TestNewRejectsBadProxy(t *testing.T) {
+	if _, err := New([]string{"10.0.0.0/33"}, nil); err == nil {
+		t.Error("New accepted an invalid CIDR")
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "[::ffff:192.0.2.1]:1234"
+	if got := FromRequest(r); got != "192.0.2.1" {
+		t.Errorf("FromRequest without a resolver = %s, want the peer", got)
+	}
+	r = r.WithContext(WithInfo(r.Context(), &Info{IP: "203.0.113.5"}))
+	if got := FromRequest(r); got != "203.0.113.5" {
+		t.Errorf("FromRequest = %s, want the resolved IP", got)
+	}
+}
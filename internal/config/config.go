@@ -1,8 +1,8 @@
 package config
 
 import (
-	"log"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -19,10 +19,256 @@ type JanusConfig struct {
 	SuspicionThreshold int            `yaml:"suspicion_threshold"`
 	SuspicionWeights   map[string]int `yaml:"suspicion_weights"`
 	RedisAddr          string         `yaml:"redis_addr"`
-	RateLimit          struct {
-		RequestsPerMinute int `yaml:"requests_per_minute"`
-		Burst             int `yaml:"burst"`
-	} `yaml:"rate_limit"`
+	// StoreBackend holds sessions, nonces, challenges, fingerprints,
+	// counters and reputation: "redis" (shared by all replicas), "memory",
+	// or "bolt" (an on-disk file at StorePath for single-node installs).
+	// StoreTimeout bounds each Redis operation.
+	StoreBackend string        `yaml:"store_backend"`
+	StorePath    string        `yaml:"store_path"`
+	StoreTimeout time.Duration `yaml:"store_timeout"`
+	// RedisFailureMode decides what happens while Redis is down: "open"
+	// skips rate limits and challenges, "closed" answers protected requests
+	// and challenges with 503, and "local-fallback" keeps all state in
+	// per-replica memory. Redis counts as down after RedisBreakerFailures
+	// failed operations in a row, or from startup if it does not answer
+	// then, and is pinged every RedisProbeInterval until it answers.
+	RedisFailureMode     string        `yaml:"redis_failure_mode"`
+	RedisBreakerFailures int           `yaml:"redis_breaker_failures"`
+	RedisProbeInterval   time.Duration `yaml:"redis_probe_interval"`
+	// ChallengeMaxAttempts caps verify attempts per visitor session within
+	// ChallengeAttemptWindow; 0 disables the cap. Every attempt consumes
+	// its challenge.
+	ChallengeMaxAttempts   int           `yaml:"challenge_max_attempts"`
+	ChallengeAttemptWindow time.Duration `yaml:"challenge_attempt_window"`
+	// Puzzles lists the puzzles interactive challenges pick from at
+	// random: "image" (characters in a distorted picture) and "logic" (a
+	// sum drawn in one). Empty means both.
+	Puzzles []string `yaml:"puzzles"`
+	// Fingerprints are keyed by the visitor's challenge session and kept
+	// for FingerprintTTL; the memory backend holds at most
+	// FingerprintMaxEntries, evicting the least recently used.
+	FingerprintTTL        time.Duration `yaml:"fingerprint_ttl"`
+	FingerprintMaxEntries int           `yaml:"fingerprint_max_entries"`
+	// TokenTTL is the sliding lifetime of a janus_token: tokens are
+	// re-issued while the visitor stays active, but never past
+	// TokenMaxLifetime after the challenge was solved.
+	TokenTTL         time.Duration `yaml:"token_ttl"`
+	TokenMaxLifetime time.Duration `yaml:"token_max_lifetime"`
+	// TrustedProxies (addresses or CIDRs) are the only peers whose
+	// ClientIPHeaders are believed. Headers are tried in order.
+	TrustedProxies  []string `yaml:"trusted_proxies"`
+	ClientIPHeaders []string `yaml:"client_ip_headers"`
+	// TLSFingerprintDB is the labelled JA3/JA4 database, re-read every
+	// TLSFingerprintReload when it changes.
+	TLSFingerprintDB     string          `yaml:"tls_fingerprint_db"`
+	TLSFingerprintReload time.Duration   `yaml:"tls_fingerprint_reload"`
+	RateLimit            RateLimitConfig `yaml:"rate_limit"`
+	// Routes apply per-path policies; the first matching route wins.
+	Routes        []RouteConfig       `yaml:"routes"`
+	Proxy         ProxyConfig         `yaml:"proxy"`
+	ProxyProtocol ProxyProtocolConfig `yaml:"proxy_protocol"`
+	Detectors     []DetectorConfig    `yaml:"detectors"`
+	Debug         DebugConfig         `yaml:"debug"`
+	Admin         AdminConfig         `yaml:"admin"`
+	Signing       SigningConfig       `yaml:"signing"`
+	TokenBinding  TokenBindingConfig  `yaml:"token_binding"`
+	Cookie        CookieConfig        `yaml:"cookie"`
+	Exchange      ExchangeConfig      `yaml:"exchange"`
+	Monitoring    MonitoringConfig    `yaml:"monitoring"`
+	Behavior      BehaviorConfig      `yaml:"behavior"`
+	// Actions is the ladder of responses for unverified visitors; the band
+	// with the highest MinScore not above the score applies.
+	Actions []ActionBand `yaml:"actions"`
+}
+
+// RateLimitConfig allows RequestsPerMinute requests per client IP on
+// average, with up to Burst more at once. Keys add limits on other
+// dimensions; a request must pass all of them.
+type RateLimitConfig struct {
+	RequestsPerMinute int                  `yaml:"requests_per_minute"`
+	Burst             int                  `yaml:"burst"`
+	Keys              []RateLimitKeyConfig `yaml:"keys"`
+}
+
+// RateLimitKeyConfig limits requests sharing a Key: "ip", "subnet" (the
+// IPv4Prefix or IPv6Prefix network), "asn", "ja3", "ja4", "token" (the
+// janus_token ID), "fingerprint" (a hash of JA4, User-Agent and language
+// headers) or "header:<Name>". Requests without a value for the key, such
+// as those without a token, are not limited by it.
+type RateLimitKeyConfig struct {
+	Key               string `yaml:"key"`
+	RequestsPerMinute int    `yaml:"requests_per_minute"`
+	Burst             int    `yaml:"burst"`
+	IPv4Prefix        int    `yaml:"ipv4_prefix"`
+	IPv6Prefix        int    `yaml:"ipv6_prefix"`
+}
+
+// RouteConfig is a policy for requests whose path matches Path ("*" is one
+// segment, a final "**" any number) and, when set, one of Methods and
+// Hosts. Bypass serves them without rate limits or challenges. Otherwise
+// RateLimit applies on top of the global limit, MinAction is the least an
+// unverified visitor gets, and Difficulty is the least proof-of-work
+// difficulty of challenges started there.
+type RouteConfig struct {
+	Name       string           `yaml:"name"`
+	Path       string           `yaml:"path"`
+	Methods    []string         `yaml:"methods"`
+	Hosts      []string         `yaml:"hosts"`
+	Bypass     bool             `yaml:"bypass"`
+	RateLimit  *RateLimitConfig `yaml:"rate_limit"`
+	MinAction  string           `yaml:"min_action"`
+	Difficulty int              `yaml:"difficulty"`
+}
+
+// ActionBand maps a score band to an action: allow, invisible,
+// interactive, block or tarpit. Status and Template default per action;
+// Delay applies to tarpit only.
+type ActionBand struct {
+	Action   string        `yaml:"action"`
+	MinScore int           `yaml:"min_score"`
+	Status   int           `yaml:"status"`
+	Template string        `yaml:"template"`
+	Delay    time.Duration `yaml:"delay"`
+}
+
+// MonitoringConfig watches verified sessions. A session that views
+// MaxPassivePages pages without ever scrolling or moving the mouse
+// naturally is treated as automated and gets Action: "rechallenge" (drop the
+// token and show an interactive challenge) or "revoke" (drop it and block).
+// It is off by default: only pages that load /janus/telemetry.js report
+// scrolling and mouse movement, and without it every session looks passive.
+type MonitoringConfig struct {
+	Enabled         bool   `yaml:"enabled"`
+	MaxPassivePages int    `yaml:"max_passive_pages"`
+	Action          string `yaml:"action"`
+	// PathHistory is how many recent paths a session keeps.
+	PathHistory int `yaml:"path_history"`
+}
+
+// BehaviorConfig sets the humanness cut-offs (0-100) for pointer and key
+// samples from the challenge page. Visitors scoring at least HumanScore
+// whose risk stays low skip the proof-of-work.
+type BehaviorConfig struct {
+	HumanScore   int `yaml:"human_score"`
+	RoboticScore int `yaml:"robotic_score"`
+}
+
+// DebugConfig controls decision explanations. Only TrustedIPs (addresses
+// or CIDRs) may read them.
+type DebugConfig struct {
+	TrustedIPs []string `yaml:"trusted_ips"`
+	// Header echoes each decision in an X-Janus-Debug response header.
+	Header bool `yaml:"header"`
+	// History is how many recent decisions /janus/debug/explain can return.
+	History int `yaml:"history"`
+}
+
+// SigningConfig lists the janus_token keys. Active names the key new tokens
+// are signed with; the others only verify, so a rotation adds the new key,
+// switches Active, and removes the old key once its tokens have expired.
+type SigningConfig struct {
+	Active string             `yaml:"active"`
+	Keys   []SigningKeyConfig `yaml:"keys"`
+}
+
+// SigningKeyConfig is one key, identified in tokens by its ID (kid). HS256
+// keys take a secret inline, from SecretEnv or from SecretFile. ES256 and
+// EdDSA keys take a PEM private key from PrivateKeyFile or PrivateKeyEnv,
+// or only PublicKeyFile for a retired key that still verifies.
+type SigningKeyConfig struct {
+	ID             string `yaml:"id"`
+	Alg            string `yaml:"alg"`
+	Secret         string `yaml:"secret"`
+	SecretEnv      string `yaml:"secret_env"`
+	SecretFile     string `yaml:"secret_file"`
+	PrivateKeyFile string `yaml:"private_key_file"`
+	PrivateKeyEnv  string `yaml:"private_key_env"`
+	PublicKeyFile  string `yaml:"public_key_file"`
+}
+
+// TokenBindingConfig decides what a janus_token is bound to. Modes is any
+// combination of "ip" (the exact address), "subnet" (its IPv4Prefix or
+// IPv6Prefix network), "asn" (its autonomous system, which needs the
+// GeoLite2 ASN database) and "fingerprint" (canvas hash, WebGL renderer and
+// TLS fingerprint); all listed must match. With Grace, a token whose
+// network binding drifted but whose fingerprint still matches is re-scored
+// silently and re-bound instead of sending the visitor to a challenge;
+// Grace needs the "fingerprint" mode.
+type TokenBindingConfig struct {
+	Modes      []string `yaml:"modes"`
+	IPv4Prefix int      `yaml:"ipv4_prefix"`
+	IPv6Prefix int      `yaml:"ipv6_prefix"`
+	Grace      bool     `yaml:"grace"`
+}
+
+// CookieConfig sets the janus_token cookie attributes. SameSite is
+// "strict", "lax" or "none" (which needs Secure). MaxAge caps the cookie's
+// lifetime; 0 keeps it as long as the token. HostPrefix names the cookie
+// __Host-<Name>, which browsers only accept with Secure, Path "/" and no
+// Domain.
+type CookieConfig struct {
+	Name       string        `yaml:"name"`
+	Path       string        `yaml:"path"`
+	Domain     string        `yaml:"domain"`
+	SameSite   string        `yaml:"same_site"`
+	Secure     bool          `yaml:"secure"`
+	MaxAge     time.Duration `yaml:"max_age"`
+	HostPrefix bool          `yaml:"host_prefix"`
+}
+
+// ExchangeConfig lets /janus/exchange hand a verified visitor to another
+// host in Hosts with a signed, single-use handoff valid for TTL. Every
+// host must share the store and signing keys.
+type ExchangeConfig struct {
+	Hosts []string      `yaml:"hosts"`
+	TTL   time.Duration `yaml:"ttl"`
+}
+
+// AdminConfig guards the /janus/admin endpoints; only TrustedIPs
+// (addresses or CIDRs) may call them.
+type AdminConfig struct {
+	TrustedIPs []string `yaml:"trusted_ips"`
+}
+
+// DetectorConfig positions, toggles and re-weights one detector. Detectors
+// not listed stay enabled and run after the listed ones.
+type DetectorConfig struct {
+	Name    string         `yaml:"name"`
+	Enabled *bool          `yaml:"enabled"`
+	Weights map[string]int `yaml:"weights"`
+}
+
+// ProxyProtocolConfig makes cmd/janus listeners accept PROXY protocol v1/v2
+// headers from load balancers in TrustedCIDRs; those peers must send one.
+type ProxyProtocolConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	TrustedCIDRs  []string      `yaml:"trusted_cidrs"`
+	HeaderTimeout time.Duration `yaml:"header_timeout"`
+}
+
+// ProxyConfig enables reverse-proxy mode in cmd/janus.
+type ProxyConfig struct {
+	Enabled   bool             `yaml:"enabled"`
+	Retries   int              `yaml:"retries"`
+	Upstreams []UpstreamConfig `yaml:"upstreams"`
+}
+
+// UpstreamConfig is a pool of equivalent backends selected by host and
+// path prefix. URLs in one pool should differ only in scheme and host.
+type UpstreamConfig struct {
+	Name         string            `yaml:"name"`
+	URLs         []string          `yaml:"urls"`
+	Hosts        []string          `yaml:"hosts"`
+	PathPrefix   string            `yaml:"path_prefix"`
+	StripPrefix  bool              `yaml:"strip_prefix"`
+	PreserveHost bool              `yaml:"preserve_host"`
+	HealthCheck  HealthCheckConfig `yaml:"health_check"`
+}
+
+type HealthCheckConfig struct {
+	Path     string        `yaml:"path"`
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
 }
 
 func DefaultConfig() *JanusConfig {
@@ -40,16 +286,54 @@ func DefaultConfig() *JanusConfig {
 			"blacklisted_ip":        100,
 			"banned_geo":            100,
 			"tls_mismatch":          30,
+			"tls_unknown":           10,
+			"tls_automation":        50,
+			"h2_mismatch":           30,
 			"no_user_agent":         40,
 			"headless_browser":      50,
 			"missing_headers":       20,
 			"header_order_mismatch": 20,
 			"no_fingerprint":        30,
+			"behavior_robotic":      40,
+			"behavior_human":        -20,
 		},
-		RedisAddr: "localhost:6379",
+		RedisAddr:              "localhost:6379",
+		StoreBackend:           "redis",
+		StorePath:              "janus.db",
+		StoreTimeout:           500 * time.Millisecond,
+		RedisFailureMode:       "local-fallback",
+		RedisBreakerFailures:   5,
+		RedisProbeInterval:     2 * time.Second,
+		ChallengeMaxAttempts:   5,
+		ChallengeAttemptWindow: 10 * time.Minute,
+		Puzzles:                []string{"image", "logic"},
+		FingerprintTTL:         30 * time.Minute,
+		FingerprintMaxEntries:  100000,
+		TokenTTL:               24 * time.Hour,
+		TokenMaxLifetime:       7 * 24 * time.Hour,
+		TrustedProxies:         []string{},
+		ClientIPHeaders:        []string{"X-Forwarded-For", "Forwarded"},
+		TLSFingerprintDB:       "tls_fingerprints.yaml",
+		TLSFingerprintReload:   30 * time.Second,
 	}
 	cfg.RateLimit.RequestsPerMinute = 60
 	cfg.RateLimit.Burst = 10
+	cfg.Proxy.Retries = 2
+	cfg.ProxyProtocol.HeaderTimeout = 5 * time.Second
+	cfg.Debug.TrustedIPs = []string{"127.0.0.1", "::1"}
+	cfg.Debug.History = 1000
+	cfg.Admin.TrustedIPs = []string{"127.0.0.1", "::1"}
+	cfg.TokenBinding = TokenBindingConfig{Modes: []string{"ip"}, IPv4Prefix: 24, IPv6Prefix: 64}
+	cfg.Cookie = CookieConfig{Name: "janus_token", Path: "/", SameSite: "lax", Secure: true}
+	cfg.Exchange.TTL = 30 * time.Second
+	cfg.Monitoring = MonitoringConfig{Enabled: false, MaxPassivePages: 200, Action: "rechallenge", PathHistory: 50}
+	cfg.Behavior = BehaviorConfig{HumanScore: 70, RoboticScore: 30}
+	cfg.Actions = []ActionBand{
+		{Action: "allow", MinScore: 0},
+		{Action: "invisible", MinScore: 20},
+		{Action: "interactive", MinScore: 50},
+		{Action: "block", MinScore: 80, Status: 403},
+	}
 	return cfg
 }
 
@@ -57,11 +341,9 @@ func LoadConfig(path string) (*JanusConfig, error) {
 	cfg := DefaultConfig()
 	data, err := os.ReadFile(path)
 	if err != nil {
-		log.Printf("LoadConfig: Failed to read config file %s: %v", path, err)
 		return cfg, err
 	}
 	if err := yaml.Unmarshal(data, cfg); err != nil {
-		log.Printf("LoadConfig: Failed to unmarshal config: %v", err)
 		return cfg, err
 	}
 	return cfg, nil
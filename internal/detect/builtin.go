@@ -0,0 +1,255 @@
+package detect
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"janus/internal/behavior"
+	"janus/internal/h2fp"
+	"janus/internal/tlsfp"
+
+	"github.com/oschwald/geoip2-golang/v2"
+)
+
+// Whitelist allows requests whose User-Agent and IP are both whitelisted.
+type Whitelist struct {
+	UAs []string
+	IPs []string
+}
+
+func (Whitelist) Name() string { return "whitelist" }
+func (Whitelist) Cost() Cost   { return CostCheap }
+
+func (d Whitelist) Evaluate(ctx context.Context, info *RequestInfo) []Signal {
+	uaLower := strings.ToLower(info.UserAgent)
+	uaWhitelisted := false
+	for _, allowed := range d.UAs {
+		if strings.Contains(uaLower, strings.ToLower(allowed)) {
+			uaWhitelisted = true
+			break
+		}
+	}
+	if !uaWhitelisted {
+		return nil
+	}
+	for _, ip := range d.IPs {
+		if info.ClientIP == ip {
+			return []Signal{{Name: "whitelisted", Allow: true, Evidence: fmt.Sprintf("ua %q, ip %s", info.UserAgent, ip)}}
+		}
+	}
+	return nil
+}
+
+// IPBlacklist flags addresses or CIDR ranges from blacklisted_ips.
+type IPBlacklist struct {
+	prefixes []netip.Prefix
+}
+
+// NewIPBlacklist parses entries, each an address or a CIDR range.
+func NewIPBlacklist(entries []string) (*IPBlacklist, error) {
+	d := &IPBlacklist{}
+	for _, e := range entries {
+		if p, err := netip.ParsePrefix(e); err == nil {
+			d.prefixes = append(d.prefixes, p.Masked())
+		} else if a, err := netip.ParseAddr(e); err == nil {
+			d.prefixes = append(d.prefixes, netip.PrefixFrom(a, a.BitLen()))
+		} else {
+			return nil, fmt.Errorf("detect: invalid blacklisted ip %q", e)
+		}
+	}
+	return d, nil
+}
+
+func (*IPBlacklist) Name() string { return "ip_blacklist" }
+func (*IPBlacklist) Cost() Cost   { return CostCheap }
+
+func (d *IPBlacklist) Evaluate(ctx context.Context, info *RequestInfo) []Signal {
+	addr, err := netip.ParseAddr(info.ClientIP)
+	if err != nil {
+		return nil
+	}
+	addr = addr.Unmap()
+	for _, p := range d.prefixes {
+		if p.Contains(addr) {
+			return []Signal{{Name: "blacklisted_ip", Weight: 100, Terminal: true, Evidence: "ip " + info.ClientIP + " in " + p.String()}}
+		}
+	}
+	return nil
+}
+
+// Geo flags countries listed in banned_geo_locations.
+type Geo struct {
+	DB     *geoip2.Reader
+	Banned []string
+}
+
+func (*Geo) Name() string { return "geo" }
+func (*Geo) Cost() Cost   { return CostModerate }
+
+func (d *Geo) Evaluate(ctx context.Context, info *RequestInfo) []Signal {
+	if d.DB == nil || len(d.Banned) == 0 {
+		return nil
+	}
+	addr, err := netip.ParseAddr(info.ClientIP)
+	if err != nil {
+		return nil
+	}
+	record, err := d.DB.City(addr)
+	if err != nil {
+		return nil
+	}
+	geoCode := record.Country.ISOCode
+	for _, banned := range d.Banned {
+		if geoCode == banned {
+			return []Signal{{Name: "banned_geo", Weight: 100, Terminal: true, Evidence: "country " + geoCode}}
+		}
+	}
+	return nil
+}
+
+// TLS checks the connection's JA3/JA4 against the fingerprint database
+// and the claimed User-Agent.
+type TLS struct {
+	DB *tlsfp.DB
+}
+
+func (*TLS) Name() string { return "tls" }
+func (*TLS) Cost() Cost   { return CostCheap }
+
+func (d *TLS) Evaluate(ctx context.Context, info *RequestInfo) []Signal {
+	if d.DB == nil || info.TLS == nil {
+		return nil
+	}
+	verdict := d.DB.Check(info.TLS, info.UserAgent)
+	var signals []Signal
+	if verdict.Unknown {
+		signals = append(signals, Signal{Name: "tls_unknown", Weight: 10, Evidence: fmt.Sprintf("ja3 %s, ja4 %s", info.TLS.JA3Hash, info.TLS.JA4)})
+	}
+	if verdict.Automation {
+		signals = append(signals, Signal{Name: "tls_automation", Weight: 50, Evidence: "tls stack " + verdict.Entry.Family})
+	}
+	if verdict.Mismatch {
+		signals = append(signals, Signal{Name: "tls_mismatch", Weight: 30, Evidence: verdict.Reason})
+	}
+	return signals
+}
+
+// HTTP2 checks the HTTP/2 connection fingerprint against the claimed
+// browser.
+type HTTP2 struct{}
+
+func (HTTP2) Name() string { return "http2" }
+func (HTTP2) Cost() Cost   { return CostCheap }
+
+func (HTTP2) Evaluate(ctx context.Context, info *RequestInfo) []Signal {
+	if info.HTTP2 == nil {
+		return nil
+	}
+	if ok, reason := h2fp.Check(info.HTTP2, info.UserAgent); !ok {
+		return []Signal{{Name: "h2_mismatch", Weight: 30, Evidence: reason + " (" + info.HTTP2.String() + ")"}}
+	}
+	return nil
+}
+
+// UserAgent flags missing, tool and headless User-Agents.
+type UserAgent struct{}
+
+func (UserAgent) Name() string { return "user_agent" }
+func (UserAgent) Cost() Cost   { return CostCheap }
+
+func (UserAgent) Evaluate(ctx context.Context, info *RequestInfo) []Signal {
+	uaLower := strings.ToLower(info.UserAgent)
+	var signals []Signal
+	if info.UserAgent == "" || strings.Contains(uaLower, "curl") || strings.Contains(uaLower, "python") {
+		signals = append(signals, Signal{Name: "no_user_agent", Weight: 40, Evidence: fmt.Sprintf("ua %q", info.UserAgent)})
+	}
+	if strings.Contains(uaLower, "headless") {
+		signals = append(signals, Signal{Name: "headless_browser", Weight: 50, Evidence: fmt.Sprintf("ua %q", info.UserAgent)})
+	}
+	return signals
+}
+
+// Headers flags requests missing headers every browser sends.
+type Headers struct{}
+
+func (Headers) Name() string { return "headers" }
+func (Headers) Cost() Cost   { return CostCheap }
+
+var expectedHeaders = []string{"user-agent", "accept-language", "accept-encoding"}
+
+func (Headers) Evaluate(ctx context.Context, info *RequestInfo) []Signal {
+	r := info.Request
+	if strings.Contains(r.URL.Path, ".well-known") {
+		return nil
+	}
+	var signals []Signal
+	if r.Header.Get("Accept") == "" {
+		signals = append(signals, Signal{Name: "missing_headers", Weight: 20, Evidence: "no accept header"})
+	}
+	var missing []string
+	for _, h := range expectedHeaders {
+		if r.Header.Get(h) == "" {
+			missing = append(missing, h)
+		}
+	}
+	if len(missing) > 0 {
+		signals = append(signals, Signal{Name: "header_order_mismatch", Weight: 20, Evidence: "missing " + strings.Join(missing, ",")})
+	}
+	return signals
+}
+
+// BrowserFingerprint checks the fingerprint posted by sensor.js.
+type BrowserFingerprint struct{}
+
+func (BrowserFingerprint) Name() string { return "browser_fingerprint" }
+func (BrowserFingerprint) Cost() Cost   { return CostCheap }
+
+func (BrowserFingerprint) Evaluate(ctx context.Context, info *RequestInfo) []Signal {
+	fp := info.Fingerprint
+	if fp == nil {
+		return []Signal{{Name: "no_fingerprint", Weight: 30, Evidence: "no fingerprint submitted"}}
+	}
+	var signals []Signal
+	if fp.Webdriver {
+		signals = append(signals, Signal{Name: "headless_browser", Weight: 50, Evidence: "navigator.webdriver set"})
+	}
+	if !fp.ChromeExists && strings.Contains(strings.ToLower(info.UserAgent), "chrome") {
+		signals = append(signals, Signal{Name: "headless_browser", Weight: 50, Evidence: "chrome ua without window.chrome"})
+	}
+	if fp.CanvasHash == "error" || fp.CanvasHash == "" {
+		signals = append(signals, Signal{Name: "no_fingerprint", Weight: 30, Evidence: "invalid canvas hash"})
+	}
+	if fp.WebGLRenderer == "no-webgl" || fp.WebGLRenderer == "error" {
+		signals = append(signals, Signal{Name: "no_fingerprint", Weight: 30, Evidence: "webgl renderer " + fp.WebGLRenderer})
+	}
+	return signals
+}
+
+// Behavior scores the pointer and key samples posted with the fingerprint.
+// Traces at or below RoboticScore raise behavior_robotic; those at or
+// above HumanScore raise behavior_human, whose default weight is negative.
+type Behavior struct {
+	HumanScore   int
+	RoboticScore int
+}
+
+func (*Behavior) Name() string { return "behavior" }
+func (*Behavior) Cost() Cost   { return CostModerate }
+
+func (d *Behavior) Evaluate(ctx context.Context, info *RequestInfo) []Signal {
+	if info.Fingerprint == nil || info.Fingerprint.Behavior == nil {
+		return nil
+	}
+	res := behavior.Analyze(info.Fingerprint.Behavior)
+	switch {
+	case !res.Scored:
+		return nil
+	case res.Score <= d.RoboticScore:
+		return []Signal{{Name: "behavior_robotic", Weight: 40, Evidence: res.String()}}
+	case res.Score >= d.HumanScore:
+		return []Signal{{Name: "behavior_human", Weight: -20, Evidence: res.String()}}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package detect
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"janus/internal/types"
+)
+
+// names lists the signal names d raises for info.
+func names(d Detector, info *RequestInfo) map[string]bool {
+	out := map[string]bool{}
+	for _, s := range d.Evaluate(context.Background(), info) {
+		out[s.Name] = true
+	}
+	return out
+}
+
+func TestWhitelist(t *testing.T) {
+	d := Whitelist{UAs: []string{"chrome"}, IPs: []string{"127.0.0.1"}}
+	tests := []struct {
+		ua, ip string
+		want   bool
+	}{
+		{"Mozilla/5.0 Chrome/126.0", "127.0.0.1", true},
+		{"Mozilla/5.0 Chrome/126.0", "192.0.2.1", false},
+		{"curl/8.5.0", "127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := names(d, &RequestInfo{UserAgent: tt.ua, ClientIP: tt.ip})["whitelisted"]; got != tt.want {
+			t.Errorf("ua %q ip %s: whitelisted %v, want %v", tt.ua, tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestIPBlacklist(t *testing.T) {
+	d, err := NewIPBlacklist([]string{"198.51.100.7", "203.0.113.0/24", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]bool{
+		"198.51.100.7":         true,
+		"198.51.100.8":         false,
+		"203.0.113.200":        true,
+		"::ffff:203.0.113.1":   true,
+		"2001:db8:1::1":        true,
+		"2001:db9::1":          false,
+		"definitely not an ip": false,
+	}
+	for ip, want := range tests {
+		if got := names(d, &RequestInfo{ClientIP: ip})["blacklisted_ip"]; got != want {
+			t.Errorf("%s: blacklisted %v, want %v", ip, got, want)
+		}
+	}
+}
+
+func TestIPBlacklistRejectsBadEntries(t *testing.T) {
+	for _, e := range []string{"not an ip", "203.0.113.0/33", "198.51.100.300"} {
+		if _, err := NewIPBlacklist([]string{"198.51.100.7", e}); err == nil {
+			t.Errorf("NewIPBlacklist accepted %q", e)
+		}
+	}
+}
+
+func TestUserAgentDetector(t *testing.T) {
+	tests := map[string][]string{
+		"":                           {"no_user_agent"},
+		"curl/8.5.0":                 {"no_user_agent"},
+		"python-requests/2.31.0":     {"no_user_agent"},
+		"Mozilla/5.0 HeadlessChrome": {"headless_browser"},
+		"Mozilla/5.0 Chrome/126.0":   nil,
+	}
+	for ua, want := range tests {
+		got := names(UserAgent{}, &RequestInfo{UserAgent: ua})
+		if len(got) != len(want) {
+			t.Errorf("ua %q raised %v, want %v", ua, got, want)
+		}
+		for _, n := range want {
+			if !got[n] {
+				t.Errorf("ua %q did not raise %s", ua, n)
+			}
+		}
+	}
+}
+
+func TestHeadersDetector(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	if got := names(Headers{}, &RequestInfo{Request: r}); !got["missing_headers"] || !got["header_order_mismatch"] {
+		t.Errorf("bare request raised %v", got)
+	}
+	for _, h := range []string{"Accept", "User-Agent", "Accept-Language", "Accept-Encoding"} {
+		r.Header.Set(h, "x")
+	}
+	if got := names(Headers{}, &RequestInfo{Request: r}); len(got) != 0 {
+		t.Errorf("browser request raised %v", got)
+	}
+	if got := names(Headers{}, &RequestInfo{Request: httptest.NewRequest("GET", "/.well-known/acme-challenge/x", nil)}); len(got) != 0 {
+		t.Errorf(".well-known request raised %v", got)
+	}
+}
+
+func TestBrowserFingerprintDetector(t *testing.T) {
+	chrome := "Mozilla/5.0 Chrome/126.0"
+	good := &types.Fingerprint{CanvasHash: "abc", WebGLRenderer: "ANGLE", ChromeExists: true}
+	if got := names(BrowserFingerprint{}, &RequestInfo{UserAgent: chrome, Fingerprint: good}); len(got) != 0 {
+		t.Errorf("browser fingerprint raised %v", got)
+	}
+	if got := names(BrowserFingerprint{}, &RequestInfo{UserAgent: chrome}); !got["no_fingerprint"] {
+		t.Errorf("missing fingerprint raised %v", got)
+	}
+	headless := &types.Fingerprint{CanvasHash: "abc", WebGLRenderer: "ANGLE", Webdriver: true}
+	if got := names(BrowserFingerprint{}, &RequestInfo{UserAgent: chrome, Fingerprint: headless}); !got["headless_browser"] {
+		t.Errorf("webdriver fingerprint raised %v", got)
+	}
+	broken := &types.Fingerprint{CanvasHash: "error", WebGLRenderer: "no-webgl", ChromeExists: true}
+	if got := names(BrowserFingerprint{}, &RequestInfo{UserAgent: chrome, Fingerprint: broken}); !got["no_fingerprint"] {
+		t.Errorf("broken fingerprint raised %v", got)
+	}
+}
+
+func TestBehaviorDetector(t *testing.T) {
+	d := &Behavior{HumanScore: 70, RoboticScore: 30}
+	line := &types.Behavior{}
+	for i := 0; i < 20; i++ {
+		line.Pointer = append(line.Pointer, types.PointerSample{X: float64(10 * i), Y: 0, T: float64(16 * i)})
+	}
+	if got := names(d, &RequestInfo{Fingerprint: &types.Fingerprint{Behavior: line}}); !got["behavior_robotic"] {
+		t.Errorf("scripted trace raised %v", got)
+	}
+	short := &types.Behavior{Pointer: line.Pointer[:3]}
+	if got := names(d, &RequestInfo{Fingerprint: &types.Fingerprint{Behavior: short}}); len(got) != 0 {
+		t.Errorf("unscored trace raised %v", got)
+	}
+	if got := names(d, &RequestInfo{Fingerprint: &types.Fingerprint{}}); len(got) != 0 {
+		t.Errorf("fingerprint without behaviour raised %v", got)
+	}
+}
@@ -0,0 +1,129 @@
+package detect
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions recorded on a Decision.
+const (
+	ActionAllow       = "allow"
+	ActionInvisible   = "invisible"
+	ActionInteractive = "interactive"
+	ActionBlock       = "block"
+	ActionTarpit      = "tarpit"
+)
+
+// Decision explains why a request was treated the way it was: every signal
+// that fired with its weight and evidence, the score and the action taken.
+type Decision struct {
+	ID       string    `json:"id"`
+	Time     time.Time `json:"time"`
+	ClientIP string    `json:"client_ip"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+	// Route names the routes entry that matched, if any.
+	Route     string   `json:"route,omitempty"`
+	UserAgent string   `json:"user_agent"`
+	JA3       string   `json:"ja3,omitempty"`
+	JA4       string   `json:"ja4,omitempty"`
+	HTTP2     string   `json:"http2,omitempty"`
+	Signals   []Signal `json:"signals"`
+	Score     int      `json:"score"`
+	Threshold int      `json:"threshold"`
+	// Whitelisted is set when a detector allowed the request outright.
+	Whitelisted bool   `json:"whitelisted,omitempty"`
+	Action      string `json:"action"`
+}
+
+// Suspicious reports whether the score reached the threshold.
+func (d *Decision) Suspicious() bool {
+	return d.Score >= d.Threshold
+}
+
+// HasSignal reports whether a signal called name fired.
+func (d *Decision) HasSignal(name string) bool {
+	for _, s := range d.Signals {
+		if s.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Header renders the decision compactly for the X-Janus-Debug header.
+func (d *Decision) Header() string {
+	signals := make([]string, 0, len(d.Signals))
+	for _, s := range d.Signals {
+		signals = append(signals, fmt.Sprintf("%s:%d", s.Name, s.Weight))
+	}
+	return fmt.Sprintf("id=%s; action=%s; score=%d; threshold=%d; signals=%s",
+		d.ID, d.Action, d.Score, d.Threshold, strings.Join(signals, ","))
+}
+
+type decisionKey struct{}
+
+// WithDecision attaches d to ctx.
+func WithDecision(ctx context.Context, d *Decision) context.Context {
+	return context.WithValue(ctx, decisionKey{}, d)
+}
+
+// DecisionFromContext returns the Decision made for the request, if any.
+func DecisionFromContext(ctx context.Context) (*Decision, bool) {
+	d, ok := ctx.Value(decisionKey{}).(*Decision)
+	return d, ok
+}
+
+// History keeps the most recent decisions in a fixed-size ring.
+type History struct {
+	mu    sync.Mutex
+	items []*Decision
+	next  int
+	full  bool
+}
+
+func NewHistory(size int) *History {
+	if size <= 0 {
+		size = 1
+	}
+	return &History{items: make([]*Decision, size)}
+}
+
+func (h *History) Add(d *Decision) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.items[h.next] = d
+	h.next = (h.next + 1) % len(h.items)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// Find returns decisions matching id and/or clientIP, newest first. Empty
+// filters match everything.
+func (h *History) Find(id, clientIP string, limit int) []*Decision {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := h.next
+	if h.full {
+		n = len(h.items)
+	}
+	var out []*Decision
+	for i := 0; i < n && (limit <= 0 || len(out) < limit); i++ {
+		d := h.items[(h.next-1-i+len(h.items))%len(h.items)]
+		if d == nil {
+			continue
+		}
+		if id != "" && d.ID != id {
+			continue
+		}
+		if clientIP != "" && d.ClientIP != clientIP {
+			continue
+		}
+		out = append(out, d)
+	}
+	return out
+}
@@ -0,0 +1,56 @@
+package detect
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	h := NewHistory(3)
+	for i := 0; i < 5; i++ {
+		h.Add(&Decision{ID: fmt.Sprint(i), ClientIP: []string{"a", "b"}[i%2]})
+	}
+	ids := func(ds []*Decision) string {
+		s := ""
+		for _, d := range ds {
+			s += d.ID
+		}
+		return s
+	}
+	// The ring keeps the newest three, newest first.
+	if got := ids(h.Find("", "", 0)); got != "432" {
+		t.Errorf("Find all = %s, want 432", got)
+	}
+	if got := ids(h.Find("", "a", 0)); got != "42" {
+		t.Errorf("Find ip a = %s, want 42", got)
+	}
+	if got := ids(h.Find("3", "", 0)); got != "3" {
+		t.Errorf("Find id 3 = %s, want 3", got)
+	}
+	if got := ids(h.Find("0", "", 0)); got != "" {
+		t.Errorf("Find evicted id 0 = %s, want none", got)
+	}
+	if got := ids(h.Find("", "", 2)); got != "43" {
+		t.Errorf("Find limit 2 = %s, want 43", got)
+	}
+}
+
+func TestDecision(t *testing.T) {
+	d := &Decision{
+		ID:        "abc",
+		Action:    ActionInvisible,
+		Score:     60,
+		Threshold: 50,
+		Signals:   []Signal{{Name: "no_fingerprint", Weight: 30}, {Name: "missing_headers", Weight: 30}},
+	}
+	if want := "id=abc; action=invisible; score=60; threshold=50; signals=no_fingerprint:30,missing_headers:30"; d.Header() != want {
+		t.Errorf("Header = %q, want %q", d.Header(), want)
+	}
+	if !d.Suspicious() || !d.HasSignal("missing_headers") || d.HasSignal("tls_unknown") {
+		t.Errorf("Suspicious %v, HasSignal %v/%v", d.Suspicious(), d.HasSignal("missing_headers"), d.HasSignal("tls_unknown"))
+	}
+	if got, ok := DecisionFromContext(WithDecision(context.Background(), d)); !ok || got != d {
+		t.Error("decision not carried by the context")
+	}
+}
@@ -0,0 +1,104 @@
+package detect
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"janus/internal/h2fp"
+	"janus/internal/tlsfp"
+	"janus/internal/types"
+)
+
+// Cost classifies how expensive a detector is to run. Detectors without an
+// explicit position in the config run cheapest first.
+type Cost int
+
+const (
+	CostCheap Cost = iota
+	CostModerate
+	CostExpensive
+)
+
+func (c Cost) String() string {
+	switch c {
+	case CostCheap:
+		return "cheap"
+	case CostModerate:
+		return "moderate"
+	case CostExpensive:
+		return "expensive"
+	}
+	return fmt.Sprintf("cost(%d)", int(c))
+}
+
+// Signal is one observation raised by a detector. Weight is a default; the
+// engine replaces it with the configured weight for Name when one exists.
+type Signal struct {
+	Name     string `json:"name"`
+	Detector string `json:"detector"`
+	Weight   int    `json:"weight"`
+	Evidence string `json:"evidence,omitempty"`
+	// Terminal stops evaluation of the remaining detectors.
+	Terminal bool `json:"terminal,omitempty"`
+	// Allow stops evaluation and clears the score (whitelisting).
+	Allow bool `json:"allow,omitempty"`
+}
+
+// RequestInfo is what detectors evaluate. Optional parts are nil when not
+// available for the request.
+type RequestInfo struct {
+	Request     *http.Request
+	ClientIP    string
+	UserAgent   string
+	JA3         string
+	TLS         *tlsfp.Fingerprint
+	HTTP2       *h2fp.Fingerprint
+	Fingerprint *types.Fingerprint
+}
+
+// Detector scores one aspect of a request.
+type Detector interface {
+	Name() string
+	Cost() Cost
+	Evaluate(ctx context.Context, info *RequestInfo) []Signal
+}
+
+// Registry holds detectors by name in registration order.
+type Registry struct {
+	mu        sync.RWMutex
+	detectors map[string]Detector
+	order     []string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{detectors: make(map[string]Detector)}
+}
+
+// Register adds d, failing if its name is already taken.
+func (r *Registry) Register(d Detector) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name := d.Name()
+	if _, exists := r.detectors[name]; exists {
+		return fmt.Errorf("detect: detector %q already registered", name)
+	}
+	r.detectors[name] = d
+	r.order = append(r.order, name)
+	return nil
+}
+
+func (r *Registry) Get(name string) (Detector, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	d, ok := r.detectors[name]
+	return d, ok
+}
+
+// Names returns registered detector names in registration order.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]string(nil), r.order...)
+}
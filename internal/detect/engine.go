@@ -0,0 +1,101 @@
+package detect
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"janus/internal/config"
+)
+
+type stage struct {
+	detector Detector
+	weights  map[string]int
+}
+
+// Engine runs an ordered set of detectors and sums their signals.
+type Engine struct {
+	stages  []stage
+	weights map[string]int
+}
+
+// Result is the outcome of one evaluation. Signals carry their resolved
+// weights.
+type Result struct {
+	Score   int
+	Signals []Signal
+	// Allowed is set when a detector whitelisted the request.
+	Allowed bool
+}
+
+// NewEngine orders the registered detectors according to cfg: listed
+// detectors run first in the listed order, unless disabled; the rest follow
+// by cost class. weights are the global signal weights that per-detector
+// weights override.
+func NewEngine(reg *Registry, cfg []config.DetectorConfig, weights map[string]int) (*Engine, error) {
+	e := &Engine{weights: weights}
+
+	listed := make(map[string]bool)
+	for _, dc := range cfg {
+		if listed[dc.Name] {
+			return nil, fmt.Errorf("detect: detector %q configured twice", dc.Name)
+		}
+		listed[dc.Name] = true
+		d, ok := reg.Get(dc.Name)
+		if !ok {
+			return nil, fmt.Errorf("detect: unknown detector %q", dc.Name)
+		}
+		if dc.Enabled != nil && !*dc.Enabled {
+			continue
+		}
+		e.stages = append(e.stages, stage{detector: d, weights: dc.Weights})
+	}
+
+	var rest []Detector
+	for _, name := range reg.Names() {
+		if listed[name] {
+			continue
+		}
+		d, _ := reg.Get(name)
+		rest = append(rest, d)
+	}
+	sort.SliceStable(rest, func(a, b int) bool { return rest[a].Cost() < rest[b].Cost() })
+	for _, d := range rest {
+		e.stages = append(e.stages, stage{detector: d})
+	}
+	return e, nil
+}
+
+// Evaluate runs the detectors in order until one returns a terminal or
+// allowing signal.
+func (e *Engine) Evaluate(ctx context.Context, info *RequestInfo) Result {
+	var res Result
+	for _, st := range e.stages {
+		if ctx.Err() != nil {
+			break
+		}
+		for _, sig := range st.detector.Evaluate(ctx, info) {
+			sig.Detector = st.detector.Name()
+			if sig.Allow {
+				return Result{Signals: []Signal{sig}, Allowed: true}
+			}
+			sig.Weight = e.weight(st, sig)
+			res.Score += sig.Weight
+			res.Signals = append(res.Signals, sig)
+			if sig.Terminal {
+				return res
+			}
+		}
+	}
+	return res
+}
+
+func (e *Engine) weight(st stage, sig Signal) int {
+	if w, ok := st.weights[sig.Name]; ok {
+		return w
+	}
+	if w, ok := e.weights[sig.Name]; ok {
+		return w
+	}
+	return sig.Weight
+}
@@ -0,0 +1,124 @@
+package detect
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"janus/internal/config"
+)
+
+// stub raises fixed signals and records that it ran.
+type stub struct {
+	name    string
+	cost    Cost
+	signals []Signal
+	ran     *[]string
+}
+
+func (s stub) Name() string { return s.name }
+func (s stub) Cost() Cost   { return s.cost }
+
+func (s stub) Evaluate(ctx context.Context, info *RequestInfo) []Signal {
+	*s.ran = append(*s.ran, s.name)
+	return s.signals
+}
+
+func registry(t *testing.T, ds ...Detector) *Registry {
+	t.Helper()
+	reg := NewRegistry()
+	for _, d := range ds {
+		if err := reg.Register(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return reg
+}
+
+func TestRegisterRejectsDuplicates(t *testing.T) {
+	var ran []string
+	reg := registry(t, stub{name: "a", ran: &ran})
+	if err := reg.Register(stub{name: "a", ran: &ran}); err == nil {
+		t.Error("second detector named a registered")
+	}
+}
+
+func TestEngineOrder(t *testing.T) {
+	var ran []string
+	reg := registry(t,
+		stub{name: "expensive", cost: CostExpensive, ran: &ran},
+		stub{name: "moderate", cost: CostModerate, ran: &ran},
+		stub{name: "cheap", cost: CostCheap, ran: &ran},
+		stub{name: "off", cost: CostCheap, ran: &ran},
+		stub{name: "first", cost: CostExpensive, ran: &ran},
+	)
+	disabled := false
+	e, err := NewEngine(reg, []config.DetectorConfig{{Name: "first"}, {Name: "off", Enabled: &disabled}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Evaluate(context.Background(), &RequestInfo{})
+	if want := []string{"first", "cheap", "moderate", "expensive"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+}
+
+func TestEngineConfigErrors(t *testing.T) {
+	var ran []string
+	reg := registry(t, stub{name: "a", ran: &ran})
+	for name, cfg := range map[string][]config.DetectorConfig{
+		"unknown": {{Name: "b"}},
+		"twice":   {{Name: "a"}, {Name: "a"}},
+	} {
+		if _, err := NewEngine(reg, cfg, nil); err == nil {
+			t.Errorf("%s: NewEngine succeeded", name)
+		}
+	}
+}
+
+func TestEngineWeights(t *testing.T) {
+	var ran []string
+	reg := registry(t,
+		stub{name: "a", ran: &ran, signals: []Signal{{Name: "x", Weight: 1}, {Name: "y", Weight: 2}, {Name: "z", Weight: 3}}},
+	)
+	e, err := NewEngine(reg, []config.DetectorConfig{{Name: "a", Weights: map[string]int{"x": 100}}}, map[string]int{"x": 10, "y": 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := e.Evaluate(context.Background(), &RequestInfo{})
+	// Per-detector weights beat global ones, which beat the default.
+	if res.Score != 100+20+3 {
+		t.Errorf("Score = %d, want 123", res.Score)
+	}
+	for _, s := range res.Signals {
+		if s.Detector != "a" {
+			t.Errorf("signal %s attributed to %q", s.Name, s.Detector)
+		}
+	}
+}
+
+func TestEngineStops(t *testing.T) {
+	var ran []string
+	reg := registry(t,
+		stub{name: "a", ran: &ran, signals: []Signal{{Name: "x", Weight: 5}}},
+		stub{name: "b", ran: &ran, signals: []Signal{{Name: "bad", Weight: 100, Terminal: true}}},
+		stub{name: "c", ran: &ran, signals: []Signal{{Name: "y", Weight: 5}}},
+	)
+	e, _ := NewEngine(reg, nil, nil)
+	res := e.Evaluate(context.Background(), &RequestInfo{})
+	if res.Score != 105 || len(ran) != 2 {
+		t.Errorf("terminal signal: score %d after %v, want 105 after [a b]", res.Score, ran)
+	}
+
+	ran = nil
+	reg = registry(t,
+		stub{name: "a", ran: &ran, signals: []Signal{{Name: "x", Weight: 5}}},
+		stub{name: "b", ran: &ran, signals: []Signal{{Name: "ok", Allow: true}}},
+		stub{name: "c", ran: &ran, signals: []Signal{{Name: "y", Weight: 5}}},
+	)
+	e, _ = NewEngine(reg, nil, nil)
+	res = e.Evaluate(context.Background(), &RequestInfo{})
+	if !res.Allowed || res.Score != 0 || len(res.Signals) != 1 || len(ran) != 2 {
+		t.Errorf("allowing signal: %+v after %v, want allowed with score 0 after [a b]", res, ran)
+	}
+}
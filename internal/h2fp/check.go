@@ -0,0 +1,38 @@
+package h2fp
+
+import (
+	"fmt"
+
+	"janus/internal/tlsfp"
+)
+
+// pseudoOrders lists the pseudo-header orders each browser engine sends.
+// Non-browser HTTP/2 stacks rarely match the engine they claim to be.
+var pseudoOrders = map[string][]string{
+	"chromium": {"m,a,s,p"},
+	"firefox":  {"m,p,a,s"},
+	"safari":   {"m,s,p,a", "m,s,a,p"},
+}
+
+// Check reports whether fp is plausible for the browser ua claims to be,
+// with a reason when it is not. Non-browser user agents are not checked.
+func Check(fp *Fingerprint, ua string) (bool, string) {
+	claimed := tlsfp.ParseUserAgent(ua)
+	if !claimed.Browser {
+		return true, ""
+	}
+	if len(fp.Settings) == 0 {
+		return false, fmt.Sprintf("%s user agent sent no SETTINGS", claimed.Family)
+	}
+	orders, ok := pseudoOrders[claimed.Family]
+	if !ok {
+		return true, ""
+	}
+	order := fp.PseudoOrder()
+	for _, o := range orders {
+		if o == order {
+			return true, ""
+		}
+	}
+	return false, fmt.Sprintf("%s user agent with pseudo-header order %s", claimed.Family, order)
+}
@@ -0,0 +1,34 @@
+package h2fp
+
+import "testing"
+
+func TestCheck(t *testing.T) {
+	const (
+		chrome  = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36"
+		firefox = "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0"
+		safari  = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Safari/605.1.15"
+	)
+	settings := []Setting{{ID: 4, Value: 65535}}
+	order := func(pseudo ...string) *Fingerprint {
+		return &Fingerprint{Settings: settings, PseudoHeaders: pseudo}
+	}
+	tests := []struct {
+		name string
+		fp   *Fingerprint
+		ua   string
+		ok   bool
+	}{
+		{"chrome", order(":method", ":authority", ":scheme", ":path"), chrome, true},
+		{"firefox", order(":method", ":path", ":authority", ":scheme"), firefox, true},
+		{"safari", order(":method", ":scheme", ":path", ":authority"), safari, true},
+		{"firefox order claiming chrome", order(":method", ":path", ":authority", ":scheme"), chrome, false},
+		{"no settings", &Fingerprint{PseudoHeaders: []string{":method", ":authority", ":scheme", ":path"}}, chrome, false},
+		{"tool is not checked", order(":path"), "curl/8.5.0", true},
+	}
+	for _, tt := range tests {
+		ok, reason := Check(tt.fp, tt.ua)
+		if ok != tt.ok || (ok == (reason != "")) {
+			t.Errorf("%s: Check = %v, %q; want %v", tt.name, ok, reason, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package h2fp
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/http2"
+)
+
+// maxPrefaceBytes bounds how much plaintext is buffered while waiting for
+// the first request's headers.
+const maxPrefaceBytes = 64 * 1024
+
+type contextKey struct{}
+
+// Conn records the plaintext read from an HTTP/2 connection until the
+// first request's header block has been parsed.
+type Conn struct {
+	*tls.Conn
+
+	mu   sync.Mutex
+	buf  []byte
+	done bool
+	fp   *Fingerprint
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.record(b[:n])
+	}
+	return n, err
+}
+
+func (c *Conn) record(b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done {
+		return
+	}
+	c.buf = append(c.buf, b...)
+	fp, err := parse(c.buf)
+	if err == errIncomplete && len(c.buf) < maxPrefaceBytes {
+		return
+	}
+	c.done = true
+	c.buf = nil
+	if err == nil {
+		c.fp = fp
+	}
+}
+
+// Fingerprint returns the connection's HTTP/2 fingerprint, or nil if the
+// preface has not been parsed.
+func (c *Conn) Fingerprint() *Fingerprint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.fp
+}
+
+// ConfigureServer enables HTTP/2 on srv through an x/net/http2 server
+// whose connections are fingerprinted. Call it instead of relying on the
+// standard library's built-in HTTP/2 support.
+func ConfigureServer(srv *http.Server, h2 *http2.Server) {
+	if h2 == nil {
+		h2 = &http2.Server{}
+	}
+	if srv.TLSConfig == nil {
+		srv.TLSConfig = &tls.Config{}
+	}
+	protos := []string{"h2"}
+	for _, p := range srv.TLSConfig.NextProtos {
+		if p != "h2" {
+			protos = append(protos, p)
+		}
+	}
+	srv.TLSConfig.NextProtos = protos
+
+	if srv.TLSNextProto == nil {
+		srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+	srv.TLSNextProto["h2"] = func(hs *http.Server, tc *tls.Conn, h http.Handler) {
+		ctx := context.Background()
+		// The handler net/http passes here carries the per-connection
+		// context built by ConnContext.
+		if bc, ok := h.(interface{ BaseContext() context.Context }); ok {
+			ctx = bc.BaseContext()
+		}
+		conn := &Conn{Conn: tc}
+		h2.ServeConn(conn, &http2.ServeConnOpts{
+			Context:    context.WithValue(ctx, contextKey{}, conn),
+			BaseConfig: hs,
+			Handler:    h,
+		})
+	}
+}
+
+// FromContext returns the HTTP/2 fingerprint of the connection that
+// carried the request. It reports false for HTTP/1.x requests.
+func FromContext(ctx context.Context) (*Fingerprint, bool) {
+	conn, ok := ctx.Value(contextKey{}).(*Conn)
+	if !ok {
+		return nil, false
+	}
+	fp := conn.Fingerprint()
+	return fp, fp != nil
+}
@@ -0,0 +1,203 @@
+package h2fp
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+const clientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+const (
+	frameHeaders      = 0x1
+	framePriority     = 0x2
+	frameSettings     = 0x4
+	frameWindowUpdate = 0x8
+	frameContinuation = 0x9
+
+	flagAck        = 0x1
+	flagEndHeaders = 0x4
+	flagPadded     = 0x8
+	flagPriority   = 0x20
+)
+
+var (
+	errIncomplete = errors.New("h2fp: incomplete preface")
+	errNotHTTP2   = errors.New("h2fp: not an HTTP/2 client preface")
+)
+
+type Setting struct {
+	ID    uint16
+	Value uint32
+}
+
+type Priority struct {
+	StreamID  uint32
+	Exclusive bool
+	DependsOn uint32
+	Weight    uint8
+}
+
+// Fingerprint describes the start of a client's HTTP/2 connection: its
+// first SETTINGS frame, the connection-level WINDOW_UPDATE, any PRIORITY
+// frames sent before the first request and that request's pseudo-header
+// order.
+type Fingerprint struct {
+	Settings      []Setting
+	WindowUpdate  uint32
+	Priorities    []Priority
+	PseudoHeaders []string
+}
+
+// String renders the Akamai format:
+// SETTINGS|WINDOW_UPDATE|PRIORITY|PSEUDO_HEADERS, e.g.
+// "1:65536;2:0;4:6291456;6:262144|15663105|0|m,a,s,p".
+func (f *Fingerprint) String() string {
+	settings := make([]string, 0, len(f.Settings))
+	for _, s := range f.Settings {
+		settings = append(settings, fmt.Sprintf("%d:%d", s.ID, s.Value))
+	}
+	wu := "00"
+	if f.WindowUpdate != 0 {
+		wu = strconv.FormatUint(uint64(f.WindowUpdate), 10)
+	}
+	prios := make([]string, 0, len(f.Priorities))
+	for _, p := range f.Priorities {
+		excl := 0
+		if p.Exclusive {
+			excl = 1
+		}
+		prios = append(prios, fmt.Sprintf("%d:%d:%d:%d", p.StreamID, excl, p.DependsOn, int(p.Weight)+1))
+	}
+	prio := "0"
+	if len(prios) > 0 {
+		prio = strings.Join(prios, ",")
+	}
+	return strings.Join([]string{
+		strings.Join(settings, ";"),
+		wu,
+		prio,
+		f.PseudoOrder(),
+	}, "|")
+}
+
+// Hash is the MD5 of String, for compact storage and lookups.
+func (f *Fingerprint) Hash() string {
+	sum := md5.Sum([]byte(f.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// PseudoOrder returns the pseudo-header order as initials, e.g. "m,a,s,p".
+func (f *Fingerprint) PseudoOrder() string {
+	initials := make([]string, 0, len(f.PseudoHeaders))
+	for _, h := range f.PseudoHeaders {
+		if len(h) > 1 {
+			initials = append(initials, h[1:2])
+		}
+	}
+	return strings.Join(initials, ",")
+}
+
+// parse reads frames from the start of a plaintext HTTP/2 stream until the
+// first request's header block is complete. It returns errIncomplete while
+// more bytes are needed.
+func parse(data []byte) (*Fingerprint, error) {
+	if len(data) < len(clientPreface) {
+		if !bytes.HasPrefix([]byte(clientPreface), data) {
+			return nil, errNotHTTP2
+		}
+		return nil, errIncomplete
+	}
+	if string(data[:len(clientPreface)]) != clientPreface {
+		return nil, errNotHTTP2
+	}
+	data = data[len(clientPreface):]
+
+	fp := &Fingerprint{}
+	seenSettings := false
+	var block []byte
+	for {
+		if len(data) < 9 {
+			return nil, errIncomplete
+		}
+		length := int(data[0])<<16 | int(data[1])<<8 | int(data[2])
+		typ, flags := data[3], data[4]
+		stream := binary.BigEndian.Uint32(data[5:9]) & 0x7fffffff
+		if len(data) < 9+length {
+			return nil, errIncomplete
+		}
+		payload := data[9 : 9+length]
+		data = data[9+length:]
+
+		switch typ {
+		case frameSettings:
+			if flags&flagAck != 0 || seenSettings {
+				continue
+			}
+			seenSettings = true
+			for i := 0; i+6 <= len(payload); i += 6 {
+				fp.Settings = append(fp.Settings, Setting{
+					ID:    binary.BigEndian.Uint16(payload[i:]),
+					Value: binary.BigEndian.Uint32(payload[i+2:]),
+				})
+			}
+		case frameWindowUpdate:
+			if stream == 0 && len(payload) >= 4 && fp.WindowUpdate == 0 {
+				fp.WindowUpdate = binary.BigEndian.Uint32(payload) & 0x7fffffff
+			}
+		case framePriority:
+			if len(payload) >= 5 {
+				dep := binary.BigEndian.Uint32(payload)
+				fp.Priorities = append(fp.Priorities, Priority{
+					StreamID:  stream,
+					Exclusive: dep&0x80000000 != 0,
+					DependsOn: dep & 0x7fffffff,
+					Weight:    payload[4],
+				})
+			}
+		case frameHeaders:
+			if flags&flagPadded != 0 {
+				if len(payload) < 1 || int(payload[0]) > len(payload)-1 {
+					return nil, errNotHTTP2
+				}
+				pad := int(payload[0])
+				payload = payload[1 : len(payload)-pad]
+			}
+			if flags&flagPriority != 0 {
+				if len(payload) < 5 {
+					return nil, errNotHTTP2
+				}
+				payload = payload[5:]
+			}
+			block = append(block, payload...)
+			if flags&flagEndHeaders != 0 {
+				fp.PseudoHeaders = pseudoHeaders(block)
+				return fp, nil
+			}
+		case frameContinuation:
+			block = append(block, payload...)
+			if flags&flagEndHeaders != 0 {
+				fp.PseudoHeaders = pseudoHeaders(block)
+				return fp, nil
+			}
+		}
+	}
+}
+
+func pseudoHeaders(block []byte) []string {
+	var names []string
+	dec := hpack.NewDecoder(4096, func(f hpack.HeaderField) {
+		if strings.HasPrefix(f.Name, ":") {
+			names = append(names, f.Name)
+		}
+	})
+	dec.Write(block)
+	return names
+}
@@ -0,0 +1,136 @@
+package h2fp
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// chromePreface writes the start of a connection as Chrome sends it.
+func chromePreface(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	buf.WriteString(clientPreface)
+	fr := http2.NewFramer(&buf, nil)
+	fr.WriteSettings(
+		http2.Setting{ID: http2.SettingHeaderTableSize, Val: 65536},
+		http2.Setting{ID: http2.SettingEnablePush, Val: 0},
+		http2.Setting{ID: http2.SettingInitialWindowSize, Val: 6291456},
+		http2.Setting{ID: http2.SettingMaxHeaderListSize, Val: 262144},
+	)
+	fr.WriteWindowUpdate(0, 15663105)
+
+	var block bytes.Buffer
+	enc := hpack.NewEncoder(&block)
+	for _, f := range [][2]string{{":method", "GET"}, {":authority", "example.com"}, {":scheme", "https"}, {":path", "/"}, {"user-agent", "test"}} {
+		enc.WriteField(hpack.HeaderField{Name: f[0], Value: f[1]})
+	}
+	// Split the block to exercise CONTINUATION.
+	half := block.Len() / 2
+	fr.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      1,
+		BlockFragment: block.Bytes()[:half],
+		EndStream:     true,
+		Priority:      http2.PriorityParam{StreamDep: 0, Exclusive: true, Weight: 255},
+	})
+	fr.WriteContinuation(1, true, block.Bytes()[half:])
+	return buf.Bytes()
+}
+
+func TestParse(t *testing.T) {
+	fp, err := parse(chromePreface(t))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if want := "1:65536;2:0;4:6291456;6:262144|15663105|0|m,a,s,p"; fp.String() != want {
+		t.Errorf("String = %q, want %q", fp.String(), want)
+	}
+	if len(fp.Hash()) != 32 {
+		t.Errorf("Hash = %q", fp.Hash())
+	}
+}
+
+func TestParsePriorityFrames(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString(clientPreface)
+	fr := http2.NewFramer(&buf, nil)
+	fr.WriteSettings(http2.Setting{ID: http2.SettingInitialWindowSize, Val: 131072})
+	fr.WritePriority(3, http2.PriorityParam{StreamDep: 0, Weight: 200})
+	fr.WritePriority(5, http2.PriorityParam{StreamDep: 3, Exclusive: true, Weight: 100})
+	var block bytes.Buffer
+	enc := hpack.NewEncoder(&block)
+	for _, name := range []string{":method", ":path", ":authority", ":scheme"} {
+		enc.WriteField(hpack.HeaderField{Name: name, Value: "x"})
+	}
+	fr.WriteHeaders(http2.HeadersFrameParam{StreamID: 1, BlockFragment: block.Bytes(), EndHeaders: true})
+
+	fp, err := parse(buf.Bytes())
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if want := "4:131072|00|3:0:0:201,5:1:3:101|m,p,a,s"; fp.String() != want {
+		t.Errorf("String = %q, want %q", fp.String(), want)
+	}
+}
+
+func TestParseIncomplete(t *testing.T) {
+	data := chromePreface(t)
+	for _, n := range []int{0, 10, len(clientPreface), len(data) - 1} {
+		if _, err := parse(data[:n]); err != errIncomplete {
+			t.Errorf("parse(%d of %d bytes) error = %v, want errIncomplete", n, len(data), err)
+		}
+	}
+	if _, err := parse([]byte("GET / HTTP/1.1\r\n")); err != errNotHTTP2 {
+		t.Errorf("HTTP/1.1 request: error = %v, want errNotHTTP2", err)
+	}
+}
+
+func TestConnRecordsAcrossReads(t *testing.T) {
+	data := chromePreface(t)
+	c := &Conn{}
+	for i := range data {
+		c.record(data[i : i+1])
+	}
+	if fp := c.Fingerprint(); fp == nil || fp.PseudoOrder() != "m,a,s,p" {
+		t.Fatalf("Fingerprint fed a byte at a time = %+v", fp)
+	}
+}
+
+// TestConfigureServer fingerprints a real HTTP/2 connection.
+func TestConfigureServer(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fp, ok := FromContext(r.Context())
+		if !ok {
+			http.Error(w, "no fingerprint", http.StatusInternalServerError)
+			return
+		}
+		io.WriteString(w, fp.String())
+	}))
+	ConfigureServer(srv.Config, nil)
+	srv.TLS = srv.Config.TLSConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	tr := &http2.Transport{TLSClientConfig: srv.Client().Transport.(*http.Transport).TLSClientConfig.Clone()}
+	tr.TLSClientConfig.NextProtos = []string{"h2"}
+	client := &http.Client{Transport: tr}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || resp.ProtoMajor != 2 {
+		t.Fatalf("response %s %d %q", resp.Proto, resp.StatusCode, body)
+	}
+	parts := strings.Split(string(body), "|")
+	if len(parts) != 4 || parts[0] == "" || len(strings.Split(parts[3], ",")) != 4 {
+		t.Errorf("fingerprint %q lacks settings or pseudo-headers", body)
+	}
+}
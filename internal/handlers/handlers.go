@@ -2,58 +2,50 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
-	"strings"
+	"time"
 
+	"janus/internal/behavior"
+	"janus/internal/clientip"
+	"janus/internal/store"
 	"janus/internal/types"
 )
 
-func HandleFingerprint(store *types.FingerprintStore) http.HandlerFunc {
+// maxFingerprintBody leaves room for the canvas data URL and behaviour
+// samples.
+const maxFingerprintBody = 256 << 10
+
+// HandleFingerprint stores the posted fingerprint under the visitor's
+// session ID, which visitorID returns (issuing one if needed), and logs to
+// logger. storeFailed answers the request if the fingerprint cannot be
+// stored.
+func HandleFingerprint(fps store.Fingerprints, ttl time.Duration, visitorID func(http.ResponseWriter, *http.Request) string, storeFailed func(http.ResponseWriter, error), logger *log.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var fp types.Fingerprint
-		if err := json.NewDecoder(r.Body).Decode(&fp); err != nil {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxFingerprintBody)).Decode(&fp); err != nil {
+			logger.Printf("HandleFingerprint: Invalid fingerprint from %s: %v", clientip.FromRequest(r), err)
+			var tooBig *http.MaxBytesError
+			if errors.As(err, &tooBig) {
+				http.Error(w, "fingerprint payload too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "invalid fingerprint payload", http.StatusBadRequest)
 			return
 		}
+		behavior.Trim(fp.Behavior)
 
-		fp.ClientIP = getClientIP(r)
+		fp.ClientIP = clientip.FromRequest(r)
+		id := visitorID(w, r)
 
-		store.Lock()
-		store.Data[fp.ClientIP] = fp
-		store.Unlock()
+		if err := fps.PutFingerprint(r.Context(), id, &fp, ttl); err != nil {
+			logger.Printf("HandleFingerprint: Failed to store fingerprint for %s: %v", fp.ClientIP, err)
+			storeFailed(w, err)
+			return
+		}
 
-		log.Printf("HandleFingerprint: Stored fingerprint for %s: %+v", fp.ClientIP, fp)
+		logger.Printf("HandleFingerprint: Stored fingerprint for %s (session %s): %+v", fp.ClientIP, id, fp)
 		w.WriteHeader(http.StatusOK)
 	}
 }
-
-func getClientIP(r *http.Request) string {
-	log.Printf("getClientIP: X-Forwarded-For: %s, RemoteAddr: %s", r.Header.Get("X-Forwarded-For"), r.RemoteAddr)
-	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
-		ip := strings.Split(forwarded, ",")[0]
-		ip = strings.TrimSpace(ip)
-		if ip != "" && ip != "[" {
-			return ip
-		}
-	}
-	addr := r.RemoteAddr
-	if strings.HasPrefix(addr, "[") {
-		end := strings.LastIndex(addr, "]")
-		if end != -1 {
-			ip := addr[1:end]
-			if ip != "" {
-				return ip
-			}
-		}
-	} else {
-		parts := strings.Split(addr, ":")
-		if len(parts) > 0 {
-			ip := strings.TrimSpace(parts[0])
-			if ip != "" {
-				return ip
-			}
-		}
-	}
-	log.Printf("getClientIP: Falling back to default IP")
-	return "unknown"
-}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"janus/internal/store"
+	"janus/internal/types"
+)
+
+// failingFingerprints is a fingerprint store whose writes fail.
+type failingFingerprints struct{ store.Fingerprints }
+
+func (failingFingerprints) PutFingerprint(context.Context, string, *types.Fingerprint, time.Duration) error {
+	return errors.New("disk full")
+}
+
+func handler(fps store.Fingerprints, failed *error) http.HandlerFunc {
+	visitor := func(http.ResponseWriter, *http.Request) string { return "visitor-1" }
+	storeFailed := func(w http.ResponseWriter, err error) {
+		*failed = err
+		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+	}
+	return HandleFingerprint(fps, time.Minute, visitor, storeFailed, log.New(io.Discard, "", 0))
+}
+
+func post(h http.Handler, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/janus/fingerprint", strings.NewReader(body))
+	r.RemoteAddr = "192.0.2.1:40000"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestHandleFingerprint(t *testing.T) {
+	fps := store.NewMemory(0)
+	var failed error
+	if w := post(handler(fps, &failed), `{"canvas_hash":"abc","webdriver":true}`); w.Code != http.StatusOK {
+		t.Fatalf("POST: %d %s", w.Code, w.Body)
+	}
+	fp, err := fps.GetFingerprint(context.Background(), "visitor-1")
+	if err != nil {
+		t.Fatalf("fingerprint not stored under the visitor ID: %v", err)
+	}
+	// The client IP comes from the connection, not the body.
+	if fp.CanvasHash != "abc" || !fp.Webdriver || fp.ClientIP != "192.0.2.1" {
+		t.Errorf("stored %+v", fp)
+	}
+}
+
+func TestHandleFingerprintRejectsBadBodies(t *testing.T) {
+	fps := store.NewMemory(0)
+	var failed error
+	h := handler(fps, &failed)
+	for name, tt := range map[string]struct {
+		body string
+		want int
+	}{
+		"not JSON":  {"canvas=abc", http.StatusBadRequest},
+		"truncated": {`{"canvas_hash":`, http.StatusBadRequest},
+		"too big":   {`{"fonts":"` + strings.Repeat("a", maxFingerprintBody) + `"}`, http.StatusRequestEntityTooLarge},
+	} {
+		if w := post(h, tt.body); w.Code != tt.want || w.Body.Len() == 0 {
+			t.Errorf("%s: %d %q, want %d with a message", name, w.Code, w.Body, tt.want)
+		}
+		if _, err := fps.GetFingerprint(context.Background(), "visitor-1"); err == nil {
+			t.Errorf("%s: fingerprint stored", name)
+		}
+	}
+}
+
+func TestHandleFingerprintStoreFailure(t *testing.T) {
+	var failed error
+	w := post(handler(failingFingerprints{}, &failed), `{"canvas_hash":"abc"}`)
+	if w.Code != http.StatusServiceUnavailable || failed == nil {
+		t.Errorf("store failure: %d, storeFailed got %v", w.Code, failed)
+	}
+}
@@ -0,0 +1,154 @@
+package janus
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"time"
+
+	"janus/internal/config"
+	"janus/internal/detect"
+)
+
+// action is a compiled config.ActionBand.
+type action struct {
+	config.ActionBand
+	tmpl *template.Template
+}
+
+// compileActions sorts the ladder by score and loads each band's template,
+// falling back to a plain-text response when a template cannot be parsed.
+func (j *Janus) compileActions(bands []config.ActionBand) ([]action, error) {
+	if len(bands) == 0 {
+		bands = config.DefaultConfig().Actions
+	}
+	out := make([]action, 0, len(bands))
+	for _, b := range bands {
+		switch b.Action {
+		case detect.ActionAllow:
+		case detect.ActionInvisible, detect.ActionInteractive:
+			if b.Template == "" {
+				b.Template = "assets/challenge.html"
+			}
+		case detect.ActionBlock:
+			if b.Status == 0 {
+				b.Status = http.StatusForbidden
+			}
+		case detect.ActionTarpit:
+			if b.Status == 0 {
+				b.Status = http.StatusForbidden
+			}
+			if b.Delay <= 0 {
+				b.Delay = 10 * time.Second
+			}
+		default:
+			return nil, fmt.Errorf("janus: unknown action %q", b.Action)
+		}
+		if b.Status == 0 {
+			b.Status = http.StatusOK
+		}
+		a := action{ActionBand: b}
+		if b.Template != "" {
+			tmpl, err := template.ParseFiles(b.Template)
+			if err != nil {
+				j.logger.Printf("compileActions: Failed to load template %s for %s: %v", b.Template, b.Action, err)
+			} else {
+				a.tmpl = tmpl
+			}
+		}
+		out = append(out, a)
+	}
+	sort.SliceStable(out, func(a, b int) bool { return out[a].MinScore < out[b].MinScore })
+	return out, nil
+}
+
+// actionFor picks the band for d. Whitelisted requests are always allowed.
+func (j *Janus) actionFor(d *detect.Decision) action {
+	if d.Whitelisted {
+		return action{ActionBand: config.ActionBand{Action: detect.ActionAllow}}
+	}
+	chosen := j.actions[0]
+	for _, a := range j.actions {
+		if d.Score >= a.MinScore {
+			chosen = a
+		}
+	}
+	return chosen
+}
+
+// compileNamedActions indexes the ladder's bands by action, compiling a
+// default band for each action the ladder does not use, so routes'
+// min_action and the monitoring action are looked up rather than compiled
+// per request.
+func (j *Janus) compileNamedActions() (map[string]action, error) {
+	named := make(map[string]action, len(actionRank))
+	for _, a := range j.actions {
+		if _, ok := named[a.Action]; !ok {
+			named[a.Action] = a
+		}
+	}
+	for name := range actionRank {
+		if _, ok := named[name]; ok {
+			continue
+		}
+		acts, err := j.compileActions([]config.ActionBand{{Action: name}})
+		if err != nil {
+			return nil, err
+		}
+		named[name] = acts[0]
+	}
+	return named, nil
+}
+
+// actionNamed returns the band for name. Names are checked at startup, so
+// an unknown one is a bug; it is logged and blocked.
+func (j *Janus) actionNamed(name string) action {
+	if a, ok := j.namedActions[name]; ok {
+		return a
+	}
+	j.logger.Printf("actionNamed: Unknown action %q, blocking", name)
+	return action{ActionBand: config.ActionBand{Action: detect.ActionBlock, Status: http.StatusForbidden}}
+}
+
+// respond renders a non-allow action. Tarpits hold the connection for
+// their delay first, giving up early if the client goes away.
+func (j *Janus) respond(w http.ResponseWriter, r *http.Request, a action, d *detect.Decision) {
+	if a.Action == detect.ActionTarpit {
+		timer := time.NewTimer(a.Delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if a.Action == detect.ActionInvisible || a.Action == detect.ActionInteractive {
+		j.ensureVisitorID(w, r)
+	}
+
+	if a.tmpl == nil {
+		msg := "Verification required"
+		if a.Action == detect.ActionBlock || a.Action == detect.ActionTarpit {
+			msg = "Access denied"
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(a.Status)
+		fmt.Fprintf(w, "%s (reference %s)\n", msg, d.ID)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := a.tmpl.Execute(&buf, d); err != nil {
+		j.logger.Printf("respond: Failed to render %s template: %v", a.Action, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(a.Status)
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,119 @@
+package janus
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"janus/internal/config"
+	"janus/internal/detect"
+)
+
+func TestActionLadder(t *testing.T) {
+	j := testJanus(t, func(cfg *config.JanusConfig) {
+		cfg.Actions = []config.ActionBand{
+			{Action: detect.ActionBlock, MinScore: 90},
+			{Action: detect.ActionAllow},
+			{Action: detect.ActionInteractive, MinScore: 60},
+			{Action: detect.ActionInvisible, MinScore: 30},
+		}
+	})
+	tests := []struct {
+		score       int
+		whitelisted bool
+		want        string
+	}{
+		{0, false, detect.ActionAllow},
+		{29, false, detect.ActionAllow},
+		{30, false, detect.ActionInvisible},
+		{60, false, detect.ActionInteractive},
+		{200, false, detect.ActionBlock},
+		{200, true, detect.ActionAllow},
+	}
+	for _, tt := range tests {
+		d := &detect.Decision{Score: tt.score, Whitelisted: tt.whitelisted}
+		if got := j.actionFor(d).Action; got != tt.want {
+			t.Errorf("score %d whitelisted %v: %s, want %s", tt.score, tt.whitelisted, got, tt.want)
+		}
+	}
+	if a := j.actionFor(&detect.Decision{Score: 95}); a.Status != http.StatusForbidden {
+		t.Errorf("block status defaults to %d, want 403", a.Status)
+	}
+}
+
+func TestUnknownActionRejected(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.StoreBackend = "memory"
+	cfg.Actions = []config.ActionBand{{Action: "captcha"}}
+	if j, err := New(Options{Config: cfg, GeoIPPath: "testdata/missing.mmdb", SigningKey: []byte(strings.Repeat("k", 32)), Logger: log.New(io.Discard, "", 0)}); err == nil {
+		j.Close()
+		t.Fatal("New accepted an unknown action")
+	}
+}
+
+func TestBlockResponse(t *testing.T) {
+	j := testJanus(t, func(cfg *config.JanusConfig) {
+		cfg.Actions = []config.ActionBand{{Action: detect.ActionBlock, Status: http.StatusTeapot}}
+	})
+	w := newClient(t, j, "192.0.2.1").do(http.MethodGet, "/", nil)
+	if w.Code != http.StatusTeapot || !strings.HasPrefix(w.Body.String(), "Access denied (reference ") {
+		t.Errorf("block: %d %q", w.Code, w.Body)
+	}
+	if w.Header().Get("Cache-Control") != "no-store" {
+		t.Error("block response is cacheable")
+	}
+}
+
+func TestTarpit(t *testing.T) {
+	j := testJanus(t, func(cfg *config.JanusConfig) {
+		cfg.Actions = []config.ActionBand{{Action: detect.ActionTarpit, Delay: 100 * time.Millisecond}}
+	})
+	c := newClient(t, j, "192.0.2.1")
+
+	start := time.Now()
+	w := c.do(http.MethodGet, "/", nil)
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("tarpit answered after %v, want at least its delay", elapsed)
+	}
+	if w.Code != http.StatusForbidden {
+		t.Errorf("tarpit: %d, want 403", w.Code)
+	}
+
+	// A client that hangs up is let go without an answer.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	w = httptest.NewRecorder()
+	start = time.Now()
+	c.h.ServeHTTP(w, c.request(http.MethodGet, "/", nil).WithContext(ctx))
+	if elapsed := time.Since(start); elapsed >= 100*time.Millisecond {
+		t.Errorf("tarpit held a gone client for %v", elapsed)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("tarpit wrote %q to a gone client", w.Body)
+	}
+}
+
+func TestActionNamed(t *testing.T) {
+	j := testJanus(t, func(cfg *config.JanusConfig) {
+		cfg.Actions = []config.ActionBand{{Action: detect.ActionAllow}, {Action: detect.ActionBlock, MinScore: 90, Status: http.StatusTeapot}}
+	})
+	for name := range actionRank {
+		if a := j.actionNamed(name); a.Action != name {
+			t.Errorf("actionNamed(%s) = %s", name, a.Action)
+		}
+	}
+	if a := j.actionNamed(detect.ActionBlock); a.Status != http.StatusTeapot {
+		t.Errorf("block from the ladder answers %d, want its configured 418", a.Status)
+	}
+	if a := j.actionNamed(detect.ActionTarpit); a.Delay != 10*time.Second || a.Status != http.StatusForbidden {
+		t.Errorf("default tarpit %+v, want a 10s delay and 403", a.ActionBand)
+	}
+	if a := j.actionNamed("captcha"); a.Action != detect.ActionBlock {
+		t.Errorf("unknown action %s, want block", a.Action)
+	}
+}
@@ -0,0 +1,180 @@
+package janus
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/netip"
+
+	"janus/internal/clientip"
+	"janus/internal/config"
+	"janus/internal/detect"
+	"janus/internal/routes"
+	"janus/internal/tlsfp"
+	"janus/internal/types"
+)
+
+// Token binding modes.
+const (
+	bindIP          = "ip"
+	bindSubnet      = "subnet"
+	bindASN         = "asn"
+	bindFingerprint = "fingerprint"
+)
+
+// binding is what a request looks like to the token binding checks. Only
+// the parts for the configured modes are filled in.
+type binding struct {
+	IP     string
+	Subnet string
+	ASN    uint
+	// Fingerprint hashes the device part with the connection's JA4.
+	Fingerprint string
+}
+
+// bindingModes validates modes and returns them as a set.
+func bindingModes(cfg config.TokenBindingConfig) (map[string]bool, error) {
+	modes := make(map[string]bool)
+	for _, m := range cfg.Modes {
+		switch m {
+		case bindIP, bindSubnet, bindASN, bindFingerprint:
+			modes[m] = true
+		default:
+			return nil, fmt.Errorf("janus: unknown token binding mode %q", m)
+		}
+	}
+	if cfg.Grace && !modes[bindFingerprint] {
+		// Without it a stolen token could be rebound to any network.
+		return nil, fmt.Errorf("janus: token_binding.grace needs the %q mode", bindFingerprint)
+	}
+	if cfg.IPv4Prefix < 0 || cfg.IPv4Prefix > 32 || cfg.IPv6Prefix < 0 || cfg.IPv6Prefix > 128 {
+		return nil, fmt.Errorf("janus: invalid token binding prefix /%d, /%d", cfg.IPv4Prefix, cfg.IPv6Prefix)
+	}
+	return modes, nil
+}
+
+// deviceHash condenses the parts of a browser fingerprint that only the
+// sensor can see. It is kept in the session so later requests, which carry
+// no fingerprint, can be checked against it.
+func deviceHash(fp *types.Fingerprint) string {
+	if fp == nil {
+		return ""
+	}
+	return shortHash(fp.CanvasHash + "|" + fp.WebGLRenderer)
+}
+
+func shortHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return base64.RawURLEncoding.EncodeToString(sum[:16])
+}
+
+// bindingFor describes r for the configured modes. device is the session's
+// deviceHash, needed for fingerprint binding. JA4 rather than JA3 is used
+// because browsers shuffle extension order, which changes JA3 on every
+// connection.
+func (j *Janus) bindingFor(r *http.Request, device string) binding {
+	b := binding{IP: clientip.FromRequest(r)}
+	addr, err := netip.ParseAddr(b.IP)
+	if err == nil {
+		addr = addr.Unmap()
+		if j.bindModes[bindSubnet] {
+			bits := j.cfg.TokenBinding.IPv6Prefix
+			if addr.Is4() {
+				bits = j.cfg.TokenBinding.IPv4Prefix
+			}
+			if p, err := addr.Prefix(bits); err == nil {
+				b.Subnet = p.String()
+			}
+		}
+		if j.bindModes[bindASN] && j.asnDB != nil {
+			if rec, err := j.asnDB.ASN(addr); err == nil {
+				b.ASN = rec.AutonomousSystemNumber
+			}
+		}
+	}
+	if j.bindModes[bindFingerprint] {
+		ja4 := ""
+		if fp, ok := tlsfp.FromContext(r.Context()); ok {
+			ja4 = fp.JA4
+		}
+		b.Fingerprint = shortHash(device + "|" + ja4)
+	}
+	return b
+}
+
+// setClaims records b in token claims. ip is always set; it names the
+// address the token was issued to for logs and revocation.
+func (b binding) setClaims(claims map[string]interface{}, modes map[string]bool) {
+	claims["ip"] = b.IP
+	if modes[bindSubnet] {
+		claims["net"] = b.Subnet
+	}
+	if modes[bindASN] {
+		claims["asn"] = b.ASN
+	}
+	if modes[bindFingerprint] {
+		claims["fp"] = b.Fingerprint
+	}
+}
+
+// checkBinding compares the claims against the current request. network
+// reports whether the ip/subnet/asn bindings hold, device whether the
+// fingerprint binding does.
+func (j *Janus) checkBinding(claims map[string]interface{}, cur binding) (network, device bool, reason string) {
+	network, device = true, true
+	if j.bindModes[bindIP] {
+		if ip, _ := claims["ip"].(string); ip != cur.IP {
+			network, reason = false, fmt.Sprintf("ip %s, token ip %s", cur.IP, ip)
+		}
+	}
+	if j.bindModes[bindSubnet] {
+		if subnet, _ := claims["net"].(string); subnet != cur.Subnet {
+			network, reason = false, fmt.Sprintf("subnet %s, token subnet %s", cur.Subnet, subnet)
+		}
+	}
+	if j.bindModes[bindASN] {
+		// JSON numbers decode as float64.
+		if asn, _ := claims["asn"].(float64); uint(asn) != cur.ASN {
+			network, reason = false, fmt.Sprintf("AS%d, token AS%d", cur.ASN, uint(asn))
+		}
+	}
+	if j.bindModes[bindFingerprint] {
+		if fp, _ := claims["fp"].(string); fp != cur.Fingerprint {
+			device, reason = false, "fingerprint changed"
+		}
+	}
+	return network, device, reason
+}
+
+// rebindToken is grace mode: a token that is valid except that the network
+// it is bound to drifted is re-scored silently and, if the request would
+// not need an interactive challenge on route, re-issued for the current
+// network. Only tokens whose device fingerprint was checked against the
+// session qualify.
+func (j *Janus) rebindToken(w http.ResponseWriter, r *http.Request, tok *verifiedToken, route *routes.Route) bool {
+	if tok.session == nil {
+		j.logger.Printf("rebindToken: Not rebinding token %s without its session", tok.id)
+		return false
+	}
+	d := j.decide(r)
+	a := j.actionForRoute(d, route)
+	if a.Action != detect.ActionAllow && a.Action != detect.ActionInvisible {
+		j.logger.Printf("rebindToken: Not rebinding token %s for IP %s, action %s", tok.id, d.ClientIP, a.Action)
+		return false
+	}
+
+	claims := make(map[string]interface{}, len(tok.claims))
+	for k, v := range tok.claims {
+		claims[k] = v
+	}
+	tok.current.setClaims(claims, j.bindModes)
+	if err := j.issueToken(w, claims, tok.expires); err != nil {
+		j.logger.Printf("rebindToken: Failed to re-issue token %s: %v", tok.id, err)
+		return false
+	}
+	tok.claims = claims
+	j.metrics.Inc("token_rebinds")
+	j.logger.Printf("rebindToken: Rebound token %s to IP %s (score %d)", tok.id, d.ClientIP, d.Score)
+	return true
+}
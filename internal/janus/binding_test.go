@@ -0,0 +1,113 @@
+package janus
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"janus/internal/config"
+)
+
+func TestBindingModesRejectsBadConfig(t *testing.T) {
+	for name, cfg := range map[string]config.TokenBindingConfig{
+		"unknown mode":  {Modes: []string{"cookie"}},
+		"ipv4 prefix":   {Modes: []string{"subnet"}, IPv4Prefix: 33},
+		"ipv6 prefix":   {Modes: []string{"subnet"}, IPv6Prefix: -1},
+		"grace without": {Modes: []string{"subnet"}, Grace: true},
+	} {
+		if _, err := bindingModes(cfg); err == nil {
+			t.Errorf("%s: bindingModes accepted %+v", name, cfg)
+		}
+	}
+}
+
+// TestTokenBinding solves a challenge on one address and replays the token
+// from another.
+func TestTokenBinding(t *testing.T) {
+	cases := []struct {
+		name     string
+		modes    []string
+		from, to string
+		served   bool
+	}{
+		{"ip same", []string{"ip"}, "192.0.2.1", "192.0.2.1", true},
+		{"ip moved", []string{"ip"}, "192.0.2.1", "192.0.2.2", false},
+		{"subnet same /24", []string{"subnet"}, "192.0.2.1", "192.0.2.200", true},
+		{"subnet moved", []string{"subnet"}, "192.0.2.1", "198.51.100.1", false},
+		{"subnet same /64", []string{"subnet"}, "2001:db8:1:2::1", "2001:db8:1:2:ffff::9", true},
+		{"subnet moved /64", []string{"subnet"}, "2001:db8:1:2::1", "2001:db8:1:3::1", false},
+		{"unbound", nil, "192.0.2.1", "198.51.100.1", true},
+		{"all must match", []string{"subnet", "ip"}, "192.0.2.1", "192.0.2.2", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			j := invisibleJanus(t, func(cfg *config.JanusConfig) {
+				cfg.TokenBinding = config.TokenBindingConfig{Modes: tc.modes, IPv4Prefix: 24, IPv6Prefix: 64}
+			})
+			c := newClient(t, j, tc.from)
+			c.solve("/")
+			c.ip = tc.to
+			if got := c.served("/"); got != tc.served {
+				t.Errorf("token from %s served on %s = %v, want %v", tc.from, tc.to, got, tc.served)
+			}
+		})
+	}
+}
+
+func TestBindingClaims(t *testing.T) {
+	j := invisibleJanus(t, func(cfg *config.JanusConfig) {
+		cfg.TokenBinding = config.TokenBindingConfig{Modes: []string{"subnet", "fingerprint"}, IPv4Prefix: 16, IPv6Prefix: 48}
+	})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "192.0.2.1:40000"
+	b := j.bindingFor(r, "device")
+	if b.Subnet != "192.0.0.0/16" || b.Fingerprint == "" {
+		t.Fatalf("binding = %+v, want subnet 192.0.0.0/16 and a fingerprint", b)
+	}
+	claims := map[string]interface{}{}
+	b.setClaims(claims, j.bindModes)
+	if claims["ip"] != "192.0.2.1" || claims["net"] != "192.0.0.0/16" || claims["fp"] != b.Fingerprint {
+		t.Errorf("claims = %v", claims)
+	}
+	if _, ok := claims["asn"]; ok {
+		t.Errorf("asn claim set without the asn mode: %v", claims)
+	}
+
+	if network, device, _ := j.checkBinding(claims, b); !network || !device {
+		t.Errorf("binding does not match itself: network %v, device %v", network, device)
+	}
+	// Another device on the same network keeps the network binding only.
+	network, device, reason := j.checkBinding(claims, j.bindingFor(r, "other"))
+	if !network || device || reason == "" {
+		t.Errorf("other device: network %v, device %v, reason %q", network, device, reason)
+	}
+	r.RemoteAddr = "198.51.100.1:40000"
+	network, device, _ = j.checkBinding(claims, j.bindingFor(r, "device"))
+	if network || !device {
+		t.Errorf("other network: network %v, device %v", network, device)
+	}
+}
+
+func TestASNBinding(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.StoreBackend = "memory"
+	cfg.TLSFingerprintDB = ""
+	cfg.TokenBinding.Modes = []string{"asn"}
+	if j, err := New(Options{Config: cfg, GeoIPPath: "testdata/missing.mmdb", ASNPath: "testdata/missing.mmdb", Logger: log.New(io.Discard, "", 0)}); err == nil {
+		j.Close()
+		t.Fatal("asn binding without an ASN database accepted")
+	}
+
+	j := invisibleJanus(t, nil)
+	j.bindModes = map[string]bool{bindASN: true}
+	// Claims round-trip through JSON, so numbers arrive as float64.
+	claims := map[string]interface{}{"asn": float64(64496)}
+	if network, _, _ := j.checkBinding(claims, binding{ASN: 64496}); !network {
+		t.Error("same AS rejected")
+	}
+	if network, _, reason := j.checkBinding(claims, binding{ASN: 64511}); network || reason != "AS64511, token AS64496" {
+		t.Errorf("other AS: network %v, reason %q", network, reason)
+	}
+}
@@ -0,0 +1,149 @@
+package janus
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"janus/internal/clientip"
+	"janus/internal/detect"
+	"janus/internal/h2fp"
+	"janus/internal/tlsfp"
+
+	"github.com/google/uuid"
+)
+
+// decide runs the detectors for r and returns an explained Decision with
+// no action set yet.
+func (j *Janus) decide(r *http.Request) *detect.Decision {
+	info := &detect.RequestInfo{
+		Request:   r,
+		ClientIP:  clientip.FromRequest(r),
+		UserAgent: r.Header.Get("User-Agent"),
+		JA3:       j.ja3Fingerprint(r),
+	}
+	if fp, ok := tlsfp.FromContext(r.Context()); ok {
+		info.TLS = fp
+	}
+	if fp, ok := h2fp.FromContext(r.Context()); ok {
+		info.HTTP2 = fp
+	}
+	if fp, err := j.lookupFingerprint(r.Context(), j.visitorID(r)); err == nil {
+		info.Fingerprint = fp
+	}
+
+	res := j.detectors.Evaluate(r.Context(), info)
+	d := &detect.Decision{
+		ID:          uuid.NewString(),
+		Time:        time.Now(),
+		ClientIP:    info.ClientIP,
+		Method:      r.Method,
+		Path:        r.URL.Path,
+		UserAgent:   info.UserAgent,
+		JA3:         info.JA3,
+		Signals:     res.Signals,
+		Score:       res.Score,
+		Threshold:   j.cfg.SuspicionThreshold,
+		Whitelisted: res.Allowed,
+	}
+	if d.Signals == nil {
+		d.Signals = []detect.Signal{}
+	}
+	if info.TLS != nil {
+		d.JA4 = info.TLS.JA4
+	}
+	if info.HTTP2 != nil {
+		d.HTTP2 = info.HTTP2.String()
+	}
+	return d
+}
+
+// recordDecision logs d as one structured line, keeps it for
+// /janus/debug/explain and echoes it to trusted clients when enabled.
+func (j *Janus) recordDecision(w http.ResponseWriter, r *http.Request, d *detect.Decision) {
+	if line, err := json.Marshal(d); err == nil {
+		j.logger.Printf("decision: %s", line)
+	}
+	j.history.Add(d)
+	if j.cfg.Debug.Header && j.isDebugTrusted(r) {
+		w.Header().Set("X-Janus-Debug", d.Header())
+	}
+}
+
+func (j *Janus) isDebugTrusted(r *http.Request) bool {
+	return inPrefixes(j.debugIPs, clientip.FromRequest(r))
+}
+
+func inPrefixes(prefixes []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleExplain returns recent decisions filtered by ?id= and/or ?ip=, or,
+// without filters, explains the calling request itself.
+func (j *Janus) handleExplain(w http.ResponseWriter, r *http.Request) {
+	if !j.isDebugTrusted(r) {
+		j.logger.Printf("handleExplain: Untrusted IP %s", clientip.FromRequest(r))
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	q := r.URL.Query()
+	id, ip := q.Get("id"), q.Get("ip")
+	var decisions []*detect.Decision
+	if id == "" && ip == "" {
+		d := j.decide(r)
+		d.Action = "explain"
+		decisions = []*detect.Decision{d}
+	} else {
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		if limit <= 0 {
+			limit = 20
+		}
+		decisions = j.history.Find(id, ip, limit)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"decisions": decisions}); err != nil {
+		j.logger.Printf("handleExplain: Failed to encode response: %v", err)
+	}
+}
+
+// handleVars serves the instance's counters (challenge replays, failures
+// and so on) to trusted clients.
+func (j *Janus) handleVars(w http.ResponseWriter, r *http.Request) {
+	if !j.isDebugTrusted(r) {
+		j.logger.Printf("handleVars: Untrusted IP %s", clientip.FromRequest(r))
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	j.metrics.ServeHTTP(w, r)
+}
+
+// parsePrefixes parses the addresses and CIDR ranges of the config list
+// name.
+func parsePrefixes(name string, entries []string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, e := range entries {
+		if p, err := netip.ParsePrefix(e); err == nil {
+			out = append(out, p.Masked())
+		} else if a, err := netip.ParseAddr(e); err == nil {
+			out = append(out, netip.PrefixFrom(a, a.BitLen()))
+		} else {
+			return nil, fmt.Errorf("janus: invalid %s entry %q", name, e)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,82 @@
+package janus
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+
+	"janus/internal/config"
+	"janus/internal/detect"
+)
+
+func TestDecisionExplained(t *testing.T) {
+	j := testJanus(t, func(cfg *config.JanusConfig) {
+		cfg.Debug.Header = true
+		cfg.Debug.TrustedIPs = []string{"192.0.2.0/24"}
+		cfg.Actions = []config.ActionBand{{Action: detect.ActionInvisible}}
+	})
+	c := newClient(t, j, "192.0.2.1")
+	w := c.do(http.MethodGet, "/page", nil)
+	header := w.Header().Get("X-Janus-Debug")
+	if !strings.Contains(header, "action=invisible") || !strings.Contains(header, "no_fingerprint:") {
+		t.Fatalf("X-Janus-Debug = %q", header)
+	}
+	id := strings.TrimPrefix(strings.SplitN(header, ";", 2)[0], "id=")
+
+	w = c.do(http.MethodGet, "/janus/debug/explain?id="+id, nil)
+	var body struct{ Decisions []detect.Decision }
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("explain: %d %s", w.Code, w.Body)
+	}
+	if len(body.Decisions) != 1 {
+		t.Fatalf("explain returned %d decisions, want 1", len(body.Decisions))
+	}
+	d := body.Decisions[0]
+	if d.ID != id || d.Path != "/page" || d.Action != detect.ActionInvisible || d.Score == 0 || len(d.Signals) == 0 {
+		t.Errorf("explained decision = %+v", d)
+	}
+
+	// Without filters the calling request itself is explained.
+	w = c.do(http.MethodGet, "/janus/debug/explain", nil)
+	if !strings.Contains(w.Body.String(), `"action":"explain"`) {
+		t.Errorf("self explanation: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestDecisionDebugTrustedOnly(t *testing.T) {
+	j := testJanus(t, func(cfg *config.JanusConfig) {
+		cfg.Debug.Header = true
+		cfg.Debug.TrustedIPs = []string{"192.0.2.0/24"}
+	})
+	c := newClient(t, j, "198.51.100.1")
+	if w := c.do(http.MethodGet, "/", nil); w.Header().Get("X-Janus-Debug") != "" {
+		t.Error("debug header sent to an untrusted client")
+	}
+	for _, path := range []string{"/janus/debug/explain", "/janus/debug/vars"} {
+		if w := c.do(http.MethodGet, path, nil); w.Code != http.StatusForbidden {
+			t.Errorf("%s from an untrusted client: %d, want 403", path, w.Code)
+		}
+	}
+}
+
+func TestBadIPListsRejected(t *testing.T) {
+	for name, edit := range map[string]func(*config.JanusConfig){
+		"blacklisted_ips":   func(cfg *config.JanusConfig) { cfg.BlacklistedIPs = []string{"203.0.113.0/24", "203.0.113.999"} },
+		"trusted_proxies":   func(cfg *config.JanusConfig) { cfg.TrustedProxies = []string{"proxy.internal"} },
+		"debug.trusted_ips": func(cfg *config.JanusConfig) { cfg.Debug.TrustedIPs = []string{"10.0.0.0/8", "localhost"} },
+		"admin.trusted_ips": func(cfg *config.JanusConfig) { cfg.Admin.TrustedIPs = []string{"10.0.0.0/40"} },
+	} {
+		cfg := config.DefaultConfig()
+		cfg.StoreBackend = "memory"
+		cfg.TLSFingerprintDB = ""
+		edit(cfg)
+		j, err := New(Options{Config: cfg, GeoIPPath: "testdata/missing.mmdb", SigningKey: []byte(strings.Repeat("k", 32)), Logger: log.New(io.Discard, "", 0)})
+		if err == nil {
+			j.Close()
+			t.Errorf("New accepted a bad %s entry", name)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package janus
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"janus/internal/clientip"
+	"janus/internal/store"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Handoff claims: typ is "handoff", jti the single-use nonce, aud the
+// target host, sub the session (janus_token jti) being handed over and
+// texp when that token expires. vid, auth_time, the act and dif claims of
+// the solved challenge and the binding claims are copied from the token.
+const handoffType = "handoff"
+
+// handoffClaims are carried over from the janus_token.
+var handoffClaims = []string{"vid", "auth_time", "act", "dif", "ip", "net", "asn", "fp"}
+
+// handleExchange carries a verified visitor to another host without a
+// second challenge. GET /janus/exchange?to=<url> on the host the visitor is
+// verified on signs a short-lived handoff for the target host and
+// redirects to the target's /janus/exchange?handoff=...; there the handoff
+// is consumed once and turned into a janus_token for that host before the
+// visitor continues to the target URL. A visitor without a valid token, or
+// whose handoff fails, continues anyway and is challenged as usual.
+func (j *Janus) handleExchange(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("handoff") {
+		j.acceptHandoff(w, r)
+		return
+	}
+	j.startHandoff(w, r)
+}
+
+func (j *Janus) startHandoff(w http.ResponseWriter, r *http.Request) {
+	clientIP := clientip.FromRequest(r)
+	target, err := url.Parse(r.URL.Query().Get("to"))
+	if err != nil || (target.Scheme != "https" && target.Scheme != "http") || !j.exchangeHost(target.Hostname()) {
+		j.logger.Printf("startHandoff: Invalid exchange target %q from IP %s", r.URL.Query().Get("to"), clientIP)
+		http.Error(w, "Invalid exchange target", http.StatusBadRequest)
+		return
+	}
+	tok, ok := j.verifyToken(r)
+	if !ok {
+		j.logger.Printf("startHandoff: No valid token for IP %s, sending to %s unverified", clientIP, target.Host)
+		http.Redirect(w, r, target.String(), http.StatusFound)
+		return
+	}
+
+	nonce, err := j.store.CreateNonce(r.Context(), j.cfg.Exchange.TTL)
+	if err != nil {
+		j.logger.Printf("startHandoff: Failed to create handoff nonce for IP %s: %v", clientIP, err)
+		http.Redirect(w, r, target.String(), http.StatusFound)
+		return
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"typ":  handoffType,
+		"jti":  nonce,
+		"aud":  target.Hostname(),
+		"sub":  tok.id,
+		"iat":  now.Unix(),
+		"exp":  now.Add(j.cfg.Exchange.TTL).Unix(),
+		"texp": tok.expires.Unix(),
+	}
+	for _, k := range handoffClaims {
+		if v, ok := tok.claims[k]; ok {
+			claims[k] = v
+		}
+	}
+	handoff, err := j.keys.Sign(claims)
+	if err != nil {
+		j.logger.Printf("startHandoff: Failed to sign handoff for IP %s: %v", clientIP, err)
+		http.Redirect(w, r, target.String(), http.StatusFound)
+		return
+	}
+
+	dest := url.URL{
+		Scheme:   target.Scheme,
+		Host:     target.Host,
+		Path:     "/janus/exchange",
+		RawQuery: url.Values{"handoff": {handoff}, "next": {target.RequestURI()}}.Encode(),
+	}
+	j.logger.Printf("startHandoff: Handing token %s for IP %s to %s", tok.id, clientIP, target.Host)
+	http.Redirect(w, r, dest.String(), http.StatusFound)
+}
+
+func (j *Janus) acceptHandoff(w http.ResponseWriter, r *http.Request) {
+	clientIP := clientip.FromRequest(r)
+	q := r.URL.Query()
+	next := q.Get("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		next = "/"
+	}
+	fail := func(format string, args ...interface{}) {
+		j.metrics.Inc("token_exchange_failures")
+		j.logger.Printf("acceptHandoff: Rejected handoff for IP %s: "+format, append([]interface{}{clientIP}, args...)...)
+		http.Redirect(w, r, next, http.StatusFound)
+	}
+
+	host := requestHost(r)
+	token, err := j.parseToken(q.Get("handoff"), jwt.WithAudience(host))
+	if err != nil {
+		fail("%v", err)
+		return
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		fail("invalid claims format")
+		return
+	}
+	if typ, _ := claims["typ"].(string); typ != handoffType {
+		fail("not a handoff")
+		return
+	}
+	nonce, _ := claims["jti"].(string)
+	if valid, err := j.store.ValidateNonce(r.Context(), nonce); err != nil {
+		fail("nonce store error: %v", err)
+		return
+	} else if !valid {
+		fail("unknown or reused handoff %s", nonce)
+		return
+	}
+	id, _ := claims["sub"].(string)
+	session, err := j.store.GetSession(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && session.Revoked) {
+		fail("session %s ended or revoked", id)
+		return
+	} else if err != nil {
+		fail("failed to load session %s: %v", id, err)
+		return
+	}
+	cur := j.bindingFor(r, session.Device)
+	if network, device, reason := j.checkBinding(claims, cur); !network || !device {
+		fail("binding mismatch: %s", reason)
+		return
+	}
+	exp := claimTime(claims, "texp")
+	if !exp.After(time.Now()) {
+		fail("token %s expired", id)
+		return
+	}
+
+	now := time.Now()
+	tokenClaims := jwt.MapClaims{"jti": id, "iat": now.Unix()}
+	for _, k := range []string{"vid", "auth_time", "act", "dif"} {
+		if v, ok := claims[k]; ok {
+			tokenClaims[k] = v
+		}
+	}
+	cur.setClaims(tokenClaims, j.bindModes)
+	if err := j.issueToken(w, tokenClaims, exp); err != nil {
+		fail("failed to issue token %s: %v", id, err)
+		return
+	}
+	j.metrics.Inc("token_exchanges")
+	j.logger.Printf("acceptHandoff: Issued token %s for IP %s on %s", id, clientIP, host)
+	http.Redirect(w, r, next, http.StatusFound)
+}
+
+// exchangeHost reports whether host may receive handoffs. exchange.hosts
+// entries are exact host names or "*.example.com" for any subdomain.
+func (j *Janus) exchangeHost(host string) bool {
+	for _, h := range j.cfg.Exchange.Hosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+		if strings.HasPrefix(h, "*.") && len(host) > len(h)-1 && strings.EqualFold(host[len(host)-len(h)+1:], h[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestHost is r's Host without the port.
+func requestHost(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.Host); err == nil {
+		return host
+	}
+	return r.Host
+}
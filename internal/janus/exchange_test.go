@@ -0,0 +1,139 @@
+package janus
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"janus/internal/config"
+)
+
+func exchangeJanus(t *testing.T) *Janus {
+	return invisibleJanus(t, func(cfg *config.JanusConfig) {
+		cfg.Exchange.Hosts = []string{"shop.example", "*.example.net"}
+	})
+}
+
+// startHandoff asks for a handoff to target and returns the redirect.
+func startHandoff(t *testing.T, c *client, target string) *url.URL {
+	t.Helper()
+	w := c.do(http.MethodGet, "/janus/exchange?to="+url.QueryEscape(target), nil)
+	if w.Code != http.StatusFound {
+		t.Fatalf("exchange to %s: %d %s", target, w.Code, w.Body)
+	}
+	loc, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestExchange(t *testing.T) {
+	j := exchangeJanus(t)
+	c := newClient(t, j, "192.0.2.1")
+	c.solve("/")
+
+	loc := startHandoff(t, c, "https://shop.example/cart?item=1")
+	if loc.Host != "shop.example" || loc.Path != "/janus/exchange" || loc.Query().Get("handoff") == "" {
+		t.Fatalf("redirect to %s, want the target's /janus/exchange with a handoff", loc)
+	}
+
+	// The target host has its own cookie jar.
+	shop := newClient(t, j, "192.0.2.1")
+	w := shop.do(http.MethodGet, loc.String(), nil)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/cart?item=1" {
+		t.Fatalf("handoff: %d to %q, want 302 to /cart?item=1", w.Code, w.Header().Get("Location"))
+	}
+	if !shop.served("https://shop.example/cart?item=1") {
+		t.Fatal("visitor not served on the target host after the handoff")
+	}
+	if got, want := tokenClaims(t, j, shop)["jti"], tokenClaims(t, j, c)["jti"]; got != want {
+		t.Errorf("handed-over token %v, want the same session %v", got, want)
+	}
+	if n := j.metrics.Get("token_exchanges"); n != 1 {
+		t.Errorf("token_exchanges = %d, want 1", n)
+	}
+}
+
+func TestExchangeRejectsHandoff(t *testing.T) {
+	cases := []struct {
+		name string
+		// use presents the handoff redirect loc; it returns the client that
+		// should have no token afterwards.
+		use func(t *testing.T, j *Janus, loc *url.URL) *client
+	}{
+		{"replayed", func(t *testing.T, j *Janus, loc *url.URL) *client {
+			newClient(t, j, "192.0.2.1").do(http.MethodGet, loc.String(), nil)
+			thief := newClient(t, j, "192.0.2.1")
+			thief.do(http.MethodGet, loc.String(), nil)
+			return thief
+		}},
+		{"other host", func(t *testing.T, j *Janus, loc *url.URL) *client {
+			loc.Host = "a.example.net"
+			other := newClient(t, j, "192.0.2.1")
+			other.do(http.MethodGet, loc.String(), nil)
+			return other
+		}},
+		{"other ip", func(t *testing.T, j *Janus, loc *url.URL) *client {
+			thief := newClient(t, j, "198.51.100.1")
+			thief.do(http.MethodGet, loc.String(), nil)
+			return thief
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			j := exchangeJanus(t)
+			c := newClient(t, j, "192.0.2.1")
+			c.solve("/")
+			got := tc.use(t, j, startHandoff(t, c, "https://shop.example/"))
+			if _, ok := got.cookies[j.cookieName]; ok {
+				t.Error("handoff accepted")
+			}
+			if n := j.metrics.Get("token_exchange_failures"); n != 1 {
+				t.Errorf("token_exchange_failures = %d, want 1", n)
+			}
+		})
+	}
+}
+
+func TestExchangeTargets(t *testing.T) {
+	j := exchangeJanus(t)
+	c := newClient(t, j, "192.0.2.1")
+	for _, target := range []string{"https://evil.example/", "javascript:alert(1)", "https://example.net/", "//shop.example/"} {
+		if w := c.do(http.MethodGet, "/janus/exchange?to="+url.QueryEscape(target), nil); w.Code != http.StatusBadRequest {
+			t.Errorf("exchange to %q: %d, want 400", target, w.Code)
+		}
+	}
+	// Without a token the visitor goes on unverified.
+	if loc := startHandoff(t, c, "https://a.example.net/x"); loc.String() != "https://a.example.net/x" {
+		t.Errorf("unverified visitor sent to %s, want the target itself", loc)
+	}
+}
+
+func TestExchangeNextStaysOnHost(t *testing.T) {
+	j := exchangeJanus(t)
+	c := newClient(t, j, "192.0.2.1")
+	for _, next := range []string{"//evil.example/", "/\\evil.example/", "https://evil.example/"} {
+		w := c.do(http.MethodGet, "/janus/exchange?handoff=x&next="+url.QueryEscape(next), nil)
+		if loc := w.Header().Get("Location"); w.Code != http.StatusFound || loc != "/" {
+			t.Errorf("next %q: %d to %q, want 302 to /", next, w.Code, loc)
+		}
+	}
+}
+
+func TestExchangeHost(t *testing.T) {
+	j := exchangeJanus(t)
+	for host, want := range map[string]bool{
+		"shop.example":    true,
+		"SHOP.example":    true,
+		"a.example.net":   true,
+		"a.b.example.net": true,
+		"example.net":     false,
+		"badexample.net":  false,
+		"shop.example.io": false,
+	} {
+		if got := j.exchangeHost(host); got != want {
+			t.Errorf("exchangeHost(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package janus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"janus/internal/ratelimit"
+	"janus/internal/routes"
+	"janus/internal/store"
+)
+
+// Redis failure modes.
+const (
+	failOpen   = "open"
+	failClosed = "closed"
+	failLocal  = "local-fallback"
+)
+
+// guardStore validates the failure mode and puts a circuit breaker in
+// front of a Redis store, so an outage costs one fast error per call
+// rather than a timeout. A Redis that does not answer now starts out
+// down. In local-fallback mode the breaker is backed by per-replica
+// memory, which serves every call while Redis is down.
+func (j *Janus) guardStore() error {
+	switch j.cfg.RedisFailureMode {
+	case failOpen, failClosed, failLocal:
+	default:
+		return fmt.Errorf("janus: unknown redis_failure_mode %q (want open, closed or local-fallback)", j.cfg.RedisFailureMode)
+	}
+	if _, ok := j.store.(*store.Redis); !ok {
+		return nil
+	}
+	j.breaker = store.NewBreaker(j.store, store.BreakerOptions{
+		Failures:      j.cfg.RedisBreakerFailures,
+		ProbeInterval: j.cfg.RedisProbeInterval,
+		OnChange:      j.storeHealthChanged,
+	})
+	j.store = j.breaker
+	if j.cfg.RedisFailureMode == failLocal {
+		j.store = &store.Fallback{
+			Store: j.breaker,
+			Local: store.NewMemory(j.cfg.FingerprintMaxEntries),
+			OnFallback: func(error) {
+				j.metrics.Inc("store_local_fallback")
+			},
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := j.breaker.Ping(ctx); err != nil {
+		j.breaker.Trip(err)
+	}
+	return nil
+}
+
+// storeHealthy reports whether the store is answering. Only Redis can be
+// down.
+func (j *Janus) storeHealthy() bool {
+	return j.breaker == nil || j.breaker.Healthy()
+}
+
+// storeHealthChanged logs and counts Redis going down and coming back.
+func (j *Janus) storeHealthChanged(healthy bool, err error) {
+	if healthy {
+		j.metrics.Inc("redis_recoveries")
+		j.logger.Printf("storeHealthChanged: Redis is reachable again")
+		return
+	}
+	j.metrics.Inc("redis_outages")
+	mode := map[string]string{
+		failOpen:   "rate limits and challenges are skipped (fail open)",
+		failClosed: "protected requests and challenges get 503 (fail closed)",
+		failLocal:  "state is kept in per-replica memory (local fallback)",
+	}[j.cfg.RedisFailureMode]
+	j.logger.Printf("storeHealthChanged: Redis unavailable (%v), %s until it answers", err, mode)
+}
+
+// checkRateLimits counts r against its limiters. In local-fallback mode
+// the store itself falls back to memory; otherwise a store failure allows
+// r without limits in open mode and returns the error, refusing r, in
+// closed mode.
+func (j *Janus) checkRateLimits(r *http.Request, route *routes.Route) ([]ratelimit.Status, error) {
+	req := j.rateRequest(r)
+	limits, err := ratelimit.Check(r.Context(), j.store, req, j.limitersFor(route)...)
+	if err == nil {
+		return limits, nil
+	}
+	// An open breaker was logged when it tripped.
+	if !errors.Is(err, store.ErrUnavailable) {
+		j.logger.Printf("checkRateLimits: Rate limit store error for %s: %v", req.IP, err)
+	}
+	if j.cfg.RedisFailureMode == failOpen {
+		j.metrics.Inc("ratelimit_fail_open")
+		return nil, nil
+	}
+	j.metrics.Inc("ratelimit_fail_closed")
+	return nil, err
+}
+
+// skipChallenge reports whether an unverified request that needs a
+// challenge is let through instead, because challenges cannot be stored
+// and the failure mode is open.
+func (j *Janus) skipChallenge() bool {
+	if j.cfg.RedisFailureMode != failOpen || j.storeHealthy() {
+		return false
+	}
+	j.metrics.Inc("challenge_fail_open")
+	return true
+}
+
+// storeFailed answers a request whose store call failed with err: 503
+// while the store is down, 500 for other errors.
+func (j *Janus) storeFailed(w http.ResponseWriter, err error) {
+	if errors.Is(err, store.ErrUnavailable) {
+		j.unavailable(w)
+		return
+	}
+	http.Error(w, "Internal server error", http.StatusInternalServerError)
+}
+
+// unavailable answers 503, asking the client to retry once the store has
+// been probed again.
+func (j *Janus) unavailable(w http.ResponseWriter) {
+	retry := int(math.Ceil(j.cfg.RedisProbeInterval.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(retry, 1)))
+	w.Header().Set("Cache-Control", "no-store")
+	http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+}
@@ -0,0 +1,712 @@
+package janus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"janus/internal/challenge"
+	"janus/internal/clientip"
+	"janus/internal/config"
+	"janus/internal/detect"
+	"janus/internal/handlers"
+	"janus/internal/metrics"
+	"janus/internal/ratelimit"
+	"janus/internal/routes"
+	"janus/internal/signing"
+	"janus/internal/store"
+	"janus/internal/tlsfp"
+	"janus/internal/types"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/oschwald/geoip2-golang/v2"
+)
+
+type contextKey string
+
+const (
+	ja3ContextKey contextKey = "ja3"
+)
+
+// Options configures a Janus instance. Zero values fall back to the
+// defaults used by cmd/janus.
+type Options struct {
+	// Config is used as-is when set; otherwise ConfigPath is loaded.
+	Config *config.JanusConfig
+	// ConfigPath defaults to "config.yaml".
+	ConfigPath string
+	// GeoIPPath defaults to "GeoLite2-City.mmdb". Geo checks are disabled
+	// when the database cannot be opened.
+	GeoIPPath string
+	// ASNPath defaults to "GeoLite2-ASN.mmdb"; it is required for the asn
+	// token binding mode.
+	ASNPath string
+	// SigningKey is an HS256 secret (at least 32 bytes) for janus_token
+	// cookies, used when the config lists no signing keys.
+	SigningKey []byte
+	// Logger defaults to log.Default().
+	Logger *log.Logger
+	// Detectors are registered after the built-in ones and can be ordered,
+	// disabled and re-weighted through the detectors config section.
+	Detectors []detect.Detector
+}
+
+// Janus is a self-contained protection instance with its own config,
+// stores, GeoIP reader and signing key.
+type Janus struct {
+	cfg     *config.JanusConfig
+	logger  *log.Logger
+	metrics *metrics.Counters
+	store   store.Store
+	// breaker guards a Redis store; nil for other backends.
+	breaker *store.Breaker
+	limiter *ratelimit.Limiter
+	routes  *routes.Table
+	// routeLimiters holds the limiters of routes with their own rate
+	// limit, by route index. rateKeys lists the keys any limiter uses.
+	routeLimiters map[int]*ratelimit.Limiter
+	rateKeys      map[string]bool
+	geoDB         *geoip2.Reader
+	tlsDB         *tlsfp.DB
+	detectors     *detect.Engine
+	history       *detect.History
+	actions       []action
+	// namedActions holds a band for every action, by name.
+	namedActions map[string]action
+	debugIPs     []netip.Prefix
+	adminIPs     []netip.Prefix
+	clientIPs    *clientip.Resolver
+	keys         *signing.Keyring
+	bindModes    map[string]bool
+	asnDB        *geoip2.Reader
+	// cookieName includes the __Host- prefix when configured.
+	cookieName string
+	sameSite   http.SameSite
+	router     *chi.Mux
+
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+const (
+	// challengeTTL is how long an issued challenge and its nonce stay valid.
+	challengeTTL = 5 * time.Minute
+)
+
+// New builds a Janus instance from opts and starts its background cleanup.
+// Call Close to release it.
+func New(opts Options) (*Janus, error) {
+	logger := opts.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	cfg := opts.Config
+	if cfg == nil {
+		path := opts.ConfigPath
+		if path == "" {
+			path = "config.yaml"
+		}
+		var err error
+		cfg, err = config.LoadConfig(path)
+		if err != nil {
+			logger.Printf("New: Failed to load config: %v, using default config", err)
+			cfg = config.DefaultConfig()
+		}
+	}
+	if cfg.RedisAddr == "" {
+		cfg.RedisAddr = "localhost:6379"
+	}
+	if cfg.RedisFailureMode == "" {
+		cfg.RedisFailureMode = failLocal
+	}
+	if cfg.RateLimit.RequestsPerMinute == 0 {
+		cfg.RateLimit.RequestsPerMinute = 60
+	}
+	if cfg.ChallengeAttemptWindow <= 0 {
+		cfg.ChallengeAttemptWindow = 10 * time.Minute
+	}
+	if cfg.FingerprintTTL <= 0 {
+		cfg.FingerprintTTL = 30 * time.Minute
+	}
+	if cfg.TokenTTL <= 0 {
+		cfg.TokenTTL = 24 * time.Hour
+	}
+	if cfg.TokenMaxLifetime <= 0 {
+		cfg.TokenMaxLifetime = 7 * 24 * time.Hour
+	}
+	if cfg.TokenBinding.IPv4Prefix == 0 {
+		cfg.TokenBinding.IPv4Prefix = 24
+	}
+	if cfg.TokenBinding.IPv6Prefix == 0 {
+		cfg.TokenBinding.IPv6Prefix = 64
+	}
+	if cfg.Cookie.Name == "" {
+		cfg.Cookie.Name = "janus_token"
+	}
+	if cfg.Cookie.Path == "" {
+		cfg.Cookie.Path = "/"
+	}
+	if cfg.Exchange.TTL <= 0 {
+		cfg.Exchange.TTL = 30 * time.Second
+	}
+
+	geoPath := opts.GeoIPPath
+	if geoPath == "" {
+		geoPath = "GeoLite2-City.mmdb"
+	}
+	geoDB, err := geoip2.Open(geoPath)
+	if err != nil {
+		logger.Printf("New: GeoIP database load error: %v, geo checks disabled", err)
+		geoDB = nil
+	}
+
+	var tlsDB *tlsfp.DB
+	if cfg.TLSFingerprintDB != "" {
+		tlsDB, err = tlsfp.LoadDB(cfg.TLSFingerprintDB)
+		if err != nil {
+			logger.Printf("New: TLS fingerprint database load error: %v, TLS checks disabled", err)
+			tlsDB = nil
+		}
+	}
+
+	keys, err := loadKeys(cfg, opts.SigningKey, logger)
+	if err != nil {
+		if geoDB != nil {
+			geoDB.Close()
+		}
+		return nil, err
+	}
+
+	j := &Janus{
+		cfg:     cfg,
+		logger:  logger,
+		metrics: metrics.New(),
+		history: detect.NewHistory(cfg.Debug.History),
+		geoDB:   geoDB,
+		tlsDB:   tlsDB,
+		keys:    keys,
+		done:    make(chan struct{}),
+	}
+
+	j.store, err = store.Open(store.Options{
+		Backend:         cfg.StoreBackend,
+		RedisAddr:       cfg.RedisAddr,
+		Path:            cfg.StorePath,
+		Timeout:         cfg.StoreTimeout,
+		MaxFingerprints: cfg.FingerprintMaxEntries,
+	})
+	if err != nil {
+		j.closeResources()
+		return nil, err
+	}
+	if err := j.guardStore(); err != nil {
+		j.closeResources()
+		return nil, err
+	}
+	if err := j.compileRoutes(cfg.RateLimit, cfg.Routes); err != nil {
+		j.closeResources()
+		return nil, err
+	}
+	if err := challenge.ValidatePuzzles(cfg.Puzzles); err != nil {
+		j.closeResources()
+		return nil, err
+	}
+
+	j.clientIPs, err = clientip.New(cfg.TrustedProxies, cfg.ClientIPHeaders)
+	if err != nil {
+		j.closeResources()
+		return nil, err
+	}
+
+	if j.debugIPs, err = parsePrefixes("debug.trusted_ips", cfg.Debug.TrustedIPs); err != nil {
+		j.closeResources()
+		return nil, err
+	}
+	if j.adminIPs, err = parsePrefixes("admin.trusted_ips", cfg.Admin.TrustedIPs); err != nil {
+		j.closeResources()
+		return nil, err
+	}
+	blacklist, err := detect.NewIPBlacklist(cfg.BlacklistedIPs)
+	if err != nil {
+		j.closeResources()
+		return nil, err
+	}
+
+	reg := detect.NewRegistry()
+	builtins := []detect.Detector{
+		detect.Whitelist{UAs: cfg.WhitelistUA, IPs: cfg.WhitelistIPs},
+		blacklist,
+		&detect.Geo{DB: geoDB, Banned: cfg.BannedGeoLocations},
+		&detect.TLS{DB: tlsDB},
+		detect.HTTP2{},
+		detect.UserAgent{},
+		detect.Headers{},
+		detect.BrowserFingerprint{},
+		&detect.Behavior{HumanScore: cfg.Behavior.HumanScore, RoboticScore: cfg.Behavior.RoboticScore},
+	}
+	for _, d := range append(builtins, opts.Detectors...) {
+		if err := reg.Register(d); err != nil {
+			j.closeResources()
+			return nil, err
+		}
+	}
+	j.detectors, err = detect.NewEngine(reg, cfg.Detectors, cfg.SuspicionWeights)
+	if err != nil {
+		j.closeResources()
+		return nil, err
+	}
+
+	switch cfg.Monitoring.Action {
+	case "", "rechallenge", "revoke":
+	default:
+		j.closeResources()
+		return nil, fmt.Errorf("janus: unknown monitoring action %q", cfg.Monitoring.Action)
+	}
+
+	j.bindModes, err = bindingModes(cfg.TokenBinding)
+	if err != nil {
+		j.closeResources()
+		return nil, err
+	}
+	if j.bindModes[bindASN] || j.rateKeys[ratelimit.KeyASN] {
+		asnPath := opts.ASNPath
+		if asnPath == "" {
+			asnPath = "GeoLite2-ASN.mmdb"
+		}
+		if j.asnDB, err = geoip2.Open(asnPath); err != nil {
+			j.closeResources()
+			return nil, fmt.Errorf("janus: asn token binding and rate limits need an ASN database: %w", err)
+		}
+	}
+
+	j.cookieName, j.sameSite, err = tokenCookie(cfg.Cookie)
+	if err != nil {
+		j.closeResources()
+		return nil, err
+	}
+
+	j.actions, err = j.compileActions(cfg.Actions)
+	if err != nil {
+		j.closeResources()
+		return nil, err
+	}
+	j.namedActions, err = j.compileNamedActions()
+	if err != nil {
+		j.closeResources()
+		return nil, err
+	}
+
+	j.router = chi.NewRouter()
+	j.router.Post("/janus/fingerprint", handlers.HandleFingerprint(j.store, cfg.FingerprintTTL, j.ensureVisitorID, j.storeFailed, j.logger))
+	j.router.Get("/janus/challenge", j.handleChallenge)
+	j.router.Post("/janus/verify", j.handleVerify)
+	j.router.Post("/janus/telemetry", j.handleTelemetry)
+	j.router.Get("/janus/telemetry.js", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "assets/telemetry.js")
+	})
+	j.router.Get("/janus/debug/explain", j.handleExplain)
+	j.router.Get("/janus/debug/vars", j.handleVars)
+	j.router.Post("/janus/admin/revoke", j.handleRevoke)
+	j.router.Get("/janus/.well-known/jwks.json", j.handleJWKS)
+	j.router.Get("/janus/exchange", j.handleExchange)
+
+	j.wg.Add(1)
+	go j.cleanupLoop()
+
+	if tlsDB != nil && cfg.TLSFingerprintReload > 0 {
+		j.wg.Add(1)
+		go func() {
+			defer j.wg.Done()
+			tlsDB.Watch(j.done, cfg.TLSFingerprintReload, logger)
+		}()
+	}
+
+	return j, nil
+}
+
+// Handler returns the handler for the /janus/* API routes.
+func (j *Janus) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		j.router.ServeHTTP(w, j.resolveClientIP(r))
+	})
+}
+
+// resolveClientIP attaches the resolved client address to r unless an
+// earlier pass already did.
+func (j *Janus) resolveClientIP(r *http.Request) *http.Request {
+	if _, ok := clientip.FromContext(r.Context()); ok {
+		return r
+	}
+	info := j.clientIPs.Resolve(r)
+	if info.TrustedPeer() {
+		j.logger.Printf("resolveClientIP: %s via %s, proxies %v", info.IP, info.Source, info.Chain)
+	}
+	return r.WithContext(clientip.WithInfo(r.Context(), info))
+}
+
+// Close stops background work and releases the GeoIP reader and Redis client.
+func (j *Janus) Close() error {
+	var err error
+	j.closeOnce.Do(func() {
+		close(j.done)
+		j.wg.Wait()
+		err = j.closeResources()
+	})
+	return err
+}
+
+func (j *Janus) closeResources() error {
+	var err error
+	if j.geoDB != nil {
+		err = j.geoDB.Close()
+	}
+	if j.asnDB != nil {
+		if cerr := j.asnDB.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	if j.store != nil {
+		if cerr := j.store.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (j *Janus) cleanupLoop() {
+	defer j.wg.Done()
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.done:
+			return
+		case <-ticker.C:
+			if sw, ok := j.store.(store.Sweeper); ok {
+				if err := sw.Sweep(context.Background()); err != nil {
+					j.logger.Printf("cleanupLoop: Store sweep failed: %v", err)
+				}
+			}
+		}
+	}
+}
+
+// Middleware protects next, serving the Janus API and sensor itself.
+func (j *Janus) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = j.resolveClientIP(r)
+		clientIP := clientip.FromRequest(r)
+		r = r.WithContext(context.WithValue(r.Context(), ja3ContextKey, j.ja3Fingerprint(r)))
+		j.logger.Printf("Request: %s, Method: %s, IP: %s, UA: %s", r.URL.Path, r.Method, clientIP, r.Header.Get("User-Agent"))
+
+		if strings.HasPrefix(r.URL.Path, "/janus/") {
+			j.logger.Printf("Serving Janus API endpoint: %s", r.URL.Path)
+			j.router.ServeHTTP(w, r)
+			return
+		}
+		if r.URL.Path == "/sensor.js" {
+			j.logger.Printf("Serving sensor.js asset")
+			http.ServeFile(w, r, "assets/sensor.js")
+			return
+		}
+
+		route := j.matchRoute(r)
+		if route != nil && route.Bypass {
+			j.logger.Printf("Bypassing protection for %s on route %s", clientIP, route.Name)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limits, err := j.checkRateLimits(r, route)
+		if err != nil {
+			j.unavailable(w)
+			return
+		}
+		ratelimit.SetHeaders(w.Header(), limits)
+		if limit := ratelimit.Tightest(limits); !limit.Allowed {
+			j.logger.Printf("Rate limit %s exceeded for %s, retry after %s", limit.Name, clientIP, limit.RetryAfter)
+			ratelimit.Reject(w, r, limit)
+			return
+		}
+
+		tok, ok := j.verifyToken(r)
+		if !ok && tok != nil && tok.storeErr != nil {
+			j.storeFailed(w, tok.storeErr)
+			return
+		}
+		if !ok && tok != nil && tok.drifted && j.cfg.TokenBinding.Grace {
+			ok = j.rebindToken(w, r, tok, route)
+		}
+		if ok {
+			if covered, reason := tokenCovers(tok, route); !covered {
+				j.logger.Printf("Token %s for %s does not cover route %s: %s", tok.id, clientIP, route.Name, reason)
+				ok = false
+			}
+		}
+		if ok {
+			if !j.monitorSession(w, r, tok) {
+				return
+			}
+			j.refreshToken(w, r, tok)
+			j.logger.Printf("Serving content for verified user %s", clientIP)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		d := j.decide(r)
+		a := j.actionForRoute(d, route)
+		if route != nil {
+			d.Route = route.Name
+		}
+		d.Action = a.Action
+		j.recordDecision(w, r, d)
+		r = r.WithContext(detect.WithDecision(r.Context(), d))
+		j.logger.Printf("Unverified user %s. Suspicious: %v, Score: %d, Action: %s", clientIP, d.Suspicious(), d.Score, a.Action)
+		if a.Action == detect.ActionAllow {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if (a.Action == detect.ActionInvisible || a.Action == detect.ActionInteractive) && j.skipChallenge() {
+			j.logger.Printf("Middleware: Store down, serving %s without the %s challenge (fail open)", clientIP, a.Action)
+			next.ServeHTTP(w, r)
+			return
+		}
+		j.respond(w, r, a, d)
+	})
+}
+
+// ja3Fingerprint returns the JA3 hash of r's ClientHello, or a placeholder
+// saying why there is none.
+func (j *Janus) ja3Fingerprint(r *http.Request) string {
+	if ja3, ok := r.Context().Value(ja3ContextKey).(string); ok {
+		return ja3
+	}
+	if r.TLS == nil {
+		j.logger.Printf("ja3Fingerprint: No TLS data for %s", r.RemoteAddr)
+		return "no-tls"
+	}
+	fp, ok := tlsfp.FromContext(r.Context())
+	if !ok {
+		j.logger.Printf("ja3Fingerprint: No ClientHello captured for %s", r.RemoteAddr)
+		return "unknown-ja3"
+	}
+	j.logger.Printf("ja3Fingerprint: JA3 %s (%s), JA4 %s for %s", fp.JA3Hash, fp.JA3, fp.JA4, r.RemoteAddr)
+	return fp.JA3Hash
+}
+
+// lookupFingerprint returns the fingerprint posted in challenge session sid.
+func (j *Janus) lookupFingerprint(ctx context.Context, sid string) (*types.Fingerprint, error) {
+	if sid == "" {
+		return nil, store.ErrNotFound
+	}
+	return j.store.GetFingerprint(ctx, sid)
+}
+
+func (j *Janus) handleChallenge(w http.ResponseWriter, r *http.Request) {
+	clientIP := clientip.FromRequest(r)
+	sid := j.visitorID(r)
+	fp, err := j.lookupFingerprint(r.Context(), sid)
+	if err != nil {
+		j.logger.Printf("handleChallenge: No fingerprint for IP %s, session %q: %v", clientIP, sid, err)
+		if errors.Is(err, store.ErrUnavailable) {
+			j.storeFailed(w, err)
+			return
+		}
+		http.Error(w, "No fingerprint", http.StatusBadRequest)
+		return
+	}
+
+	// The challenge page says which path it was served on, so the route's
+	// min_action and difficulty apply. The client could name another path,
+	// so the token records what was actually solved and the middleware
+	// checks it against each route's policy.
+	var route *routes.Route
+	if path := r.URL.Query().Get("path"); path != "" {
+		route = j.routes.Match("", r.Host, path)
+	}
+
+	userHistory := 0
+	d := j.decide(r)
+	a := j.actionForRoute(d, route)
+	if route != nil {
+		d.Route = route.Name
+	}
+	d.Action = a.Action
+	j.recordDecision(w, r, d)
+	riskScore := d.Score
+	if d.Suspicious() {
+		j.logger.Printf("handleChallenge: User %s is suspicious, risk score %d", clientIP, riskScore)
+	}
+	if a.Action == detect.ActionBlock || a.Action == detect.ActionTarpit {
+		j.logger.Printf("handleChallenge: Refusing challenge for IP %s, action %s", clientIP, a.Action)
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	nonce, err := j.store.CreateNonce(r.Context(), challengeTTL)
+	if err != nil {
+		j.logger.Printf("handleChallenge: Failed to register nonce for IP %s: %v", clientIP, err)
+		j.storeFailed(w, err)
+		return
+	}
+	human := d.HasSignal("behavior_human")
+	minDifficulty := 0
+	if route != nil {
+		minDifficulty = route.Difficulty
+	}
+	chal, err := challenge.GenerateChallenge(j.cfg, nonce, fp.IsMobile, riskScore, userHistory, human, a.Action == detect.ActionInteractive, minDifficulty)
+	if err != nil {
+		j.logger.Printf("handleChallenge: Failed to generate challenge for IP %s: %v", clientIP, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	chal.Action = a.Action
+	if route != nil {
+		chal.Route = route.Name
+	}
+
+	if err := j.store.PutChallenge(r.Context(), sid+chal.Nonce, chal, challengeTTL); err != nil {
+		j.logger.Printf("handleChallenge: Failed to store challenge for IP %s: %v", clientIP, err)
+		j.storeFailed(w, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"nonce":      chal.Nonce,
+		"iterations": chal.Iterations,
+		"seed":       chal.Seed,
+		"clientIP":   clientIP,
+		"type":       chal.Type,
+		"difficulty": chal.Difficulty,
+	}
+	if chal.Prompt != "" {
+		response["prompt"] = chal.Prompt
+		response["image"] = chal.Image
+	}
+	j.logger.Printf("handleChallenge: Issued challenge for IP %s, nonce %s, type %s, difficulty %d, human behaviour %v", clientIP, chal.Nonce, chal.Type, chal.Difficulty, human)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		j.logger.Printf("handleChallenge: Failed to encode response for IP %s: %v", clientIP, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (j *Janus) handleVerify(w http.ResponseWriter, r *http.Request) {
+	clientIP := clientip.FromRequest(r)
+	var req struct {
+		Nonce  string `json:"nonce"`
+		Proof  string `json:"proof"`
+		Answer string `json:"answer"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		j.logger.Printf("handleVerify: Invalid request body for IP %s: %v", clientIP, err)
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	sid := j.visitorID(r)
+	fp, err := j.lookupFingerprint(r.Context(), sid)
+	if err != nil {
+		j.logger.Printf("handleVerify: No fingerprint for IP %s, session %q: %v", clientIP, sid, err)
+		if errors.Is(err, store.ErrUnavailable) {
+			j.storeFailed(w, err)
+			return
+		}
+		http.Error(w, "No fingerprint", http.StatusBadRequest)
+		return
+	}
+
+	if j.cfg.ChallengeMaxAttempts > 0 {
+		attempts, err := j.store.Incr(r.Context(), "verify:"+sid, j.cfg.ChallengeAttemptWindow)
+		if err != nil {
+			j.logger.Printf("handleVerify: Failed to count attempts for IP %s: %v", clientIP, err)
+		} else if attempts > int64(j.cfg.ChallengeMaxAttempts) {
+			j.metrics.Inc("challenge_attempts_exceeded")
+			j.logger.Printf("handleVerify: Too many attempts for IP %s, session %s: %d", clientIP, sid, attempts)
+			http.Error(w, "Too many attempts", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	// The nonce and challenge are consumed by the first attempt, whatever
+	// its outcome: a failed proof needs a new challenge, and a captured
+	// proof cannot be replayed.
+	valid, err := j.store.ValidateNonce(r.Context(), req.Nonce)
+	if err != nil {
+		j.logger.Printf("handleVerify: Nonce store error for IP %s: %v", clientIP, err)
+		j.storeFailed(w, err)
+		return
+	}
+	stored, err := j.store.TakeChallenge(r.Context(), sid+req.Nonce)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		j.logger.Printf("handleVerify: Challenge store error for IP %s: %v", clientIP, err)
+		j.storeFailed(w, err)
+		return
+	}
+	if !valid || err != nil {
+		j.metrics.Inc("challenge_replays")
+		j.logger.Printf("handleVerify: Unknown or reused nonce for IP %s, nonce %s", clientIP, req.Nonce)
+		http.Error(w, "No valid challenge", http.StatusBadRequest)
+		return
+	}
+
+	if err := challenge.VerifyChallenge(req.Proof, stored, clientIP, fp.IsMobile, fp.CanvasHash); err != nil {
+		j.metrics.Inc("challenge_failures")
+		j.logger.Printf("handleVerify: Proof verification failed for IP %s, nonce %s, proof %s: %v", clientIP, req.Nonce, req.Proof, err)
+		http.Error(w, "Verification failed", http.StatusUnauthorized)
+		return
+	}
+	if !challenge.CheckAnswer(stored, req.Answer) {
+		j.metrics.Inc("challenge_failures")
+		j.logger.Printf("handleVerify: Wrong %s puzzle answer for IP %s, nonce %s", stored.Type, clientIP, req.Nonce)
+		http.Error(w, "Verification failed", http.StatusUnauthorized)
+		return
+	}
+
+	j.metrics.Inc("challenge_successes")
+	j.logger.Printf("handleVerify: Proof verified for IP %s, nonce %s", clientIP, req.Nonce)
+
+	// The session is the token's server-side half: it lives as long as the
+	// token, is updated by /janus/telemetry and page views, and deleting it
+	// revokes the token.
+	sessionID := uuid.NewString()
+	now := time.Now()
+	session := &store.Session{VerifiedAt: now, LastSeen: now, Device: deviceHash(fp)}
+	if err := j.store.SetSession(r.Context(), sessionID, session, j.cfg.TokenTTL); err != nil {
+		j.logger.Printf("handleVerify: Failed to create session for IP %s: %v", clientIP, err)
+		j.storeFailed(w, err)
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"jti":       sessionID,
+		"vid":       sid,
+		"auth_time": now.Unix(),
+		"iat":       now.Unix(),
+		"act":       stored.Action,
+		"dif":       stored.Difficulty,
+	}
+	j.bindingFor(r, session.Device).setClaims(claims, j.bindModes)
+	if err := j.issueToken(w, claims, now.Add(j.cfg.TokenTTL)); err != nil {
+		j.logger.Printf("handleVerify: Failed to generate token for IP %s: %v", clientIP, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	j.logger.Printf("handleVerify: Issued token %s for IP %s", sessionID, clientIP)
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "success"}); err != nil {
+		j.logger.Printf("handleVerify: Failed to encode response for IP %s: %v", clientIP, err)
+	}
+}
@@ -0,0 +1,740 @@
+package janus
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"janus/internal/config"
+	"janus/internal/detect"
+)
+
+// testJanus builds an instance on the memory store that allows every
+// visitor, so tests opt into challenges through routes. edit adjusts the
+// config first.
+func testJanus(t *testing.T, edit func(*config.JanusConfig)) *Janus {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.StoreBackend = "memory"
+	cfg.TLSFingerprintDB = ""
+	cfg.Actions = []config.ActionBand{{Action: detect.ActionAllow}}
+	if edit != nil {
+		edit(cfg)
+	}
+	j, err := New(Options{
+		Config:     cfg,
+		GeoIPPath:  "testdata/missing.mmdb",
+		SigningKey: []byte(strings.Repeat("k", 32)),
+		Logger:     log.New(io.Discard, "", 0),
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { j.Close() })
+	return j
+}
+
+// client is a browser on one IP that keeps its cookies.
+type client struct {
+	t       *testing.T
+	j       *Janus
+	h       http.Handler
+	ip      string
+	cookies map[string]*http.Cookie
+}
+
+func newClient(t *testing.T, j *Janus, ip string) *client {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	return &client{t: t, j: j, h: j.Middleware(next), ip: ip, cookies: map[string]*http.Cookie{}}
+}
+
+// request builds a request from the client, JSON-encoding body if it is
+// not nil.
+func (c *client) request(method, target string, body interface{}) *http.Request {
+	c.t.Helper()
+	var rd io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		rd = bytes.NewReader(data)
+	}
+	r := httptest.NewRequest(method, target, rd)
+	r.RemoteAddr = net.JoinHostPort(c.ip, "40000")
+	r.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36")
+	r.Header.Set("Accept", "text/html,application/xhtml+xml")
+	r.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	r.Header.Set("Accept-Encoding", "gzip, deflate, br")
+	for _, ck := range c.cookies {
+		r.AddCookie(ck)
+	}
+	return r
+}
+
+// do sends a request and keeps the cookies it sets.
+func (c *client) do(method, target string, body interface{}) *httptest.ResponseRecorder {
+	c.t.Helper()
+	w := httptest.NewRecorder()
+	c.h.ServeHTTP(w, c.request(method, target, body))
+	for _, ck := range w.Result().Cookies() {
+		if ck.MaxAge < 0 {
+			delete(c.cookies, ck.Name)
+		} else {
+			c.cookies[ck.Name] = ck
+		}
+	}
+	return w
+}
+
+// served reports whether a page request reached the protected handler.
+func (c *client) served(path string) bool {
+	c.t.Helper()
+	w := c.do(http.MethodGet, path, nil)
+	return w.Code == http.StatusOK && w.Body.String() == "ok"
+}
+
+// challengeResponse is the body of GET /janus/challenge.
+type challengeResponse struct {
+	Nonce      string `json:"nonce"`
+	Iterations int    `json:"iterations"`
+	Seed       string `json:"seed"`
+	ClientIP   string `json:"clientIP"`
+	Type       string `json:"type"`
+	Difficulty int    `json:"difficulty"`
+	Prompt     string `json:"prompt"`
+	Image      string `json:"image"`
+}
+
+// challenge posts a desktop fingerprint and fetches a challenge for path.
+func (c *client) challenge(path string) challengeResponse {
+	c.t.Helper()
+	fp := map[string]interface{}{"canvas_hash": "canvas", "webgl_renderer": "test", "jsEnabled": true}
+	if w := c.do(http.MethodPost, "/janus/fingerprint", fp); w.Code != http.StatusOK {
+		c.t.Fatalf("fingerprint: %d %s", w.Code, w.Body)
+	}
+	w := c.do(http.MethodGet, "/janus/challenge?path="+path, nil)
+	if w.Code != http.StatusOK {
+		c.t.Fatalf("challenge: %d %s", w.Code, w.Body)
+	}
+	var chal challengeResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &chal); err != nil {
+		c.t.Fatal(err)
+	}
+	return chal
+}
+
+// prove computes a proof for chal as sensor.js does.
+func (c *client) prove(chal challengeResponse) string {
+	c.t.Helper()
+	ts := time.Now().UTC().Format(time.RFC3339)
+	for i := 0; i < chal.Iterations; i++ {
+		p := strings.Join([]string{chal.Nonce, strconv.Itoa(i), ts, chal.ClientIP, chal.Seed, "canvas"}, "|")
+		sum := sha256.Sum256([]byte(p))
+		if leadingZeroBits(sum[:]) >= chal.Difficulty {
+			return p
+		}
+	}
+	c.t.Fatalf("no proof within %d iterations", chal.Iterations)
+	return ""
+}
+
+func leadingZeroBits(b []byte) int {
+	n := 0
+	for _, v := range b {
+		if v != 0 {
+			for v&0x80 == 0 {
+				n++
+				v <<= 1
+			}
+			return n
+		}
+		n += 8
+	}
+	return n
+}
+
+// answer solves chal's puzzle, if it has one. The answer is only in the
+// picture, so it is read from the store the way a person reads the picture.
+func (c *client) answer(chal challengeResponse) string {
+	c.t.Helper()
+	if chal.Prompt == "" {
+		return ""
+	}
+	ctx := context.Background()
+	key := c.cookies[c.j.visitorCookieName()].Value + chal.Nonce
+	stored, err := c.j.store.TakeChallenge(ctx, key)
+	if err != nil {
+		c.t.Fatalf("stored challenge: %v", err)
+	}
+	if err := c.j.store.PutChallenge(ctx, key, stored, challengeTTL); err != nil {
+		c.t.Fatal(err)
+	}
+	return stored.Answer
+}
+
+// verify posts body to /janus/verify.
+func (c *client) verify(body map[string]string) *httptest.ResponseRecorder {
+	c.t.Helper()
+	return c.do(http.MethodPost, "/janus/verify", body)
+}
+
+// solve passes a challenge started on path and fails the test otherwise.
+func (c *client) solve(path string) {
+	c.t.Helper()
+	chal := c.challenge(path)
+	if w := c.verify(map[string]string{"nonce": chal.Nonce, "proof": c.prove(chal), "answer": c.answer(chal)}); w.Code != http.StatusOK {
+		c.t.Fatalf("verify: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestVerifiedVisitorIsServed(t *testing.T) {
+	j := testJanus(t, func(cfg *config.JanusConfig) {
+		cfg.Actions = []config.ActionBand{{Action: detect.ActionInvisible}}
+	})
+	c := newClient(t, j, "192.0.2.1")
+	if c.served("/") {
+		t.Fatal("unverified visitor served")
+	}
+	c.solve("/")
+	if !c.served("/") {
+		t.Fatal("verified visitor not served")
+	}
+}
+
+func TestTokenCoversRoute(t *testing.T) {
+	j := testJanus(t, func(cfg *config.JanusConfig) {
+		cfg.Routes = []config.RouteConfig{{Name: "login", Path: "/login", MinAction: detect.ActionInteractive, Difficulty: 9}}
+	})
+	c := newClient(t, j, "192.0.2.1")
+	// A challenge asked for under another path does not satisfy /login.
+	c.solve("/")
+	if !c.served("/") {
+		t.Fatal("verified visitor not served on /")
+	}
+	if c.served("/login") {
+		t.Fatal("token from an easier challenge accepted on /login")
+	}
+
+	chal := c.challenge("/login")
+	if chal.Difficulty < 9 {
+		t.Fatalf("difficulty %d on /login, want at least 9", chal.Difficulty)
+	}
+	if w := c.verify(map[string]string{"nonce": chal.Nonce, "proof": c.prove(chal), "answer": c.answer(chal)}); w.Code != http.StatusOK {
+		t.Fatalf("verify: %d %s", w.Code, w.Body)
+	}
+	if !c.served("/login") {
+		t.Fatal("token from the /login challenge rejected on /login")
+	}
+}
+
+func TestPuzzleAnswerChecked(t *testing.T) {
+	j := testJanus(t, func(cfg *config.JanusConfig) {
+		cfg.Actions = []config.ActionBand{{Action: detect.ActionInteractive}}
+	})
+	c := newClient(t, j, "192.0.2.1")
+	chal := c.challenge("/")
+	if chal.Prompt == "" || !strings.HasPrefix(chal.Image, "data:image/png;base64,") {
+		t.Fatalf("interactive challenge without a puzzle picture: %+v", chal)
+	}
+	if w := c.verify(map[string]string{"nonce": chal.Nonce, "proof": c.prove(chal), "answer": "wrong"}); w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong answer: %d, want 401", w.Code)
+	}
+	if c.served("/") {
+		t.Fatal("visitor with a wrong answer served")
+	}
+	c.solve("/")
+	if !c.served("/") {
+		t.Fatal("visitor with the right answer not served")
+	}
+}
+
+func TestInstancesAreIndependent(t *testing.T) {
+	blocking := testJanus(t, func(cfg *config.JanusConfig) {
+		cfg.Actions = []config.ActionBand{{Action: detect.ActionBlock}}
+	})
+	open := testJanus(t, nil)
+	if newClient(t, blocking, "192.0.2.1").served("/") {
+		t.Error("blocking instance served the visitor")
+	}
+	if !newClient(t, open, "192.0.2.1").served("/") {
+		t.Error("second instance took the first one's config")
+	}
+	if err := blocking.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := blocking.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+	if !newClient(t, open, "192.0.2.2").served("/") {
+		t.Error("closing one instance broke the other")
+	}
+}
+
+func TestNewLoadsConfigPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "janus.yaml")
+	yaml := "store_backend: memory\ntls_fingerprint_db: \"\"\nactions:\n  - action: block\n"
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	j, err := New(Options{
+		ConfigPath: path,
+		GeoIPPath:  "testdata/missing.mmdb",
+		SigningKey: []byte(strings.Repeat("k", 32)),
+		Logger:     log.New(io.Discard, "", 0),
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer j.Close()
+	if newClient(t, j, "192.0.2.1").served("/") {
+		t.Error("config at ConfigPath not applied")
+	}
+}
+
+func TestInstanceLogger(t *testing.T) {
+	var global bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&global)
+
+	var own bytes.Buffer
+	cfg := config.DefaultConfig()
+	cfg.StoreBackend = "memory"
+	cfg.TLSFingerprintDB = ""
+	cfg.Actions = []config.ActionBand{{Action: detect.ActionInteractive}}
+	j, err := New(Options{
+		Config:     cfg,
+		GeoIPPath:  "testdata/missing.mmdb",
+		SigningKey: []byte(strings.Repeat("k", 32)),
+		Logger:     log.New(&own, "", 0),
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer j.Close()
+	c := newClient(t, j, "192.0.2.1")
+	chal := c.challenge("/")
+	c.verify(map[string]string{"nonce": chal.Nonce, "proof": "bad"})
+
+	for _, want := range []string{"HandleFingerprint: Stored", "ja3Fingerprint: No TLS data", "handleVerify: Proof verification failed"} {
+		if !strings.Contains(own.String(), want) {
+			t.Errorf("instance log lacks %q", want)
+		}
+	}
+	if global.Len() != 0 {
+		t.Errorf("logged through the standard logger: %s", global.String())
+	}
+}
+
+func TestMetricsPerInstance(t *testing.T) {
+	cfg := func(cfg *config.JanusConfig) {
+		cfg.Actions = []config.ActionBand{{Action: detect.ActionInvisible}}
+	}
+	a, b := testJanus(t, cfg), testJanus(t, cfg)
+	c := newClient(t, a, "192.0.2.1")
+	chal := c.challenge("/")
+	c.verify(map[string]string{"nonce": chal.Nonce, "proof": "bad"})
+	if n := a.metrics.Get("challenge_failures"); n != 1 {
+		t.Errorf("instance a counted %d failures, want 1", n)
+	}
+	if n := b.metrics.Get("challenge_failures"); n != 0 {
+		t.Errorf("instance b counted %d failures, want 0", n)
+	}
+
+	admin := newClient(t, a, "127.0.0.1")
+	w := admin.do(http.MethodGet, "/janus/debug/vars", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"challenge_failures": 1`) {
+		t.Errorf("debug vars: %d %s", w.Code, w.Body)
+	}
+}
+
+// TestConcurrentSessionUpdates sends page views and a telemetry batch at
+// once; the session must keep every one of them.
+func TestConcurrentSessionUpdates(t *testing.T) {
+	j := testJanus(t, func(cfg *config.JanusConfig) {
+		cfg.Monitoring.Enabled = true
+	})
+	c := newClient(t, j, "192.0.2.1")
+	c.solve("/")
+
+	const pages = 10
+	reqs := []*http.Request{c.request(http.MethodPost, "/janus/telemetry", map[string]interface{}{
+		"path": "/", "events": []map[string]interface{}{{"type": "scroll", "t": 10}},
+	})}
+	for i := 0; i < pages; i++ {
+		reqs = append(reqs, c.request(http.MethodGet, "/", nil))
+	}
+	var wg sync.WaitGroup
+	for _, r := range reqs {
+		wg.Add(1)
+		go func(r *http.Request) {
+			defer wg.Done()
+			j.Middleware(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), r)
+		}(r)
+	}
+	wg.Wait()
+
+	tok, ok := j.verifyToken(c.request(http.MethodGet, "/", nil))
+	if !ok {
+		t.Fatal("token no longer verifies")
+	}
+	session, err := j.store.GetSession(context.Background(), tok.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.PagesViewed != pages || !session.HasScrolled {
+		t.Errorf("session after %d pages and a scroll: %d pages, scrolled %v", pages, session.PagesViewed, session.HasScrolled)
+	}
+}
+
+func TestPassiveSessions(t *testing.T) {
+	if config.DefaultConfig().Monitoring.Enabled {
+		t.Error("monitoring is on by default, but nothing adds telemetry.js to pages")
+	}
+	for _, enabled := range []bool{false, true} {
+		j := testJanus(t, func(cfg *config.JanusConfig) {
+			cfg.Actions = []config.ActionBand{{Action: detect.ActionInvisible}}
+			cfg.Monitoring.Enabled = enabled
+			cfg.Monitoring.MaxPassivePages = 3
+		})
+		c := newClient(t, j, "192.0.2.1")
+		c.solve("/")
+		served := 0
+		for i := 0; i < 5; i++ {
+			if c.served("/") {
+				served++
+			}
+		}
+		// Sessions without telemetry are passive; only monitoring ends them.
+		if want := map[bool]int{false: 5, true: 2}[enabled]; served != want {
+			t.Errorf("monitoring %v: served %d of 5 passive pages, want %d", enabled, served, want)
+		}
+	}
+}
+
+func TestGraceNeedsFingerprintBinding(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.StoreBackend = "memory"
+	cfg.TLSFingerprintDB = ""
+	cfg.TokenBinding.Modes = []string{"ip"}
+	cfg.TokenBinding.Grace = true
+	j, err := New(Options{Config: cfg, GeoIPPath: "testdata/missing.mmdb", SigningKey: []byte(strings.Repeat("k", 32)), Logger: log.New(io.Discard, "", 0)})
+	if err == nil {
+		j.Close()
+		t.Fatal("grace without fingerprint binding accepted")
+	}
+}
+
+func TestGraceRebind(t *testing.T) {
+	j := testJanus(t, func(cfg *config.JanusConfig) {
+		cfg.Actions = []config.ActionBand{{Action: detect.ActionInvisible}}
+		cfg.TokenBinding.Modes = []string{"ip", "fingerprint"}
+		cfg.TokenBinding.Grace = true
+		cfg.Routes = []config.RouteConfig{{Name: "login", Path: "/login", MinAction: detect.ActionInteractive}}
+	})
+	c := newClient(t, j, "192.0.2.1")
+	c.solve("/login")
+
+	// Moving network: the route needs an interactive challenge, so the
+	// token is not rebound there.
+	c.ip = "198.51.100.7"
+	if c.served("/login") {
+		t.Fatal("token rebound on a route needing an interactive challenge")
+	}
+	if n := j.metrics.Get("token_rebinds"); n != 0 {
+		t.Fatalf("%d rebinds, want 0", n)
+	}
+	// Elsewhere an invisible challenge would do, so it is.
+	if !c.served("/") {
+		t.Fatal("drifted token not rebound")
+	}
+	if n := j.metrics.Get("token_rebinds"); n != 1 {
+		t.Fatalf("%d rebinds, want 1", n)
+	}
+	if !c.served("/login") {
+		t.Fatal("rebound token rejected on its route")
+	}
+}
+
+func TestVisitorCookieAttributes(t *testing.T) {
+	cases := []struct {
+		name   string
+		cookie config.CookieConfig
+		want   http.Cookie
+	}{
+		{"plain", config.CookieConfig{SameSite: "lax", Domain: "example.com"},
+			http.Cookie{Name: "janus_sid", Domain: "example.com", SameSite: http.SameSiteLaxMode}},
+		{"host prefix", config.CookieConfig{SameSite: "strict", Secure: true, HostPrefix: true},
+			http.Cookie{Name: "__Host-janus_sid", Secure: true, SameSite: http.SameSiteStrictMode}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			j := testJanus(t, func(cfg *config.JanusConfig) {
+				cfg.Actions = []config.ActionBand{{Action: detect.ActionInvisible}}
+				tc.cookie.Name, tc.cookie.Path = "janus_token", "/"
+				cfg.Cookie = tc.cookie
+			})
+			c := newClient(t, j, "192.0.2.1")
+			c.do(http.MethodGet, "/", nil)
+			got, ok := c.cookies[tc.want.Name]
+			if !ok {
+				t.Fatalf("no %s cookie in %v", tc.want.Name, c.cookies)
+			}
+			if got.Path != "/" || got.Domain != tc.want.Domain || got.Secure != tc.want.Secure || got.SameSite != tc.want.SameSite || !got.HttpOnly {
+				t.Errorf("cookie %+v, want %+v", got, tc.want)
+			}
+			c.solve("/")
+			if !c.served("/") {
+				t.Error("visitor not served after solving with this cookie")
+			}
+		})
+	}
+}
+
+func TestRedisFailureModes(t *testing.T) {
+	down := func(mode string) *Janus {
+		return testJanus(t, func(cfg *config.JanusConfig) {
+			cfg.StoreBackend = "redis"
+			cfg.RedisAddr = "127.0.0.1:1"
+			cfg.RedisFailureMode = mode
+			cfg.Actions = []config.ActionBand{{Action: detect.ActionInvisible}}
+		})
+	}
+
+	t.Run("local-fallback", func(t *testing.T) {
+		j := down(failLocal)
+		if j.storeHealthy() {
+			t.Fatal("unreachable Redis counts as healthy")
+		}
+		c := newClient(t, j, "192.0.2.1")
+		if c.served("/") {
+			t.Fatal("unverified visitor served")
+		}
+		c.solve("/")
+		if !c.served("/") {
+			t.Fatal("visitor verified from local state not served")
+		}
+		if j.metrics.Get("store_local_fallback") == 0 {
+			t.Error("store_local_fallback not counted")
+		}
+	})
+
+	t.Run("open", func(t *testing.T) {
+		j := down(failOpen)
+		c := newClient(t, j, "192.0.2.1")
+		if !c.served("/") {
+			t.Fatal("unverified visitor not served in open mode")
+		}
+		if j.metrics.Get("challenge_fail_open") == 0 || j.metrics.Get("ratelimit_fail_open") == 0 {
+			t.Error("fail-open requests not counted")
+		}
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		j := down(failClosed)
+		c := newClient(t, j, "192.0.2.1")
+		for _, req := range []struct{ method, target string }{
+			{http.MethodGet, "/"},
+			{http.MethodPost, "/janus/fingerprint"},
+			{http.MethodGet, "/janus/challenge?path=/"},
+		} {
+			w := c.do(req.method, req.target, map[string]interface{}{})
+			if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "2" {
+				t.Errorf("%s %s: %d, Retry-After %q; want 503, 2", req.method, req.target, w.Code, w.Header().Get("Retry-After"))
+			}
+		}
+	})
+}
+
+// TestChallengeSharedAcrossInstances issues a challenge on one replica and
+// solves it on another sharing its store, as behind a load balancer.
+func TestChallengeSharedAcrossInstances(t *testing.T) {
+	cfg := func(cfg *config.JanusConfig) {
+		cfg.Actions = []config.ActionBand{{Action: detect.ActionInvisible}}
+	}
+	a, b := testJanus(t, cfg), testJanus(t, cfg)
+	b.store.Close()
+	b.store = a.store
+
+	onA := newClient(t, a, "192.0.2.1")
+	chal := onA.challenge("/")
+	onB := newClient(t, b, "192.0.2.1")
+	onB.cookies = onA.cookies
+	body := map[string]string{"nonce": chal.Nonce, "proof": onA.prove(chal)}
+	if w := onB.verify(body); w.Code != http.StatusOK {
+		t.Fatalf("verify on the other replica: %d %s", w.Code, w.Body)
+	}
+	if !onB.served("/") || !onA.served("/") {
+		t.Fatal("verified visitor not served by both replicas")
+	}
+	// The challenge is gone for every replica once used.
+	if w := onA.verify(body); w.Code == http.StatusOK {
+		t.Fatal("challenge replayed on the issuing replica")
+	}
+	if n := a.metrics.Get("challenge_replays"); n != 1 {
+		t.Errorf("%d replays counted, want 1", n)
+	}
+}
+
+// TestFingerprintPerVisitor checks fingerprints are kept per janus_sid, so
+// visitors behind one NAT address do not share them, and expire.
+func TestFingerprintPerVisitor(t *testing.T) {
+	j := testJanus(t, func(cfg *config.JanusConfig) {
+		cfg.Actions = []config.ActionBand{{Action: detect.ActionInvisible}}
+		cfg.FingerprintTTL = 100 * time.Millisecond
+	})
+	a := newClient(t, j, "192.0.2.1")
+	a.challenge("/")
+
+	b := newClient(t, j, "192.0.2.1")
+	b.served("/")
+	if w := b.do(http.MethodGet, "/janus/challenge?path=/", nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("second visitor on the same IP got a challenge without a fingerprint: %d", w.Code)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if w := a.do(http.MethodGet, "/janus/challenge?path=/", nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("challenge after the fingerprint expired: %d, want 400", w.Code)
+	}
+}
+
+func TestNonceSingleUse(t *testing.T) {
+	j := testJanus(t, func(cfg *config.JanusConfig) {
+		cfg.Actions = []config.ActionBand{{Action: detect.ActionInvisible}}
+	})
+	c := newClient(t, j, "192.0.2.1")
+
+	// A failed attempt uses the challenge up.
+	chal := c.challenge("/")
+	if w := c.verify(map[string]string{"nonce": chal.Nonce, "proof": "bad"}); w.Code != http.StatusUnauthorized {
+		t.Fatalf("bad proof: %d, want 401", w.Code)
+	}
+	if w := c.verify(map[string]string{"nonce": chal.Nonce, "proof": c.prove(chal)}); w.Code != http.StatusBadRequest {
+		t.Fatalf("good proof for a used challenge: %d, want 400", w.Code)
+	}
+
+	// So does a successful one, and a nonce the server never issued is
+	// no challenge at all.
+	chal = c.challenge("/")
+	body := map[string]string{"nonce": chal.Nonce, "proof": c.prove(chal)}
+	if w := c.verify(body); w.Code != http.StatusOK {
+		t.Fatalf("verify: %d %s", w.Code, w.Body)
+	}
+	if w := c.verify(body); w.Code != http.StatusBadRequest {
+		t.Fatalf("replayed proof: %d, want 400", w.Code)
+	}
+	if w := c.verify(map[string]string{"nonce": "made-up", "proof": "x"}); w.Code != http.StatusBadRequest {
+		t.Fatalf("forged nonce: %d, want 400", w.Code)
+	}
+	if n := j.metrics.Get("challenge_replays"); n != 3 {
+		t.Errorf("%d replays counted, want 3", n)
+	}
+}
+
+func TestChallengeAttemptCap(t *testing.T) {
+	j := testJanus(t, func(cfg *config.JanusConfig) {
+		cfg.Actions = []config.ActionBand{{Action: detect.ActionInvisible}}
+		cfg.ChallengeMaxAttempts = 2
+	})
+	c := newClient(t, j, "192.0.2.1")
+	for i := 0; i < 2; i++ {
+		chal := c.challenge("/")
+		c.verify(map[string]string{"nonce": chal.Nonce, "proof": "bad"})
+	}
+	chal := c.challenge("/")
+	if w := c.verify(map[string]string{"nonce": chal.Nonce, "proof": c.prove(chal)}); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("third attempt: %d, want 429", w.Code)
+	}
+	if n := j.metrics.Get("challenge_attempts_exceeded"); n != 1 {
+		t.Errorf("challenge_attempts_exceeded = %d, want 1", n)
+	}
+}
+
+func TestRateLimitBurst(t *testing.T) {
+	j := testJanus(t, func(cfg *config.JanusConfig) {
+		cfg.RateLimit = config.RateLimitConfig{RequestsPerMinute: 60, Burst: 2}
+	})
+	c := newClient(t, j, "192.0.2.1")
+	for i := 0; i < 3; i++ {
+		if !c.served("/") {
+			t.Fatalf("request %d within the burst not served", i)
+		}
+	}
+	if w := c.do(http.MethodGet, "/", nil); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request past the burst: %d, want 429", w.Code)
+	}
+	if !newClient(t, j, "192.0.2.2").served("/") {
+		t.Error("other IP limited")
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	j := testJanus(t, func(cfg *config.JanusConfig) {
+		cfg.RateLimit = config.RateLimitConfig{RequestsPerMinute: 60, Burst: 1}
+	})
+	c := newClient(t, j, "192.0.2.1")
+	w := c.do(http.MethodGet, "/", nil)
+	if w.Header().Get("RateLimit-Limit") != "2" || w.Header().Get("RateLimit-Remaining") != "1" || w.Header().Get("RateLimit-Policy") == "" {
+		t.Fatalf("served response headers %v, want RateLimit-Limit 2 and Remaining 1", w.Header())
+	}
+	c.do(http.MethodGet, "/", nil)
+
+	r := c.request(http.MethodGet, "/api", nil)
+	r.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	c.h.ServeHTTP(w, r)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("limited response %d %v, want 429 with Retry-After 1", w.Code, w.Header())
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body["policy"] != "global/ip" {
+		t.Errorf("limited JSON body %s (%v), want policy global/ip", w.Body, err)
+	}
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	j := testJanus(t, func(cfg *config.JanusConfig) {
+		cfg.Actions = []config.ActionBand{{Action: detect.ActionInvisible}}
+		cfg.RateLimit = config.RateLimitConfig{RequestsPerMinute: 600, Burst: 20, Keys: []config.RateLimitKeyConfig{
+			{Key: "header:X-Api-Key", RequestsPerMinute: 60},
+			{Key: "token", RequestsPerMinute: 60, Burst: 1},
+		}}
+	})
+	withKey := func(c *client) int {
+		r := c.request(http.MethodGet, "/", nil)
+		r.Header.Set("X-Api-Key", "shared")
+		w := httptest.NewRecorder()
+		c.h.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := withKey(newClient(t, j, "192.0.2.1")); code == http.StatusTooManyRequests {
+		t.Fatal("first request with the API key limited")
+	}
+	if code := withKey(newClient(t, j, "198.51.100.1")); code != http.StatusTooManyRequests {
+		t.Errorf("API key reused from another IP: %d, want 429", code)
+	}
+
+	c := newClient(t, j, "192.0.2.1")
+	c.solve("/")
+	if !c.served("/") || !c.served("/") {
+		t.Fatal("verified visitor not served within the token burst")
+	}
+	if w := c.do(http.MethodGet, "/", nil); w.Code != http.StatusTooManyRequests {
+		t.Errorf("request past the token limit: %d, want 429", w.Code)
+	}
+}
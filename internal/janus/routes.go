@@ -0,0 +1,141 @@
+package janus
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+
+	"janus/internal/challenge"
+	"janus/internal/clientip"
+	"janus/internal/config"
+	"janus/internal/detect"
+	"janus/internal/ratelimit"
+	"janus/internal/routes"
+	"janus/internal/tlsfp"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// actionRank orders actions by severity for routes' min_action.
+var actionRank = map[string]int{
+	detect.ActionAllow:       0,
+	detect.ActionInvisible:   1,
+	detect.ActionInteractive: 2,
+	detect.ActionTarpit:      3,
+	detect.ActionBlock:       4,
+}
+
+// compileRoutes builds the global limiter, the route table and a limiter
+// for every route with a rate limit of its own.
+func (j *Janus) compileRoutes(global config.RateLimitConfig, cfgs []config.RouteConfig) error {
+	var err error
+	if j.limiter, err = ratelimit.New("global", global); err != nil {
+		return err
+	}
+	if j.routes, err = routes.Compile(cfgs); err != nil {
+		return err
+	}
+	all := []*ratelimit.Limiter{j.limiter}
+	j.routeLimiters = make(map[int]*ratelimit.Limiter)
+	for _, rt := range j.routes.Routes() {
+		if _, ok := actionRank[rt.MinAction]; rt.MinAction != "" && !ok {
+			return fmt.Errorf("janus: route %q: unknown min_action %q", rt.Name, rt.MinAction)
+		}
+		if rt.Difficulty > challenge.MaxDifficulty {
+			return fmt.Errorf("janus: route %q: difficulty %d is above the solvable maximum of %d", rt.Name, rt.Difficulty, challenge.MaxDifficulty)
+		}
+		if rt.RateLimit != nil {
+			l, err := ratelimit.New("route:"+rt.Name, *rt.RateLimit)
+			if err != nil {
+				return err
+			}
+			j.routeLimiters[rt.Index] = l
+			all = append(all, l)
+		}
+	}
+	j.rateKeys = make(map[string]bool)
+	for _, key := range []string{ratelimit.KeyASN, ratelimit.KeyToken, ratelimit.KeyFingerprint} {
+		for _, l := range all {
+			j.rateKeys[key] = j.rateKeys[key] || l.Uses(key)
+		}
+	}
+	return nil
+}
+
+// matchRoute returns the route for r, or nil.
+func (j *Janus) matchRoute(r *http.Request) *routes.Route {
+	return j.routes.Match(r.Method, r.Host, r.URL.Path)
+}
+
+// limitersFor returns the global limiter followed by route's own, if any.
+func (j *Janus) limitersFor(route *routes.Route) []*ratelimit.Limiter {
+	limiters := []*ratelimit.Limiter{j.limiter}
+	if route != nil {
+		if l := j.routeLimiters[route.Index]; l != nil {
+			limiters = append(limiters, l)
+		}
+	}
+	return limiters
+}
+
+// rateRequest collects what r can be rate limited on. The ASN, token and
+// fingerprint are only worked out when a limit uses them; the token only
+// needs a valid signature, as the limit runs before the full check.
+func (j *Janus) rateRequest(r *http.Request) *ratelimit.Request {
+	req := &ratelimit.Request{IP: clientip.FromRequest(r), Header: r.Header}
+	if fp, ok := tlsfp.FromContext(r.Context()); ok {
+		req.JA3, req.JA4 = fp.JA3Hash, fp.JA4
+	}
+	if j.rateKeys[ratelimit.KeyASN] && j.asnDB != nil {
+		if addr, err := netip.ParseAddr(req.IP); err == nil {
+			if rec, err := j.asnDB.ASN(addr.Unmap()); err == nil {
+				req.ASN = rec.AutonomousSystemNumber
+			}
+		}
+	}
+	if j.rateKeys[ratelimit.KeyToken] {
+		if c, err := r.Cookie(j.cookieName); err == nil {
+			if token, err := j.parseToken(c.Value); err == nil {
+				if claims, ok := token.Claims.(jwt.MapClaims); ok {
+					req.Token, _ = claims["jti"].(string)
+				}
+			}
+		}
+	}
+	if j.rateKeys[ratelimit.KeyFingerprint] {
+		req.Fingerprint = shortHash(req.JA4 + "|" + r.Header.Get("User-Agent") + "|" + r.Header.Get("Accept-Language") + "|" + r.Header.Get("Accept-Encoding"))
+	}
+	return req
+}
+
+// actionForRoute is actionFor raised to route's min_action. Whitelisted
+// requests stay allowed.
+func (j *Janus) actionForRoute(d *detect.Decision, route *routes.Route) action {
+	a := j.actionFor(d)
+	if route == nil || route.MinAction == "" || d.Whitelisted {
+		return a
+	}
+	if actionRank[a.Action] < actionRank[route.MinAction] {
+		return j.actionNamed(route.MinAction)
+	}
+	return a
+}
+
+// tokenCovers reports whether tok was earned by a challenge at least as
+// strict as route asks for: its action at or above a min_action of
+// invisible or interactive, and its difficulty at or above the route's.
+// Tokens predating the act and dif claims cover no such route.
+func tokenCovers(tok *verifiedToken, route *routes.Route) (bool, string) {
+	if route == nil {
+		return true, ""
+	}
+	act, _ := tok.claims["act"].(string)
+	if (route.MinAction == detect.ActionInvisible || route.MinAction == detect.ActionInteractive) && actionRank[act] < actionRank[route.MinAction] {
+		return false, fmt.Sprintf("token earned with action %q, route needs %s", act, route.MinAction)
+	}
+	// JSON numbers decode as float64.
+	if dif, _ := tok.claims["dif"].(float64); int(dif) < route.Difficulty {
+		return false, fmt.Sprintf("token earned at difficulty %d, route needs %d", int(dif), route.Difficulty)
+	}
+	return true, ""
+}
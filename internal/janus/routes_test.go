@@ -0,0 +1,104 @@
+package janus
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+
+	"janus/internal/challenge"
+	"janus/internal/config"
+	"janus/internal/detect"
+)
+
+func TestRouteBypass(t *testing.T) {
+	j := testJanus(t, func(cfg *config.JanusConfig) {
+		cfg.Actions = []config.ActionBand{{Action: detect.ActionBlock}}
+		cfg.RateLimit = config.RateLimitConfig{RequestsPerMinute: 60}
+		cfg.Routes = []config.RouteConfig{{Name: "health", Path: "/healthz", Bypass: true}}
+	})
+	c := newClient(t, j, "192.0.2.1")
+	for i := 0; i < 3; i++ {
+		if !c.served("/healthz") {
+			t.Fatalf("request %d to a bypass route not served", i)
+		}
+	}
+	if c.served("/") {
+		t.Error("blocked visitor served outside the bypass route")
+	}
+}
+
+func TestRouteMinAction(t *testing.T) {
+	j := testJanus(t, func(cfg *config.JanusConfig) {
+		cfg.Routes = []config.RouteConfig{
+			{Name: "admin", Path: "/admin/**", MinAction: detect.ActionBlock},
+			{Name: "checkout", Path: "/checkout", Methods: []string{"POST"}, MinAction: detect.ActionInvisible},
+		}
+	})
+	c := newClient(t, j, "192.0.2.1")
+	if !c.served("/") {
+		t.Fatal("allowed visitor not served")
+	}
+	if w := c.do(http.MethodGet, "/admin/users", nil); w.Code != http.StatusForbidden {
+		t.Errorf("/admin/users: %d, want 403", w.Code)
+	}
+	if !c.served("/checkout") {
+		t.Error("GET /checkout challenged by a POST-only route")
+	}
+	if w := c.do(http.MethodPost, "/checkout", nil); w.Code == http.StatusOK && w.Body.String() == "ok" {
+		t.Error("POST /checkout served without a challenge")
+	}
+}
+
+func TestRouteRateLimit(t *testing.T) {
+	j := testJanus(t, func(cfg *config.JanusConfig) {
+		cfg.RateLimit = config.RateLimitConfig{RequestsPerMinute: 60, Burst: 10}
+		cfg.Routes = []config.RouteConfig{{Name: "login", Path: "/login", RateLimit: &config.RateLimitConfig{RequestsPerMinute: 6}}}
+	})
+	c := newClient(t, j, "192.0.2.1")
+	if !c.served("/login") {
+		t.Fatal("first login request not served")
+	}
+	if w := c.do(http.MethodGet, "/login", nil); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second login request: %d, want 429", w.Code)
+	}
+	// The refused request did not count against the global limit.
+	for i := 0; i < 10; i++ {
+		if !c.served("/") {
+			t.Fatalf("request %d elsewhere not served", i)
+		}
+	}
+}
+
+func TestRouteDifficulty(t *testing.T) {
+	j := testJanus(t, func(cfg *config.JanusConfig) {
+		cfg.Actions = []config.ActionBand{{Action: detect.ActionInvisible}}
+		cfg.Routes = []config.RouteConfig{{Name: "signup", Path: "/signup", Difficulty: 10}}
+	})
+	c := newClient(t, j, "192.0.2.1")
+	if d := c.challenge("/").Difficulty; d >= 10 {
+		t.Fatalf("difficulty %d outside the route, want the default", d)
+	}
+	if d := c.challenge("/signup").Difficulty; d < 10 {
+		t.Errorf("difficulty %d on /signup, want at least 10", d)
+	}
+}
+
+func TestRouteConfigErrors(t *testing.T) {
+	for name, rc := range map[string]config.RouteConfig{
+		"unknown min_action": {Path: "/a", MinAction: "captcha"},
+		"unsolvable":         {Path: "/a", Difficulty: challenge.MaxDifficulty + 1},
+		"bad rate limit":     {Path: "/a", RateLimit: &config.RateLimitConfig{}},
+	} {
+		cfg := config.DefaultConfig()
+		cfg.StoreBackend = "memory"
+		cfg.TLSFingerprintDB = ""
+		cfg.Routes = []config.RouteConfig{rc}
+		j, err := New(Options{Config: cfg, GeoIPPath: "testdata/missing.mmdb", SigningKey: []byte(strings.Repeat("k", 32)), Logger: log.New(io.Discard, "", 0)})
+		if err == nil {
+			j.Close()
+			t.Errorf("%s: New accepted route %+v", name, rc)
+		}
+	}
+}
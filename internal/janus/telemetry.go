@@ -0,0 +1,189 @@
+package janus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"janus/internal/behavior"
+	"janus/internal/clientip"
+	"janus/internal/detect"
+	"janus/internal/store"
+	"janus/internal/types"
+)
+
+const (
+	maxTelemetryBody   = 64 << 10
+	maxTelemetryEvents = 500
+)
+
+// errSessionRevoked stops a telemetry update to a revoked session.
+var errSessionRevoked = errors.New("janus: session revoked")
+
+// telemetryEvent is one behavioural event from assets/telemetry.js. T is
+// milliseconds since page load; X and Y are set for pointer events and D,
+// the time the key was held, for key events.
+type telemetryEvent struct {
+	Type string  `json:"type"`
+	T    float64 `json:"t"`
+	X    float64 `json:"x"`
+	Y    float64 `json:"y"`
+	D    float64 `json:"d"`
+}
+
+type telemetryBatch struct {
+	Path   string           `json:"path"`
+	Events []telemetryEvent `json:"events"`
+}
+
+// handleTelemetry folds a batch of behavioural events into the verified
+// visitor's session.
+func (j *Janus) handleTelemetry(w http.ResponseWriter, r *http.Request) {
+	clientIP := clientip.FromRequest(r)
+	tok, ok := j.verifyToken(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	session := tok.session
+	if session == nil || session.Revoked {
+		j.logger.Printf("handleTelemetry: No active session for IP %s, token %s", clientIP, tok.id)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var batch telemetryBatch
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxTelemetryBody)).Decode(&batch); err != nil {
+		j.logger.Printf("handleTelemetry: Invalid batch from IP %s: %v", clientIP, err)
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if len(batch.Events) > maxTelemetryEvents {
+		batch.Events = batch.Events[:maxTelemetryEvents]
+	}
+
+	var (
+		input    types.Behavior
+		scrolled bool
+	)
+	for _, ev := range batch.Events {
+		switch ev.Type {
+		case "scroll":
+			scrolled = true
+		case "mousemove", "touchmove":
+			input.Pointer = append(input.Pointer, types.PointerSample{X: ev.X, Y: ev.Y, T: ev.T})
+		case "key":
+			input.Keys = append(input.Keys, types.KeySample{T: ev.T, D: ev.D})
+		}
+	}
+	res := behavior.Analyze(&input)
+	natural := res.Scored && res.Score >= j.cfg.Behavior.HumanScore
+
+	// Page views and other batches update the session concurrently, so
+	// only this batch's findings are merged into the stored copy.
+	_, err := j.store.UpdateSession(r.Context(), tok.id, sessionTTL(tok), func(s *store.Session) error {
+		if s.Revoked {
+			return errSessionRevoked
+		}
+		s.HasScrolled = s.HasScrolled || scrolled
+		s.HasNaturalMouseMovement = s.HasNaturalMouseMovement || natural
+		s.LastSeen = time.Now()
+		return nil
+	})
+	if errors.Is(err, errSessionRevoked) || errors.Is(err, store.ErrNotFound) {
+		j.logger.Printf("handleTelemetry: Session %s for IP %s ended", tok.id, clientIP)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		j.logger.Printf("handleTelemetry: Failed to save session %s: %v", tok.id, err)
+		j.storeFailed(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// monitorSession records a verified page view and reports whether the
+// request may proceed. Sessions that look automated are ended with the
+// configured monitoring action, which is written to w.
+func (j *Janus) monitorSession(w http.ResponseWriter, r *http.Request, tok *verifiedToken) bool {
+	session := tok.session
+	if !j.cfg.Monitoring.Enabled || session == nil {
+		// Without the store the token alone stands.
+		return true
+	}
+	if session.Revoked {
+		j.endSession(w, r, "session previously revoked")
+		return false
+	}
+
+	if !isNavigation(r) {
+		return true
+	}
+	limit := j.cfg.Monitoring.MaxPassivePages
+	session, err := j.store.UpdateSession(r.Context(), tok.id, sessionTTL(tok), func(s *store.Session) error {
+		if s.Revoked {
+			return nil
+		}
+		s.PagesViewed++
+		s.LastSeen = time.Now()
+		s.NavigationPath = append(s.NavigationPath, r.URL.Path)
+		if n := j.cfg.Monitoring.PathHistory; n > 0 && len(s.NavigationPath) > n {
+			s.NavigationPath = s.NavigationPath[len(s.NavigationPath)-n:]
+		}
+		passive := !s.HasScrolled && !s.HasNaturalMouseMovement
+		if passive && limit > 0 && s.PagesViewed >= limit {
+			s.Revoked = true
+		}
+		return nil
+	})
+	if err != nil {
+		j.logger.Printf("monitorSession: Failed to save session %s: %v", tok.id, err)
+		return true
+	}
+	if session.Revoked {
+		j.endSession(w, r, fmt.Sprintf("%d pages without scrolling or mouse movement", session.PagesViewed))
+		return false
+	}
+	return true
+}
+
+// endSession drops the visitor's token and answers with the monitoring
+// action, recording why as an explainable decision.
+func (j *Janus) endSession(w http.ResponseWriter, r *http.Request, reason string) {
+	j.clearToken(w)
+
+	name := detect.ActionInteractive
+	if j.cfg.Monitoring.Action == "revoke" {
+		name = detect.ActionBlock
+	}
+	a := j.actionNamed(name)
+	d := j.decide(r)
+	d.Signals = append(d.Signals, detect.Signal{Name: "automated_session", Detector: "monitoring", Evidence: reason})
+	d.Action = a.Action
+	j.recordDecision(w, r, d)
+	j.logger.Printf("endSession: Ending session for IP %s (%s), action %s", d.ClientIP, reason, a.Action)
+	j.respond(w, r, a, d)
+}
+
+// sessionTTL keeps a session for as long as its token is valid.
+func sessionTTL(tok *verifiedToken) time.Duration {
+	if ttl := time.Until(tok.expires); ttl > 0 {
+		return ttl
+	}
+	return time.Minute
+}
+
+// isNavigation reports whether r loads a page rather than a subresource.
+func isNavigation(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	if mode := r.Header.Get("Sec-Fetch-Mode"); mode != "" {
+		return mode == "navigate"
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
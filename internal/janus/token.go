@@ -0,0 +1,394 @@
+package janus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"janus/internal/clientip"
+	"janus/internal/config"
+	"janus/internal/signing"
+	"janus/internal/store"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// janus_token claims: jti is the token ID and the key of its session, ip
+// the client it was issued to, vid the visitor (janus_sid) that solved the
+// challenge and auth_time when it did. net, asn and fp hold the token
+// binding (see binding.go). Refreshed tokens keep all of these and get new
+// iat and exp.
+
+// verifiedToken is a janus_token that passed verifyToken.
+type verifiedToken struct {
+	claims  jwt.MapClaims
+	id      string
+	expires time.Time
+	// session is nil when the store could not be read.
+	session *store.Session
+	// current is the request's binding; drifted is set when only its
+	// network part no longer matches the token.
+	current binding
+	drifted bool
+	// storeErr is set, and the token refused, when the store could not be
+	// read in closed failure mode.
+	storeErr error
+}
+
+// loadKeys returns the signing keys from the signing config section, else
+// the SigningKey option, else JANUS_SIGNING_KEY, else a random key that
+// only this process accepts.
+func loadKeys(cfg *config.JanusConfig, secret []byte, logger *log.Logger) (*signing.Keyring, error) {
+	if len(cfg.Signing.Keys) > 0 {
+		keys, err := signing.Load(cfg.Signing)
+		if err != nil {
+			return nil, err
+		}
+		logger.Printf("loadKeys: Signing janus_token with %s key %q", keys.Active().Alg, keys.Active().ID)
+		return keys, nil
+	}
+	if len(secret) > 0 {
+		return signing.NewHMAC("default", secret)
+	}
+	if env := os.Getenv("JANUS_SIGNING_KEY"); env != "" {
+		return signing.NewHMAC("env", []byte(env))
+	}
+	logger.Printf("loadKeys: No signing key configured, using a random key; tokens will not survive a restart or work across replicas")
+	return signing.Ephemeral()
+}
+
+// tokenCookie validates the cookie section and returns the cookie name and
+// SameSite mode.
+func tokenCookie(cfg config.CookieConfig) (string, http.SameSite, error) {
+	var sameSite http.SameSite
+	switch strings.ToLower(cfg.SameSite) {
+	case "", "lax":
+		sameSite = http.SameSiteLaxMode
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "none":
+		if !cfg.Secure {
+			return "", 0, fmt.Errorf("janus: cookie same_site none needs secure")
+		}
+		sameSite = http.SameSiteNoneMode
+	default:
+		return "", 0, fmt.Errorf("janus: unknown cookie same_site %q", cfg.SameSite)
+	}
+	if !cfg.HostPrefix {
+		return cfg.Name, sameSite, nil
+	}
+	if !cfg.Secure || cfg.Path != "/" || cfg.Domain != "" {
+		return "", 0, fmt.Errorf("janus: cookie host_prefix needs secure, path \"/\" and no domain")
+	}
+	return "__Host-" + cfg.Name, sameSite, nil
+}
+
+// setTokenCookie sets the janus_token cookie; maxAge < 0 deletes it.
+func (j *Janus) setTokenCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     j.cookieName,
+		Value:    value,
+		Path:     j.cfg.Cookie.Path,
+		Domain:   j.cfg.Cookie.Domain,
+		HttpOnly: true,
+		Secure:   j.cfg.Cookie.Secure,
+		SameSite: j.sameSite,
+		MaxAge:   maxAge,
+	})
+}
+
+// issueToken signs claims, expiring at exp, and sets them as the
+// janus_token cookie.
+func (j *Janus) issueToken(w http.ResponseWriter, claims jwt.MapClaims, exp time.Time) error {
+	claims["exp"] = exp.Unix()
+	tokenString, err := j.keys.Sign(claims)
+	if err != nil {
+		return err
+	}
+	maxAge := time.Until(exp)
+	if limit := j.cfg.Cookie.MaxAge; limit > 0 && limit < maxAge {
+		maxAge = limit
+	}
+	j.setTokenCookie(w, tokenString, int(maxAge.Seconds()))
+	return nil
+}
+
+// clearToken removes the janus_token cookie.
+func (j *Janus) clearToken(w http.ResponseWriter) {
+	j.setTokenCookie(w, "", -1)
+}
+
+// verifyToken checks the janus_token cookie: signature, expiry, absolute
+// lifetime, that its session still exists, the token binding and that it
+// was not revoked. Store errors fail open, except in closed failure mode,
+// where the token is refused and returned with storeErr set. A token that
+// fails only because its network binding drifted is returned with drifted
+// set, for grace mode.
+func (j *Janus) verifyToken(r *http.Request) (*verifiedToken, bool) {
+	clientIP := clientip.FromRequest(r)
+	cookie, err := r.Cookie(j.cookieName)
+	if err != nil {
+		j.logger.Printf("verifyToken: No %s cookie for IP %s: %v", j.cookieName, clientIP, err)
+		return nil, false
+	}
+	token, err := j.parseToken(cookie.Value)
+	if err != nil {
+		j.logger.Printf("verifyToken: Token parsing failed for IP %s: %v", clientIP, err)
+		return nil, false
+	}
+	if !token.Valid {
+		j.logger.Printf("verifyToken: Invalid token for IP %s", clientIP)
+		return nil, false
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		j.logger.Printf("verifyToken: Invalid claims format for IP %s", clientIP)
+		return nil, false
+	}
+	id, _ := claims["jti"].(string)
+	if id == "" {
+		j.logger.Printf("verifyToken: Token without ID for IP %s", clientIP)
+		return nil, false
+	}
+	if typ, _ := claims["typ"].(string); typ != "" {
+		// Exchange handoffs are signed with the same keys.
+		j.logger.Printf("verifyToken: %s token presented as janus_token by IP %s", typ, clientIP)
+		return nil, false
+	}
+	authTime := claimTime(claims, "auth_time")
+	if time.Since(authTime) > j.cfg.TokenMaxLifetime {
+		j.logger.Printf("verifyToken: Token %s for IP %s exceeded its maximum lifetime", id, clientIP)
+		return nil, false
+	}
+
+	tok := &verifiedToken{claims: claims, id: id}
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		tok.expires = exp.Time
+	}
+	session, err := j.store.GetSession(r.Context(), id)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		j.logger.Printf("verifyToken: Session for token %s (IP %s) ended or revoked", id, clientIP)
+		return nil, false
+	case err != nil:
+		j.logger.Printf("verifyToken: Failed to load session for token %s: %v", id, err)
+		if j.cfg.RedisFailureMode == failClosed {
+			tok.storeErr = err
+			return tok, false
+		}
+	default:
+		tok.session = session
+		authTime = session.VerifiedAt
+	}
+
+	device := ""
+	if tok.session != nil {
+		device = tok.session.Device
+	}
+	tok.current = j.bindingFor(r, device)
+	network, deviceOK, reason := j.checkBinding(claims, tok.current)
+	if !deviceOK && tok.session == nil {
+		// The device half of the fingerprint lives in the session.
+		deviceOK = true
+	}
+	if !deviceOK {
+		j.logger.Printf("verifyToken: Token %s for IP %s no longer matches its binding: %s", id, clientIP, reason)
+		return nil, false
+	}
+
+	scopes := []string{"all", "ip:" + clientIP}
+	if issuedTo, _ := claims["ip"].(string); issuedTo != "" && issuedTo != clientIP {
+		scopes = append(scopes, "ip:"+issuedTo)
+	}
+	if vid, _ := claims["vid"].(string); vid != "" {
+		scopes = append(scopes, "visitor:"+vid)
+	}
+	cutoff, err := j.store.RevokedAt(r.Context(), scopes...)
+	if err != nil {
+		j.logger.Printf("verifyToken: Failed to check revocations for token %s: %v", id, err)
+		if j.cfg.RedisFailureMode == failClosed {
+			tok.storeErr = err
+			return tok, false
+		}
+	} else if !cutoff.IsZero() && !authTime.After(cutoff) {
+		j.logger.Printf("verifyToken: Token %s for IP %s revoked at %s", id, clientIP, cutoff.Format(time.RFC3339))
+		return nil, false
+	}
+
+	if !network {
+		j.logger.Printf("verifyToken: Token %s binding drifted: %s", id, reason)
+		tok.drifted = true
+		return tok, false
+	}
+	j.logger.Printf("verifyToken: Valid token %s for IP %s", id, clientIP)
+	return tok, true
+}
+
+// parseToken checks a JWT's signature and expiry against the keyring.
+func (j *Janus) parseToken(s string, opts ...jwt.ParserOption) (*jwt.Token, error) {
+	opts = append(opts, jwt.WithValidMethods(j.keys.Algorithms()))
+	return jwt.Parse(s, j.keys.Keyfunc, opts...)
+}
+
+// refreshToken re-issues tok once less than half of token_ttl remains, so
+// active visitors are never challenged again before token_max_lifetime.
+// Tokens whose session could not be read are not refreshed, since the
+// session may have ended.
+func (j *Janus) refreshToken(w http.ResponseWriter, r *http.Request, tok *verifiedToken) {
+	if tok.session == nil || time.Until(tok.expires) > j.cfg.TokenTTL/2 {
+		return
+	}
+	now := time.Now()
+	newExp := now.Add(j.cfg.TokenTTL)
+	if limit := claimTime(tok.claims, "auth_time").Add(j.cfg.TokenMaxLifetime); newExp.After(limit) {
+		newExp = limit
+	}
+	if !newExp.After(tok.expires) {
+		return
+	}
+
+	claims := make(jwt.MapClaims, len(tok.claims))
+	for k, v := range tok.claims {
+		claims[k] = v
+	}
+	claims["iat"] = now.Unix()
+	if err := j.store.SetSession(r.Context(), tok.id, tok.session, time.Until(newExp)); err != nil {
+		j.logger.Printf("refreshToken: Failed to extend session %s: %v", tok.id, err)
+		return
+	}
+	if err := j.issueToken(w, claims, newExp); err != nil {
+		j.logger.Printf("refreshToken: Failed to re-issue token %s: %v", tok.id, err)
+		return
+	}
+	tok.claims, tok.expires = claims, newExp
+	j.metrics.Inc("tokens_refreshed")
+	j.logger.Printf("refreshToken: Extended token %s until %s", tok.id, newExp.Format(time.RFC3339))
+}
+
+// claimTime reads a NumericDate claim, returning the zero time if absent.
+func claimTime(claims jwt.MapClaims, name string) time.Time {
+	if v, ok := claims[name].(float64); ok {
+		return time.Unix(int64(v), 0)
+	}
+	return time.Time{}
+}
+
+// RevokeToken ends the session behind one token ID; the token and any
+// refreshed copies stop working immediately.
+func (j *Janus) RevokeToken(ctx context.Context, id string) error {
+	if id == "" {
+		return fmt.Errorf("janus: empty token ID")
+	}
+	j.metrics.Inc("tokens_revoked")
+	return j.store.DeleteSession(ctx, id)
+}
+
+// RevokeIP revokes every token issued to ip so far.
+func (j *Janus) RevokeIP(ctx context.Context, ip string) error {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return fmt.Errorf("janus: invalid IP %q: %w", ip, err)
+	}
+	return j.revoke(ctx, "ip:"+addr.Unmap().String())
+}
+
+// RevokeVisitor revokes every token issued to the visitor (janus_sid) id
+// so far.
+func (j *Janus) RevokeVisitor(ctx context.Context, id string) error {
+	if id == "" {
+		return fmt.Errorf("janus: empty visitor ID")
+	}
+	return j.revoke(ctx, "visitor:"+id)
+}
+
+// RevokeAll revokes every token issued so far; visitors must solve a new
+// challenge.
+func (j *Janus) RevokeAll(ctx context.Context) error {
+	return j.revoke(ctx, "all")
+}
+
+// revoke records a cut-off for scope. It only has to outlive the tokens it
+// applies to, which token_max_lifetime bounds.
+func (j *Janus) revoke(ctx context.Context, scope string) error {
+	j.metrics.Inc("tokens_revoked")
+	return j.store.Revoke(ctx, scope, time.Now(), j.cfg.TokenMaxLifetime)
+}
+
+// handleJWKS publishes the public signing keys so other services can
+// verify janus_token offline.
+func (j *Janus) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := json.NewEncoder(w).Encode(j.keys.JWKS()); err != nil {
+		j.logger.Printf("handleJWKS: Failed to encode response: %v", err)
+	}
+}
+
+// handleRevoke lets admin.trusted_ips revoke a token ID, all tokens of an
+// IP or visitor, or every token.
+func (j *Janus) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	clientIP := clientip.FromRequest(r)
+	if !inPrefixes(j.adminIPs, clientIP) {
+		j.logger.Printf("handleRevoke: Untrusted IP %s", clientIP)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	var req struct {
+		Token   string `json:"token"`
+		IP      string `json:"ip"`
+		Visitor string `json:"visitor"`
+		All     bool   `json:"all"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		j.logger.Printf("handleRevoke: Invalid request body from IP %s: %v", clientIP, err)
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if req.IP != "" {
+		if _, err := netip.ParseAddr(req.IP); err != nil {
+			http.Error(w, "Invalid IP", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var revoked []string
+	steps := []struct {
+		set   bool
+		scope string
+		fn    func() error
+	}{
+		{req.Token != "", "token:" + req.Token, func() error { return j.RevokeToken(r.Context(), req.Token) }},
+		{req.IP != "", "ip:" + req.IP, func() error { return j.RevokeIP(r.Context(), req.IP) }},
+		{req.Visitor != "", "visitor:" + req.Visitor, func() error { return j.RevokeVisitor(r.Context(), req.Visitor) }},
+		{req.All, "all", func() error { return j.RevokeAll(r.Context()) }},
+	}
+	for _, s := range steps {
+		if !s.set {
+			continue
+		}
+		if err := s.fn(); err != nil {
+			j.logger.Printf("handleRevoke: Failed to revoke %s: %v", s.scope, err)
+			http.Error(w, "Revocation failed: "+s.scope, http.StatusInternalServerError)
+			return
+		}
+		revoked = append(revoked, s.scope)
+	}
+	if len(revoked) == 0 {
+		http.Error(w, "Nothing to revoke", http.StatusBadRequest)
+		return
+	}
+
+	j.logger.Printf("handleRevoke: IP %s revoked %v", clientIP, revoked)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string][]string{"revoked": revoked}); err != nil {
+		j.logger.Printf("handleRevoke: Failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,262 @@
+package janus
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"janus/internal/config"
+	"janus/internal/detect"
+	"janus/internal/signing"
+	"janus/internal/store"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// tokenClaims returns the claims of c's janus_token.
+func tokenClaims(t *testing.T, j *Janus, c *client) jwt.MapClaims {
+	t.Helper()
+	ck, ok := c.cookies[j.cookieName]
+	if !ok {
+		t.Fatalf("no %s cookie", j.cookieName)
+	}
+	token, err := j.parseToken(ck.Value)
+	if err != nil {
+		t.Fatalf("parseToken: %v", err)
+	}
+	return token.Claims.(jwt.MapClaims)
+}
+
+func invisibleJanus(t *testing.T, edit func(*config.JanusConfig)) *Janus {
+	return testJanus(t, func(cfg *config.JanusConfig) {
+		cfg.Actions = []config.ActionBand{{Action: detect.ActionInvisible}}
+		if edit != nil {
+			edit(cfg)
+		}
+	})
+}
+
+func TestTokenHasID(t *testing.T) {
+	j := invisibleJanus(t, nil)
+	c := newClient(t, j, "192.0.2.1")
+	c.solve("/")
+	claims := tokenClaims(t, j, c)
+	id, _ := claims["jti"].(string)
+	if id == "" {
+		t.Fatalf("token without jti: %v", claims)
+	}
+	if _, err := j.store.GetSession(t.Context(), id); err != nil {
+		t.Errorf("no session for token %s: %v", id, err)
+	}
+	if claimTime(claims, "auth_time").IsZero() {
+		t.Errorf("token without auth_time: %v", claims)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	cases := []struct {
+		name string
+		body func(claims jwt.MapClaims) map[string]interface{}
+	}{
+		{"token", func(claims jwt.MapClaims) map[string]interface{} {
+			return map[string]interface{}{"token": claims["jti"]}
+		}},
+		{"ip", func(jwt.MapClaims) map[string]interface{} { return map[string]interface{}{"ip": "192.0.2.1"} }},
+		{"visitor", func(claims jwt.MapClaims) map[string]interface{} {
+			return map[string]interface{}{"visitor": claims["vid"]}
+		}},
+		{"all", func(jwt.MapClaims) map[string]interface{} { return map[string]interface{}{"all": true} }},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			j := invisibleJanus(t, nil)
+			c := newClient(t, j, "192.0.2.1")
+			c.solve("/")
+			if !c.served("/") {
+				t.Fatal("verified visitor not served")
+			}
+
+			body := tc.body(tokenClaims(t, j, c))
+			if w := c.do(http.MethodPost, "/janus/admin/revoke", body); w.Code != http.StatusForbidden {
+				t.Fatalf("revoke from an untrusted IP: %d, want 403", w.Code)
+			}
+			admin := newClient(t, j, "127.0.0.1")
+			if w := admin.do(http.MethodPost, "/janus/admin/revoke", body); w.Code != http.StatusOK {
+				t.Fatalf("revoke: %d %s", w.Code, w.Body)
+			}
+			if c.served("/") {
+				t.Fatal("revoked token still served")
+			}
+			// Solving again after the revocation works.
+			c.solve("/")
+			if !c.served("/") {
+				t.Fatal("token issued after the revocation rejected")
+			}
+		})
+	}
+}
+
+func TestRevokeRejectsEmptyRequests(t *testing.T) {
+	j := invisibleJanus(t, nil)
+	admin := newClient(t, j, "127.0.0.1")
+	for _, body := range []map[string]interface{}{{}, {"ip": "not-an-ip"}} {
+		if w := admin.do(http.MethodPost, "/janus/admin/revoke", body); w.Code != http.StatusBadRequest {
+			t.Errorf("revoke %v: %d, want 400", body, w.Code)
+		}
+	}
+}
+
+func TestTokenRefresh(t *testing.T) {
+	j := invisibleJanus(t, func(cfg *config.JanusConfig) {
+		cfg.TokenTTL = time.Hour
+		cfg.TokenMaxLifetime = 2 * time.Hour
+	})
+	now := time.Now()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	cases := []struct {
+		name     string
+		authTime time.Time
+		expires  time.Time
+		want     time.Time // zero if the token is not refreshed
+	}{
+		{"fresh", now, now.Add(50 * time.Minute), time.Time{}},
+		{"past half", now, now.Add(20 * time.Minute), now.Add(time.Hour)},
+		{"near max lifetime", now.Add(-100 * time.Minute), now.Add(10 * time.Minute), now.Add(20 * time.Minute)},
+		{"at max lifetime", now.Add(-110 * time.Minute), now.Add(10 * time.Minute), time.Time{}},
+		{"session unread", now, now.Add(20 * time.Minute), time.Time{}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tok := &verifiedToken{
+				claims:  jwt.MapClaims{"jti": "tok", "auth_time": float64(tc.authTime.Unix())},
+				id:      "tok",
+				expires: tc.expires,
+			}
+			if tc.name != "session unread" {
+				tok.session = &store.Session{VerifiedAt: tc.authTime}
+			}
+			w := httptest.NewRecorder()
+			j.refreshToken(w, r, tok)
+			cookies := w.Result().Cookies()
+			if tc.want.IsZero() {
+				if len(cookies) != 0 {
+					t.Errorf("token refreshed: %v", cookies)
+				}
+				return
+			}
+			if len(cookies) != 1 {
+				t.Fatalf("cookies = %v, want one refreshed token", cookies)
+			}
+			if d := tok.expires.Sub(tc.want); d < -time.Second || d > time.Second {
+				t.Errorf("refreshed until %s, want %s", tok.expires, tc.want)
+			}
+			token, err := j.parseToken(cookies[0].Value)
+			if err != nil {
+				t.Fatalf("refreshed token: %v", err)
+			}
+			claims := token.Claims.(jwt.MapClaims)
+			if claims["jti"] != "tok" || claims["auth_time"] != float64(tc.authTime.Unix()) {
+				t.Errorf("refreshed claims %v lost jti or auth_time", claims)
+			}
+		})
+	}
+}
+
+// sessionlessStore fails every session read, as a store that went down
+// after the visitor was verified.
+type sessionlessStore struct {
+	store.Store
+}
+
+func (sessionlessStore) GetSession(context.Context, string) (*store.Session, error) {
+	return nil, store.ErrUnavailable
+}
+
+func TestTokenSessionStoreDown(t *testing.T) {
+	for mode, want := range map[string]int{failOpen: http.StatusOK, failClosed: http.StatusServiceUnavailable} {
+		j := invisibleJanus(t, func(cfg *config.JanusConfig) {
+			cfg.RedisFailureMode = mode
+		})
+		c := newClient(t, j, "192.0.2.1")
+		c.solve("/")
+		j.store = sessionlessStore{j.store}
+		if w := c.do(http.MethodGet, "/", nil); w.Code != want {
+			t.Errorf("%s mode: %d with the session store down, want %d", mode, w.Code, want)
+		}
+	}
+}
+
+func TestTokenMaxLifetime(t *testing.T) {
+	j := invisibleJanus(t, func(cfg *config.JanusConfig) {
+		cfg.TokenMaxLifetime = time.Hour
+	})
+	c := newClient(t, j, "192.0.2.1")
+	c.solve("/")
+
+	// The same token, re-signed as if the challenge was solved long ago.
+	claims := tokenClaims(t, j, c)
+	claims["auth_time"] = float64(time.Now().Add(-2 * time.Hour).Unix())
+	signed, err := j.keys.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.cookies[j.cookieName].Value = signed
+	if c.served("/") {
+		t.Fatal("token past token_max_lifetime served")
+	}
+}
+
+// TestJWKSEndpoint signs tokens with an EdDSA key and verifies one offline
+// with the key published at /janus/.well-known/jwks.json.
+func TestJWKSEndpoint(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "ed.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	j := invisibleJanus(t, func(cfg *config.JanusConfig) {
+		cfg.Signing.Keys = []config.SigningKeyConfig{{ID: "ed-1", Alg: signing.EdDSA, PrivateKeyFile: path}}
+	})
+	c := newClient(t, j, "192.0.2.1")
+	c.solve("/")
+
+	w := c.do(http.MethodGet, "/janus/.well-known/jwks.json", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("jwks.json: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var set signing.JWKSet
+	if err := json.Unmarshal(w.Body.Bytes(), &set); err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Keys) != 1 || set.Keys[0].Kid != "ed-1" {
+		t.Fatalf("jwks.json = %+v, want key ed-1", set)
+	}
+	pub, err := base64.RawURLEncoding.DecodeString(set.Keys[0].X)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = jwt.Parse(c.cookies[j.cookieName].Value, func(*jwt.Token) (interface{}, error) {
+		return ed25519.PublicKey(pub), nil
+	}, jwt.WithValidMethods([]string{signing.EdDSA}))
+	if err != nil {
+		t.Errorf("janus_token does not verify with the published key: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package janus
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// visitorCookie carries the per-visitor challenge session ID that
+// fingerprints and challenges are keyed by, so visitors sharing an IP do
+// not overwrite each other.
+const visitorCookie = "janus_sid"
+
+// visitorCookieName is visitorCookie with the token cookie's __Host-
+// prefix, if it has one.
+func (j *Janus) visitorCookieName() string {
+	if strings.HasPrefix(j.cookieName, "__Host-") {
+		return "__Host-" + visitorCookie
+	}
+	return visitorCookie
+}
+
+// visitorID returns the request's challenge session ID, or "" if it has
+// none.
+func (j *Janus) visitorID(r *http.Request) string {
+	c, err := r.Cookie(j.visitorCookieName())
+	if err != nil {
+		return ""
+	}
+	if _, err := uuid.Parse(c.Value); err != nil {
+		return ""
+	}
+	return c.Value
+}
+
+// ensureVisitorID returns the request's challenge session ID, issuing a new
+// one in a cookie when it has none. The cookie takes the token cookie's
+// domain, Secure and SameSite attributes but always covers "/", since the
+// /janus/ endpoints read it.
+func (j *Janus) ensureVisitorID(w http.ResponseWriter, r *http.Request) string {
+	if id := j.visitorID(r); id != "" {
+		return id
+	}
+	id := uuid.NewString()
+	http.SetCookie(w, &http.Cookie{
+		Name:     j.visitorCookieName(),
+		Value:    id,
+		Path:     "/",
+		Domain:   j.cfg.Cookie.Domain,
+		HttpOnly: true,
+		Secure:   j.cfg.Cookie.Secure,
+		SameSite: j.sameSite,
+		MaxAge:   int(j.cfg.FingerprintTTL.Seconds()),
+	})
+	return id
+}
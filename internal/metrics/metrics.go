@@ -0,0 +1,41 @@
+// Package metrics keeps named event counters. Each Janus instance has its
+// own Counters, so instances in one process do not mix their numbers.
+package metrics
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+)
+
+// Counters is a set of named counters, safe for concurrent use.
+type Counters struct {
+	m expvar.Map
+}
+
+// New returns an empty set of counters.
+func New() *Counters {
+	c := &Counters{}
+	c.m.Init()
+	return c
+}
+
+// Inc adds one to the named counter.
+func (c *Counters) Inc(name string) {
+	c.m.Add(name, 1)
+}
+
+// Get returns the named counter's value.
+func (c *Counters) Get(name string) int64 {
+	if v, ok := c.m.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+// ServeHTTP serves the counters as JSON under "janus", as the expvar page
+// used to.
+func (c *Counters) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprintf(w, "{\"janus\": %s}\n", c.m.String())
+}
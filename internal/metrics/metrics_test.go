@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCountersAreSeparate(t *testing.T) {
+	a, b := New(), New()
+	a.Inc("x")
+	a.Inc("x")
+	b.Inc("y")
+	if a.Get("x") != 2 || a.Get("y") != 0 || b.Get("x") != 0 || b.Get("y") != 1 {
+		t.Fatalf("a = %d/%d, b = %d/%d", a.Get("x"), a.Get("y"), b.Get("x"), b.Get("y"))
+	}
+
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	var body struct {
+		Janus map[string]int64 `json:"janus"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode %q: %v", w.Body, err)
+	}
+	if body.Janus["x"] != 2 || len(body.Janus) != 1 {
+		t.Errorf("served %v, want x=2 only", body.Janus)
+	}
+}
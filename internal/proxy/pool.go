@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"net/url"
+	"sync/atomic"
+)
+
+type target struct {
+	url     *url.URL
+	healthy atomic.Bool
+}
+
+// pool round-robins over healthy targets, falling back to any target when
+// none are healthy.
+type pool struct {
+	targets []*target
+	counter atomic.Uint64
+	checked bool
+}
+
+func (p *pool) next() *target {
+	n := uint64(len(p.targets))
+	start := p.counter.Add(1)
+	for i := uint64(0); i < n; i++ {
+		t := p.targets[(start+i)%n]
+		if t.healthy.Load() {
+			return t
+		}
+	}
+	return p.targets[start%n]
+}
+
+func (p *pool) lookup(host string) *target {
+	for _, t := range p.targets {
+		if t.url.Host == host {
+			return t
+		}
+	}
+	return nil
+}
@@ -0,0 +1,313 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"janus/internal/clientip"
+	"janus/internal/config"
+)
+
+// Proxy routes verified traffic to upstream pools by host and path prefix.
+type Proxy struct {
+	routes []*route
+	logger *log.Logger
+
+	done chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+type route struct {
+	name        string
+	hosts       []string
+	pathPrefix  string
+	stripPrefix bool
+	pool        *pool
+	rp          *httputil.ReverseProxy
+}
+
+// New builds a Proxy from cfg and starts health checks for every pool
+// that configures a health_check path.
+func New(cfg config.ProxyConfig, logger *log.Logger) (*Proxy, error) {
+	if logger == nil {
+		logger = log.Default()
+	}
+	if len(cfg.Upstreams) == 0 {
+		return nil, errors.New("proxy: no upstreams configured")
+	}
+
+	p := &Proxy{logger: logger, done: make(chan struct{})}
+	base := http.DefaultTransport.(*http.Transport).Clone()
+
+	for i, up := range cfg.Upstreams {
+		name := up.Name
+		if name == "" {
+			name = fmt.Sprintf("upstream-%d", i)
+		}
+		if len(up.URLs) == 0 {
+			return nil, fmt.Errorf("proxy: upstream %s has no urls", name)
+		}
+		targets := make([]*target, 0, len(up.URLs))
+		for _, raw := range up.URLs {
+			u, err := url.Parse(raw)
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				return nil, fmt.Errorf("proxy: upstream %s has invalid url %q", name, raw)
+			}
+			t := &target{url: u}
+			t.healthy.Store(true)
+			targets = append(targets, t)
+		}
+		prefix := up.PathPrefix
+		if prefix == "" {
+			prefix = "/"
+		}
+		hosts := make([]string, 0, len(up.Hosts))
+		for _, h := range up.Hosts {
+			hosts = append(hosts, strings.ToLower(h))
+		}
+
+		rt := &route{
+			name:        name,
+			hosts:       hosts,
+			pathPrefix:  prefix,
+			stripPrefix: up.StripPrefix,
+			pool:        &pool{targets: targets, checked: up.HealthCheck.Path != ""},
+		}
+		rt.rp = &httputil.ReverseProxy{
+			Rewrite: p.rewriteFunc(rt, up.PreserveHost),
+			Transport: &retryTransport{
+				base:         base,
+				pool:         rt.pool,
+				retries:      cfg.Retries,
+				preserveHost: up.PreserveHost,
+				logger:       logger,
+			},
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				logger.Printf("proxy: upstream %s failed for %s %s: %v", name, r.Method, r.URL.Path, err)
+				http.Error(w, "Bad gateway", http.StatusBadGateway)
+			},
+		}
+		p.routes = append(p.routes, rt)
+
+		if up.HealthCheck.Path != "" {
+			p.wg.Add(1)
+			go p.healthLoop(name, rt.pool, up.HealthCheck)
+		}
+	}
+
+	// Most specific routes first: host-bound before catch-all, then
+	// longest path prefix.
+	sort.SliceStable(p.routes, func(a, b int) bool {
+		ra, rb := p.routes[a], p.routes[b]
+		if (len(ra.hosts) > 0) != (len(rb.hosts) > 0) {
+			return len(ra.hosts) > 0
+		}
+		return len(ra.pathPrefix) > len(rb.pathPrefix)
+	})
+	return p, nil
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt := p.match(r)
+	if rt == nil {
+		p.logger.Printf("proxy: no upstream for host %s path %s", r.Host, r.URL.Path)
+		http.Error(w, "No upstream", http.StatusBadGateway)
+		return
+	}
+	rt.rp.ServeHTTP(w, r)
+}
+
+// Close stops the health checkers.
+func (p *Proxy) Close() error {
+	p.once.Do(func() {
+		close(p.done)
+		p.wg.Wait()
+	})
+	return nil
+}
+
+func (p *Proxy) match(r *http.Request) *route {
+	host := strings.ToLower(r.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, rt := range p.routes {
+		if len(rt.hosts) > 0 && !hostMatches(rt.hosts, host) {
+			continue
+		}
+		if pathMatches(r.URL.Path, rt.pathPrefix) {
+			return rt
+		}
+	}
+	return nil
+}
+
+// pathMatches reports whether path is prefix or lies below it, so that
+// /api matches /api and /api/users but not /apiary.
+func pathMatches(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+func hostMatches(patterns []string, host string) bool {
+	for _, p := range patterns {
+		if p == host {
+			return true
+		}
+		if strings.HasPrefix(p, "*.") && strings.HasSuffix(host, p[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Proxy) rewriteFunc(rt *route, preserveHost bool) func(*httputil.ProxyRequest) {
+	return func(pr *httputil.ProxyRequest) {
+		if rt.stripPrefix && rt.pathPrefix != "/" {
+			pr.Out.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(pr.Out.URL.Path, strings.TrimSuffix(rt.pathPrefix, "/")), "/")
+			pr.Out.URL.RawPath = ""
+		}
+		pr.SetURL(rt.pool.next().url)
+		if preserveHost {
+			pr.Out.Host = pr.In.Host
+		}
+		// Forwarding headers are only passed on when they came from a
+		// trusted proxy; otherwise the chain starts with this hop.
+		forwarded := forwardedValue(pr.In)
+		if info, ok := clientip.FromContext(pr.In.Context()); ok && info.TrustedPeer() {
+			pr.Out.Header["X-Forwarded-For"] = pr.In.Header["X-Forwarded-For"]
+			if prior := pr.In.Header.Values("Forwarded"); len(prior) > 0 {
+				forwarded = strings.Join(append(prior, forwarded), ", ")
+			}
+		}
+		pr.SetXForwarded()
+		pr.Out.Header.Set("Forwarded", forwarded)
+	}
+}
+
+// forwardedValue renders an RFC 7239 Forwarded element for the inbound
+// request.
+func forwardedValue(r *http.Request) string {
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	parts := []string{}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if strings.Contains(host, ":") {
+			parts = append(parts, fmt.Sprintf("for=\"[%s]\"", host))
+		} else {
+			parts = append(parts, "for="+host)
+		}
+	}
+	if r.Host != "" {
+		parts = append(parts, fmt.Sprintf("host=%q", r.Host))
+	}
+	parts = append(parts, "proto="+proto)
+	return strings.Join(parts, ";")
+}
+
+// retryTransport retries failed round trips against other targets in the
+// pool when the request can safely be replayed.
+type retryTransport struct {
+	base         http.RoundTripper
+	pool         *pool
+	retries      int
+	preserveHost bool
+	logger       *log.Logger
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	current := t.pool.lookup(req.URL.Host)
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if err == nil {
+			return resp, nil
+		}
+		if req.Context().Err() != nil {
+			return nil, err
+		}
+		// Without active checks nothing would mark the target healthy
+		// again, so only demote it when a health checker is running.
+		if current != nil && t.pool.checked {
+			current.healthy.Store(false)
+		}
+		if attempt >= t.retries || !replayable(req, err) {
+			return nil, err
+		}
+		next := t.pool.next()
+		t.logger.Printf("proxy: retrying %s %s on %s after error: %v", req.Method, req.URL.Path, next.url.Host, err)
+		req = req.Clone(req.Context())
+		req.URL.Scheme = next.url.Scheme
+		req.URL.Host = next.url.Host
+		if !t.preserveHost {
+			req.Host = next.url.Host
+		}
+		current = next
+	}
+}
+
+func replayable(req *http.Request, err error) bool {
+	if req.Body != nil && req.Body != http.NoBody {
+		return false
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func (p *Proxy) healthLoop(name string, pl *pool, hc config.HealthCheckConfig) {
+	defer p.wg.Done()
+	interval := hc.Interval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	timeout := hc.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	client := &http.Client{Timeout: timeout}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, t := range pl.targets {
+			healthy := probe(client, t.url, hc.Path)
+			if was := t.healthy.Swap(healthy); was != healthy {
+				p.logger.Printf("proxy: upstream %s target %s healthy=%v", name, t.url.Host, healthy)
+			}
+		}
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func probe(client *http.Client, base *url.URL, path string) bool {
+	u := *base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(path, "/")
+	u.RawQuery = ""
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode < 500
+}
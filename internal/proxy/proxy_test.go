@@ -0,0 +1,215 @@
+package proxy
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"janus/internal/config"
+)
+
+// upstream is a backend that answers with its name and records the last
+// request it saw.
+type upstream struct {
+	*httptest.Server
+	last *http.Request
+}
+
+func newUpstream(t *testing.T, name string) *upstream {
+	u := &upstream{}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.last = r
+		io.WriteString(w, name)
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+func newProxy(t *testing.T, cfg config.ProxyConfig) *Proxy {
+	t.Helper()
+	p, err := New(cfg, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+// get sends GET target with the given Host through p.
+func get(p *Proxy, host, target string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	r.Host = host
+	r.RemoteAddr = "192.0.2.1:40000"
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, r)
+	return w
+}
+
+func TestNewRejectsBadConfig(t *testing.T) {
+	for name, cfg := range map[string]config.ProxyConfig{
+		"no upstreams": {},
+		"no urls":      {Upstreams: []config.UpstreamConfig{{Name: "app"}}},
+		"bad url":      {Upstreams: []config.UpstreamConfig{{Name: "app", URLs: []string{"localhost:8080"}}}},
+	} {
+		if _, err := New(cfg, nil); err == nil {
+			t.Errorf("%s: New succeeded", name)
+		}
+	}
+}
+
+func TestRouting(t *testing.T) {
+	web, api, admin := newUpstream(t, "web"), newUpstream(t, "api"), newUpstream(t, "admin")
+	p := newProxy(t, config.ProxyConfig{Upstreams: []config.UpstreamConfig{
+		{Name: "web", URLs: []string{web.URL}},
+		{Name: "api", URLs: []string{api.URL}, PathPrefix: "/api/", StripPrefix: true},
+		{Name: "admin", URLs: []string{admin.URL}, Hosts: []string{"*.admin.example"}},
+	}})
+
+	tests := []struct {
+		host, target, want, path string
+	}{
+		{"example.com", "/", "web", "/"},
+		{"example.com", "/api/users", "api", "/users"},
+		{"eu.admin.example:443", "/api/users", "admin", "/api/users"},
+		{"admin.example", "/x", "web", "/x"},
+	}
+	for _, tt := range tests {
+		w := get(p, tt.host, tt.target)
+		if w.Body.String() != tt.want {
+			t.Errorf("%s%s went to %q, want %q", tt.host, tt.target, w.Body, tt.want)
+			continue
+		}
+		last := map[string]*upstream{"web": web, "api": api, "admin": admin}[tt.want].last
+		if last.URL.Path != tt.path {
+			t.Errorf("%s%s arrived as %s, want %s", tt.host, tt.target, last.URL.Path, tt.path)
+		}
+	}
+}
+
+// TestRoutingSegmentBoundary checks a prefix only matches whole path
+// segments, so /api neither captures nor strips /apiary.
+func TestRoutingSegmentBoundary(t *testing.T) {
+	web, api := newUpstream(t, "web"), newUpstream(t, "api")
+	p := newProxy(t, config.ProxyConfig{Upstreams: []config.UpstreamConfig{
+		{Name: "web", URLs: []string{web.URL}},
+		{Name: "api", URLs: []string{api.URL}, PathPrefix: "/api", StripPrefix: true},
+	}})
+
+	tests := []struct {
+		target, want, path string
+	}{
+		{"/api", "api", "/"},
+		{"/api/", "api", "/"},
+		{"/api/users", "api", "/users"},
+		{"/apiary", "web", "/apiary"},
+		{"/apiary/hives", "web", "/apiary/hives"},
+	}
+	for _, tt := range tests {
+		w := get(p, "example.com", tt.target)
+		if w.Body.String() != tt.want {
+			t.Errorf("%s went to %q, want %q", tt.target, w.Body, tt.want)
+			continue
+		}
+		last := map[string]*upstream{"web": web, "api": api}[tt.want].last
+		if last.URL.Path != tt.path {
+			t.Errorf("%s arrived as %s, want %s", tt.target, last.URL.Path, tt.path)
+		}
+	}
+}
+
+func TestNoUpstream(t *testing.T) {
+	app := newUpstream(t, "app")
+	p := newProxy(t, config.ProxyConfig{Upstreams: []config.UpstreamConfig{
+		{URLs: []string{app.URL}, Hosts: []string{"app.example"}},
+	}})
+	if w := get(p, "other.example", "/"); w.Code != http.StatusBadGateway {
+		t.Errorf("unmatched host: %d, want 502", w.Code)
+	}
+}
+
+func TestForwardingHeaders(t *testing.T) {
+	app := newUpstream(t, "app")
+	p := newProxy(t, config.ProxyConfig{Upstreams: []config.UpstreamConfig{{URLs: []string{app.URL}}}})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Host = "example.com"
+	r.RemoteAddr = "192.0.2.1:40000"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9")
+	r.Header.Set("Forwarded", "for=203.0.113.9")
+	p.ServeHTTP(httptest.NewRecorder(), r)
+
+	// The peer is not a trusted proxy, so its forwarding headers are
+	// dropped.
+	if got := app.last.Header.Get("X-Forwarded-For"); got != "192.0.2.1" {
+		t.Errorf("X-Forwarded-For = %q, want 192.0.2.1", got)
+	}
+	if got, want := app.last.Header.Get("Forwarded"), `for=192.0.2.1;host="example.com";proto=http`; got != want {
+		t.Errorf("Forwarded = %q, want %q", got, want)
+	}
+	if got := app.last.Header.Get("X-Forwarded-Host"); got != "example.com" {
+		t.Errorf("X-Forwarded-Host = %q, want example.com", got)
+	}
+}
+
+func TestRetryOnDeadTarget(t *testing.T) {
+	app := newUpstream(t, "app")
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	p := newProxy(t, config.ProxyConfig{Retries: 1, Upstreams: []config.UpstreamConfig{
+		{URLs: []string{dead.URL, app.URL}},
+	}})
+	for i := 0; i < 4; i++ {
+		if w := get(p, "example.com", "/"); w.Body.String() != "app" {
+			t.Fatalf("request %d: %d %q, want it retried on the live target", i, w.Code, w.Body)
+		}
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	app := newUpstream(t, "app")
+	sick := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer sick.Close()
+	p := newProxy(t, config.ProxyConfig{Upstreams: []config.UpstreamConfig{{
+		URLs:        []string{sick.URL, app.URL},
+		HealthCheck: config.HealthCheckConfig{Path: "/healthz", Interval: 10 * time.Millisecond},
+	}}})
+
+	pl := p.routes[0].pool
+	deadline := time.Now().Add(2 * time.Second)
+	for pl.targets[0].healthy.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("failing target never marked unhealthy")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	for i := 0; i < 4; i++ {
+		if w := get(p, "example.com", "/"); w.Body.String() != "app" {
+			t.Fatalf("request %d: %q, want only the healthy target", i, w.Body)
+		}
+	}
+}
+
+func TestPoolFallsBackWhenAllUnhealthy(t *testing.T) {
+	pl := &pool{targets: []*target{{}, {}}}
+	seen := map[*target]bool{}
+	for i := 0; i < 4; i++ {
+		seen[pl.next()] = true
+	}
+	if len(seen) != 2 {
+		t.Errorf("next used %d of 2 unhealthy targets", len(seen))
+	}
+}
+
+func TestForwardedValueIPv6(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "[2001:db8::1]:40000"
+	r.Host = ""
+	if got, want := forwardedValue(r), `for="[2001:db8::1]";proto=http`; got != want {
+		t.Errorf("forwardedValue = %q, want %q", got, want)
+	}
+}
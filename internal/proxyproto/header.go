@@ -0,0 +1,231 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// v2Signature starts every PROXY protocol v2 header.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// maxV1Length is the longest legal v1 header including CRLF.
+const maxV1Length = 107
+
+// TLV types defined by the PROXY protocol specification, plus the AWS
+// extension carrying the VPC endpoint ID.
+const (
+	TLVALPN      = 0x01
+	TLVAuthority = 0x02
+	TLVCRC32C    = 0x03
+	TLVNoop      = 0x04
+	TLVUniqueID  = 0x05
+	TLVSSL       = 0x20
+	TLVNetNS     = 0x30
+	TLVAWS       = 0xEA
+)
+
+var (
+	ErrNoHeader = errors.New("proxyproto: no PROXY protocol header")
+	ErrInvalid  = errors.New("proxyproto: malformed header")
+)
+
+type TLV struct {
+	Type  byte
+	Value []byte
+}
+
+// Header is a parsed PROXY protocol header. Source and Destination are nil
+// for LOCAL (v2) and UNKNOWN (v1) headers, which carry no addresses.
+type Header struct {
+	Version     int
+	Local       bool
+	Source      net.Addr
+	Destination net.Addr
+	TLVs        []TLV
+}
+
+// TLV returns the value of the first TLV of type t.
+func (h *Header) TLV(t byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == t {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Authority returns the SNI/host name the load balancer saw, if sent.
+func (h *Header) Authority() string {
+	v, _ := h.TLV(TLVAuthority)
+	return string(v)
+}
+
+// ReadHeader reads a v1 or v2 header from r.
+func ReadHeader(r *bufio.Reader) (*Header, error) {
+	peek, err := r.Peek(len(v2Signature))
+	if err != nil && len(peek) == 0 {
+		return nil, err
+	}
+	switch {
+	case bytes.Equal(peek, v2Signature):
+		return readV2(r)
+	case bytes.HasPrefix(peek, []byte("PROXY ")):
+		return readV1(r)
+	default:
+		return nil, ErrNoHeader
+	}
+}
+
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for len(line) < maxV1Length {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("%w: v1 header not terminated", ErrInvalid)
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	h := &Header{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		h.Local = true
+		return h, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("%w: v1 header %q", ErrInvalid, line)
+	}
+	src, err := v1Addr(fields[2], fields[4], fields[1] == "TCP6")
+	if err != nil {
+		return nil, err
+	}
+	dst, err := v1Addr(fields[3], fields[5], fields[1] == "TCP6")
+	if err != nil {
+		return nil, err
+	}
+	h.Source, h.Destination = src, dst
+	return h, nil
+}
+
+func v1Addr(ip, port string, v6 bool) (*net.TCPAddr, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil || (addr.To4() == nil) != v6 {
+		return nil, fmt.Errorf("%w: v1 address %q", ErrInvalid, ip)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: v1 port %q", ErrInvalid, port)
+	}
+	return &net.TCPAddr{IP: addr, Port: int(p)}, nil
+}
+
+func readV2(r *bufio.Reader) (*Header, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, fmt.Errorf("%w: v2 version %d", ErrInvalid, fixed[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	h := &Header{Version: 2}
+	switch fixed[12] & 0x0f {
+	case 0x0:
+		h.Local = true
+	case 0x1:
+	default:
+		return nil, fmt.Errorf("%w: v2 command %d", ErrInvalid, fixed[12]&0x0f)
+	}
+
+	var addrLen int
+	switch fixed[13] >> 4 {
+	case 0x0: // AF_UNSPEC
+	case 0x1: // AF_INET
+		addrLen = 12
+	case 0x2: // AF_INET6
+		addrLen = 36
+	case 0x3: // AF_UNIX
+		addrLen = 216
+	default:
+		return nil, fmt.Errorf("%w: v2 family %d", ErrInvalid, fixed[13]>>4)
+	}
+	if len(payload) < addrLen {
+		return nil, fmt.Errorf("%w: v2 address block truncated", ErrInvalid)
+	}
+	// Only TCP over IPv4/IPv6 yields usable peer addresses; UNIX and UDP
+	// headers are accepted but leave the connection's own address in place.
+	if !h.Local && fixed[13]&0x0f == 0x1 {
+		switch addrLen {
+		case 12:
+			h.Source = &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}
+			h.Destination = &net.TCPAddr{IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:12]))}
+		case 36:
+			h.Source = &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}
+			h.Destination = &net.TCPAddr{IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:36]))}
+		}
+	}
+
+	tlvs, err := parseTLVs(payload[addrLen:])
+	if err != nil {
+		return nil, err
+	}
+	h.TLVs = tlvs
+	if sum, ok := h.TLV(TLVCRC32C); ok {
+		if err := checkCRC(fixed, payload, sum); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+func parseTLVs(b []byte) ([]TLV, error) {
+	var out []TLV
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return nil, fmt.Errorf("%w: truncated TLV", ErrInvalid)
+		}
+		n := int(binary.BigEndian.Uint16(b[1:3]))
+		if len(b) < 3+n {
+			return nil, fmt.Errorf("%w: TLV 0x%02x overruns header", ErrInvalid, b[0])
+		}
+		if b[0] != TLVNoop {
+			out = append(out, TLV{Type: b[0], Value: b[3 : 3+n]})
+		}
+		b = b[3+n:]
+	}
+	return out, nil
+}
+
+// checkCRC verifies the CRC32C TLV, computed over the whole header with the
+// checksum field zeroed.
+func checkCRC(fixed, payload, sum []byte) error {
+	if len(sum) != 4 {
+		return fmt.Errorf("%w: CRC32C TLV length %d", ErrInvalid, len(sum))
+	}
+	want := binary.BigEndian.Uint32(sum)
+	copy(sum, []byte{0, 0, 0, 0})
+	table := crc32.MakeTable(crc32.Castagnoli)
+	got := crc32.Update(crc32.Checksum(fixed, table), table, payload)
+	binary.BigEndian.PutUint32(sum, want)
+	if got != want {
+		return fmt.Errorf("%w: CRC32C mismatch", ErrInvalid)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func read(t *testing.T, data []byte) (*Header, string, error) {
+	t.Helper()
+	r := bufio.NewReader(bytes.NewReader(data))
+	h, err := ReadHeader(r)
+	rest, _ := io.ReadAll(r)
+	return h, string(rest), err
+}
+
+func TestReadV1(t *testing.T) {
+	h, rest, err := read(t, []byte("PROXY TCP4 203.0.113.5 192.0.2.10 51234 443\r\nGET /"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Version != 1 || h.Source.String() != "203.0.113.5:51234" || h.Destination.String() != "192.0.2.10:443" {
+		t.Errorf("header = %+v", h)
+	}
+	if rest != "GET /" {
+		t.Errorf("left %q after the header, want the payload", rest)
+	}
+
+	h, _, err = read(t, []byte("PROXY TCP6 2001:db8::5 2001:db8::1 51234 443\r\n"))
+	if err != nil || h.Source.String() != "[2001:db8::5]:51234" {
+		t.Errorf("TCP6 header = %+v, %v", h, err)
+	}
+	h, _, err = read(t, []byte("PROXY UNKNOWN\r\n"))
+	if err != nil || !h.Local || h.Source != nil {
+		t.Errorf("UNKNOWN header = %+v, %v", h, err)
+	}
+}
+
+func TestReadV1Invalid(t *testing.T) {
+	for _, line := range []string{
+		"PROXY TCP4 203.0.113.5 192.0.2.10 51234\r\n",
+		"PROXY TCP4 2001:db8::5 192.0.2.10 51234 443\r\n",
+		"PROXY TCP4 203.0.113.5 192.0.2.10 51234 99999\r\n",
+		"PROXY UDP4 203.0.113.5 192.0.2.10 51234 443\r\n",
+		"PROXY TCP4 203.0.113.5 192.0.2.10 51234 443\n",
+		"PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n",
+	} {
+		if _, _, err := read(t, []byte(line)); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%q: error = %v, want ErrInvalid", line, err)
+		}
+	}
+	if _, _, err := read(t, []byte("GET / HTTP/1.1\r\n\r\n")); err != ErrNoHeader {
+		t.Errorf("no header: error = %v, want ErrNoHeader", err)
+	}
+}
+
+// v2 builds a v2 header with the given command, family/protocol, address
+// block and TLVs, adding a valid CRC32C TLV when crc is set.
+func v2(cmd, fam byte, addrs []byte, tlvs []TLV, crc bool) []byte {
+	var payload bytes.Buffer
+	payload.Write(addrs)
+	for _, tlv := range tlvs {
+		payload.WriteByte(tlv.Type)
+		binary.Write(&payload, binary.BigEndian, uint16(len(tlv.Value)))
+		payload.Write(tlv.Value)
+	}
+	crcAt := -1
+	if crc {
+		payload.Write([]byte{TLVCRC32C, 0, 4})
+		crcAt = payload.Len()
+		payload.Write([]byte{0, 0, 0, 0})
+	}
+	var out bytes.Buffer
+	out.Write(v2Signature)
+	out.WriteByte(0x20 | cmd)
+	out.WriteByte(fam)
+	binary.Write(&out, binary.BigEndian, uint16(payload.Len()))
+	out.Write(payload.Bytes())
+	b := out.Bytes()
+	if crc {
+		sum := crc32.Checksum(b, crc32.MakeTable(crc32.Castagnoli))
+		binary.BigEndian.PutUint32(b[16+crcAt:], sum)
+	}
+	return b
+}
+
+func ipv4Block() []byte {
+	b := append(net.ParseIP("203.0.113.5").To4(), net.ParseIP("192.0.2.10").To4()...)
+	return binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(b, 51234), 443)
+}
+
+func TestReadV2(t *testing.T) {
+	data := append(v2(0x1, 0x11, ipv4Block(), []TLV{{Type: TLVAuthority, Value: []byte("example.com")}, {Type: TLVNoop, Value: []byte{0}}}, true), "payload"...)
+	h, rest, err := read(t, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Version != 2 || h.Local || h.Source.String() != "203.0.113.5:51234" || h.Destination.String() != "192.0.2.10:443" {
+		t.Errorf("header = %+v", h)
+	}
+	if h.Authority() != "example.com" {
+		t.Errorf("Authority = %q", h.Authority())
+	}
+	if _, ok := h.TLV(TLVNoop); ok {
+		t.Error("NOOP TLV kept")
+	}
+	if rest != "payload" {
+		t.Errorf("left %q after the header, want the payload", rest)
+	}
+
+	addrs := append(append(net.ParseIP("2001:db8::5").To16(), net.ParseIP("2001:db8::1").To16()...), 0xc8, 0x22, 0x01, 0xbb)
+	h, _, err = read(t, v2(0x1, 0x21, addrs, nil, false))
+	if err != nil || h.Source.String() != "[2001:db8::5]:51234" {
+		t.Errorf("IPv6 header = %+v, %v", h, err)
+	}
+
+	h, _, err = read(t, v2(0x0, 0x00, nil, nil, false))
+	if err != nil || !h.Local || h.Source != nil {
+		t.Errorf("LOCAL header = %+v, %v", h, err)
+	}
+}
+
+func TestReadV2Invalid(t *testing.T) {
+	corrupt := v2(0x1, 0x11, ipv4Block(), nil, true)
+	corrupt[16] ^= 0xff
+	tests := map[string][]byte{
+		"bad CRC":         corrupt,
+		"bad command":     v2(0x2, 0x11, ipv4Block(), nil, false),
+		"bad family":      v2(0x1, 0x41, ipv4Block(), nil, false),
+		"short addresses": v2(0x1, 0x11, ipv4Block()[:8], nil, false),
+		"truncated TLV":   v2(0x1, 0x11, append(ipv4Block(), TLVAuthority, 0, 9, 'x'), nil, false),
+	}
+	for name, data := range tests {
+		if _, _, err := read(t, data); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: error = %v, want ErrInvalid", name, err)
+		}
+	}
+}
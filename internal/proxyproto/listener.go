@@ -0,0 +1,174 @@
+package proxyproto
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"janus/internal/config"
+)
+
+type contextKey struct{}
+
+// Conn strips the PROXY protocol header from a connection and reports the
+// source address it carried. The header is read lazily on first use so a
+// slow peer cannot stall Accept.
+type Conn struct {
+	net.Conn
+
+	trusted bool
+	timeout time.Duration
+	reader  *bufio.Reader
+
+	once   sync.Once
+	header *Header
+	err    error
+}
+
+func (c *Conn) readHeader() {
+	if !c.trusted {
+		return
+	}
+	if c.timeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+	}
+	c.header, c.err = ReadHeader(c.reader)
+	if c.err != nil {
+		log.Printf("readHeader: Rejecting connection from %s: %v", c.Conn.RemoteAddr(), c.err)
+	}
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.trusted {
+		return c.reader.Read(b)
+	}
+	return c.Conn.Read(b)
+}
+
+// RemoteAddr returns the client address from the PROXY header, falling back
+// to the peer's own address.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.header != nil && c.header.Source != nil {
+		return c.header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.header != nil && c.header.Destination != nil {
+		return c.header.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// Header returns the parsed PROXY header, or nil for direct connections.
+func (c *Conn) Header() *Header {
+	c.once.Do(c.readHeader)
+	return c.header
+}
+
+// NetConn returns the underlying connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.Conn
+}
+
+type listener struct {
+	net.Listener
+	trusted []netip.Prefix
+	timeout time.Duration
+}
+
+// NewListener wraps inner so connections from cfg.TrustedCIDRs must start
+// with a PROXY protocol v1 or v2 header. Connections from anywhere else are
+// passed through untouched, so clients cannot forge their address. Wrap it
+// with tlsfp.NewListener so the ClientHello is read after the header.
+func NewListener(inner net.Listener, cfg config.ProxyProtocolConfig) (net.Listener, error) {
+	l := &listener{Listener: inner, timeout: cfg.HeaderTimeout}
+	for _, e := range cfg.TrustedCIDRs {
+		if p, err := netip.ParsePrefix(e); err == nil {
+			l.trusted = append(l.trusted, p.Masked())
+		} else if a, err := netip.ParseAddr(e); err == nil {
+			l.trusted = append(l.trusted, netip.PrefixFrom(a, a.BitLen()))
+		} else {
+			return nil, fmt.Errorf("proxyproto: invalid trusted CIDR %q", e)
+		}
+	}
+	if l.timeout <= 0 {
+		l.timeout = 5 * time.Second
+	}
+	return l, nil
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	conn := &Conn{Conn: c, timeout: l.timeout}
+	if l.isTrusted(c.RemoteAddr()) {
+		conn.trusted = true
+		conn.reader = bufio.NewReader(c)
+	}
+	return conn, nil
+}
+
+func (l *listener) isTrusted(addr net.Addr) bool {
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return false
+	}
+	a := ap.Addr().Unmap()
+	for _, p := range l.trusted {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// ConnContext is an http.Server.ConnContext hook that makes the PROXY
+// header reachable from request contexts via FromContext.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if conn := Unwrap(c); conn != nil {
+		return context.WithValue(ctx, contextKey{}, conn)
+	}
+	return ctx
+}
+
+// Unwrap finds the Conn beneath c through any wrapper exposing NetConn.
+func Unwrap(c net.Conn) *Conn {
+	for c != nil {
+		switch v := c.(type) {
+		case *Conn:
+			return v
+		case interface{ NetConn() net.Conn }:
+			c = v.NetConn()
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// FromContext returns the PROXY header of the connection that carried the
+// request, if there was one.
+func FromContext(ctx context.Context) (*Header, bool) {
+	conn, ok := ctx.Value(contextKey{}).(*Conn)
+	if !ok {
+		return nil, false
+	}
+	h := conn.Header()
+	return h, h != nil
+}
@@ -0,0 +1,98 @@
+package proxyproto
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"janus/internal/config"
+)
+
+// accept dials l, writes data and returns the server side of the
+// connection.
+func accept(t *testing.T, l net.Listener, data string) net.Conn {
+	t.Helper()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	if _, err := io.WriteString(client, data); err != nil {
+		t.Fatal(err)
+	}
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func listen(t *testing.T, trusted ...string) net.Listener {
+	t.Helper()
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewListener(inner, config.ProxyProtocolConfig{TrustedCIDRs: trusted, HeaderTimeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestListenerTrustedPeer(t *testing.T) {
+	l := listen(t, "127.0.0.0/8")
+	c := accept(t, l, "PROXY TCP4 203.0.113.5 192.0.2.10 51234 443\r\nhello\n")
+	if got := c.RemoteAddr().String(); got != "203.0.113.5:51234" {
+		t.Errorf("RemoteAddr = %s, want the address from the header", got)
+	}
+	if got := c.LocalAddr().String(); got != "192.0.2.10:443" {
+		t.Errorf("LocalAddr = %s, want the address from the header", got)
+	}
+	line, err := bufio.NewReader(c).ReadString('\n')
+	if err != nil || line != "hello\n" {
+		t.Errorf("read %q, %v after the header", line, err)
+	}
+	ctx := ConnContext(t.Context(), c)
+	if h, ok := FromContext(ctx); !ok || h.Version != 1 {
+		t.Errorf("FromContext = %+v, %v", h, ok)
+	}
+}
+
+func TestListenerTrustedPeerWithoutHeader(t *testing.T) {
+	l := listen(t, "127.0.0.1")
+	c := accept(t, l, "GET / HTTP/1.1\r\n\r\n")
+	if _, err := c.Read(make([]byte, 16)); err != ErrNoHeader {
+		t.Errorf("Read error = %v, want ErrNoHeader", err)
+	}
+}
+
+func TestListenerUntrustedPeer(t *testing.T) {
+	l := listen(t, "10.0.0.0/8")
+	c := accept(t, l, "PROXY TCP4 203.0.113.5 192.0.2.10 51234 443\r\n")
+	if host, _, _ := net.SplitHostPort(c.RemoteAddr().String()); host != "127.0.0.1" {
+		t.Errorf("untrusted peer's header believed: RemoteAddr = %s", c.RemoteAddr())
+	}
+	line, err := bufio.NewReader(c).ReadString('\n')
+	if err != nil || line != "PROXY TCP4 203.0.113.5 192.0.2.10 51234 443\r\n" {
+		t.Errorf("read %q, %v; want the header passed through", line, err)
+	}
+	if _, ok := FromContext(ConnContext(t.Context(), c)); ok {
+		t.Error("FromContext reported a header for an untrusted peer")
+	}
+}
+
+func TestNewListenerRejectsBadCIDR(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inner.Close()
+	if _, err := NewListener(inner, config.ProxyProtocolConfig{TrustedCIDRs: []string{"nope"}}); err == nil {
+		t.Error("NewListener accepted an invalid CIDR")
+	}
+}
@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"janus/internal/store"
+)
+
+// Tightest returns the status that limits the request most: a refused one,
+// else the one with the least remaining quota. No statuses allow.
+func Tightest(statuses []Status) Status {
+	if len(statuses) == 0 {
+		return Status{RateResult: store.RateResult{Allowed: true}}
+	}
+	tightest := statuses[0]
+	for _, s := range statuses[1:] {
+		if (!s.Allowed && tightest.Allowed) || (s.Allowed == tightest.Allowed && s.Remaining < tightest.Remaining) {
+			tightest = s
+		}
+	}
+	return tightest
+}
+
+// SetHeaders sets the IETF RateLimit-Limit, -Remaining and -Reset headers
+// for the tightest of statuses and lists every limit in
+// RateLimit-Policy. Limit is the burst capacity, so Remaining never exceeds
+// it, and Reset is the seconds until the bucket is full again.
+func SetHeaders(h http.Header, statuses []Status) {
+	if len(statuses) == 0 {
+		return
+	}
+	s := Tightest(statuses)
+	h.Set("RateLimit-Limit", strconv.Itoa(s.Rate.Burst+1))
+	h.Set("RateLimit-Remaining", strconv.Itoa(s.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(seconds(s.ResetAfter)))
+	policies := make([]string, len(statuses))
+	for i, st := range statuses {
+		policies[i] = fmt.Sprintf("%d;w=%d;burst=%d;comment=%q", st.Rate.Limit, seconds(st.Rate.Period), st.Rate.Burst, st.Name)
+	}
+	h.Set("RateLimit-Policy", strings.Join(policies, ", "))
+}
+
+// Reject answers a request refused by s with 429 and Retry-After, in JSON
+// when the client accepts it.
+func Reject(w http.ResponseWriter, r *http.Request, s Status) {
+	retry := seconds(s.RetryAfter)
+	if retry < 1 {
+		retry = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retry))
+	w.Header().Set("Cache-Control", "no-store")
+	if !strings.Contains(r.Header.Get("Accept"), "json") {
+		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":       "rate_limited",
+		"policy":      s.Name,
+		"limit":       s.Rate.Burst + 1,
+		"remaining":   s.Remaining,
+		"reset":       seconds(s.ResetAfter),
+		"retry_after": retry,
+	})
+}
+
+// seconds rounds d up to whole seconds.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
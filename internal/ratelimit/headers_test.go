@@ -0,0 +1,106 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"janus/internal/store"
+)
+
+var (
+	perMinute = store.Rate{Limit: 60, Period: time.Minute, Burst: 9}
+	perHour   = store.Rate{Limit: 100, Period: time.Hour}
+)
+
+func TestTightest(t *testing.T) {
+	loose := Status{Name: "global/ip", Rate: perMinute, RateResult: store.RateResult{Allowed: true, Remaining: 8}}
+	tight := Status{Name: "global/asn", Rate: perMinute, RateResult: store.RateResult{Allowed: true, Remaining: 2}}
+	refused := Status{Name: "route:login/ip", Rate: perHour, RateResult: store.RateResult{Remaining: 0}}
+
+	if got := Tightest(nil); !got.Allowed {
+		t.Errorf("Tightest(nil) = %+v, want allowed", got)
+	}
+	if got := Tightest([]Status{loose, tight}); got.Name != tight.Name {
+		t.Errorf("Tightest = %s, want the least remaining", got.Name)
+	}
+	if got := Tightest([]Status{tight, refused, loose}); got.Name != refused.Name {
+		t.Errorf("Tightest = %s, want the refused limit", got.Name)
+	}
+}
+
+func TestSetHeaders(t *testing.T) {
+	h := http.Header{}
+	SetHeaders(h, []Status{
+		{Name: "global/ip", Rate: perMinute, RateResult: store.RateResult{Allowed: true, Remaining: 3, ResetAfter: 6500 * time.Millisecond}},
+		{Name: "global/ja4", Rate: perHour, RateResult: store.RateResult{Allowed: true, Remaining: 0, ResetAfter: 36 * time.Second}},
+	})
+	want := map[string]string{
+		"RateLimit-Limit":     "1",
+		"RateLimit-Remaining": "0",
+		"RateLimit-Reset":     "36",
+		"RateLimit-Policy":    `60;w=60;burst=9;comment="global/ip", 100;w=3600;burst=0;comment="global/ja4"`,
+	}
+	for k, v := range want {
+		if got := h.Get(k); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+
+	h = http.Header{}
+	SetHeaders(h, []Status{{Name: "global/ip", Rate: perMinute, RateResult: store.RateResult{Allowed: true, Remaining: 9, ResetAfter: time.Second}}})
+	if h.Get("RateLimit-Limit") != "10" || h.Get("RateLimit-Remaining") != "9" || h.Get("RateLimit-Reset") != "1" {
+		t.Errorf("headers %v, want limit 10 (burst + 1), remaining 9, reset 1", h)
+	}
+
+	h = http.Header{}
+	SetHeaders(h, nil)
+	if len(h) != 0 {
+		t.Errorf("headers without limits: %v", h)
+	}
+}
+
+func TestReject(t *testing.T) {
+	s := Status{Name: "route:login/ip", Rate: perMinute, RateResult: store.RateResult{RetryAfter: 1200 * time.Millisecond, ResetAfter: 10 * time.Second}}
+
+	r := httptest.NewRequest(http.MethodGet, "/login", nil)
+	r.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+	Reject(w, r, s)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "2" || w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("HTML reject: %d %v, want 429 with Retry-After 2", w.Code, w.Header())
+	}
+	if ct := w.Header().Get("Content-Type"); ct == "application/json" {
+		t.Errorf("HTML reject answered %s", ct)
+	}
+
+	r.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	Reject(w, r, s)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("JSON reject: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var body struct {
+		Error      string `json:"error"`
+		Policy     string `json:"policy"`
+		Limit      int    `json:"limit"`
+		Remaining  int    `json:"remaining"`
+		Reset      int    `json:"reset"`
+		RetryAfter int    `json:"retry_after"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error != "rate_limited" || body.Policy != "route:login/ip" || body.Limit != 10 || body.Remaining != 0 || body.Reset != 10 || body.RetryAfter != 2 {
+		t.Errorf("JSON body %+v", body)
+	}
+
+	// Retry-After is never 0, which clients would read as "now".
+	w = httptest.NewRecorder()
+	Reject(w, r, Status{Name: "global/ip", Rate: perMinute, RateResult: store.RateResult{RetryAfter: 0}})
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want 1", got)
+	}
+}
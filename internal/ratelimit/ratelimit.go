@@ -1,45 +1,212 @@
+// Package ratelimit limits requests with GCRA (a token bucket without a
+// refill timer) on any number of keys per request — client IP, subnet,
+// ASN, TLS fingerprint, token, header values. The store checks all of a
+// request's keys atomically in one round trip, so every replica sharing it
+// enforces the same limits.
 package ratelimit
 
 import (
-	"janus/internal/config"
-	"sync"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
 	"time"
+
+	"janus/internal/config"
+	"janus/internal/store"
 )
 
-type Store struct {
-	sync.Mutex
-	data map[string]struct {
-		Count     int
-		LastReset time.Time
+// Keys a limit can apply to. Header limits are written "header:<Name>".
+const (
+	KeyIP          = "ip"
+	KeySubnet      = "subnet"
+	KeyASN         = "asn"
+	KeyJA3         = "ja3"
+	KeyJA4         = "ja4"
+	KeyToken       = "token"
+	KeyFingerprint = "fingerprint"
+	KeyHeader      = "header"
+)
+
+// maxValue is the longest key value stored as is; longer header values are
+// hashed.
+const maxValue = 64
+
+// Request holds what a request can be limited on. Empty fields are not
+// limited.
+type Request struct {
+	IP          string
+	ASN         uint
+	JA3         string
+	JA4         string
+	Token       string
+	Fingerprint string
+	Header      http.Header
+}
+
+// dimension is one limit of a Limiter.
+type dimension struct {
+	// name is "<limiter>/<key>", as reported in Status and the store key.
+	name   string
+	kind   string
+	header string
+	v4, v6 int
+	rate   store.Rate
+}
+
+// Limiter is a named set of limits.
+type Limiter struct {
+	name string
+	dims []dimension
+}
+
+// New builds a limiter from cfg: RequestsPerMinute and Burst limit the
+// client IP, and each of cfg.Keys adds a limit.
+func New(name string, cfg config.RateLimitConfig) (*Limiter, error) {
+	l := &Limiter{name: name}
+	keys := cfg.Keys
+	if cfg.RequestsPerMinute > 0 {
+		keys = append([]config.RateLimitKeyConfig{{Key: KeyIP, RequestsPerMinute: cfg.RequestsPerMinute, Burst: cfg.Burst}}, keys...)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("ratelimit: %s: no limits configured", name)
+	}
+	for _, kc := range keys {
+		d, err := newDimension(name, kc)
+		if err != nil {
+			return nil, err
+		}
+		l.dims = append(l.dims, d)
+	}
+	return l, nil
+}
+
+func newDimension(limiter string, kc config.RateLimitKeyConfig) (dimension, error) {
+	d := dimension{name: limiter + "/" + kc.Key, kind: kc.Key}
+	if kc.RequestsPerMinute <= 0 {
+		return d, fmt.Errorf("ratelimit: %s: requests_per_minute must be positive", d.name)
 	}
-	cfg *config.JanusConfig
+	d.rate = store.Rate{Limit: kc.RequestsPerMinute, Period: time.Minute, Burst: max(kc.Burst, 0)}
+	switch kc.Key {
+	case KeyIP, KeyASN, KeyJA3, KeyJA4, KeyToken, KeyFingerprint:
+	case KeySubnet:
+		d.v4, d.v6 = kc.IPv4Prefix, kc.IPv6Prefix
+		if d.v4 == 0 {
+			d.v4 = 24
+		}
+		if d.v6 == 0 {
+			d.v6 = 64
+		}
+		if d.v4 < 0 || d.v4 > 32 || d.v6 < 0 || d.v6 > 128 {
+			return d, fmt.Errorf("ratelimit: %s: invalid prefix /%d, /%d", d.name, d.v4, d.v6)
+		}
+	default:
+		name, ok := strings.CutPrefix(kc.Key, KeyHeader+":")
+		if !ok || name == "" {
+			return d, fmt.Errorf("ratelimit: %s: unknown key %q", d.name, kc.Key)
+		}
+		d.kind, d.header = KeyHeader, http.CanonicalHeaderKey(name)
+	}
+	return d, nil
 }
 
-func NewStore(cfg *config.JanusConfig) *Store {
-	return &Store{
-		data: make(map[string]struct {
-			Count     int
-			LastReset time.Time
-		}),
-		cfg: cfg,
+// Name returns the limiter's name.
+func (l *Limiter) Name() string { return l.name }
+
+// Uses reports whether any of l's limits applies to key.
+func (l *Limiter) Uses(key string) bool {
+	for _, d := range l.dims {
+		if d.kind == key {
+			return true
+		}
 	}
+	return false
 }
 
-func (s *Store) Allow(ip string) bool {
-	s.Lock()
-	defer s.Unlock()
-	entry, exists := s.data[ip]
-	if !exists || time.Since(entry.LastReset) > time.Minute {
-		s.data[ip] = struct {
-			Count     int
-			LastReset time.Time
-		}{Count: 1, LastReset: time.Now()}
-		return true
+// value returns what d limits in req, or "" when req has nothing to limit.
+func (d *dimension) value(req *Request) string {
+	switch d.kind {
+	case KeyIP:
+		return req.IP
+	case KeySubnet:
+		addr, err := netip.ParseAddr(req.IP)
+		if err != nil {
+			return ""
+		}
+		addr = addr.Unmap()
+		bits := d.v6
+		if addr.Is4() {
+			bits = d.v4
+		}
+		p, err := addr.Prefix(bits)
+		if err != nil {
+			return ""
+		}
+		return p.String()
+	case KeyASN:
+		if req.ASN == 0 {
+			return ""
+		}
+		return strconv.FormatUint(uint64(req.ASN), 10)
+	case KeyJA3:
+		return req.JA3
+	case KeyJA4:
+		return req.JA4
+	case KeyToken:
+		return req.Token
+	case KeyFingerprint:
+		return req.Fingerprint
+	case KeyHeader:
+		v := req.Header.Get(d.header)
+		if len(v) > maxValue {
+			sum := sha256.Sum256([]byte(v))
+			v = hex.EncodeToString(sum[:16])
+		}
+		return v
+	}
+	return ""
+}
+
+// Status is one limit's verdict on a request.
+type Status struct {
+	// Name is "<limiter>/<key>", such as "global/subnet".
+	Name string
+	Rate store.Rate
+	store.RateResult
+}
+
+// Check counts req against every limit of limiters that applies to it, in
+// one store round trip. If any limit refuses the request it counts against
+// none.
+func Check(ctx context.Context, rates store.RateLimits, req *Request, limiters ...*Limiter) ([]Status, error) {
+	var (
+		keys     []store.RateKey
+		statuses []Status
+	)
+	for _, l := range limiters {
+		for i := range l.dims {
+			d := &l.dims[i]
+			v := d.value(req)
+			if v == "" {
+				continue
+			}
+			keys = append(keys, store.RateKey{Key: d.name + ":" + v, Rate: d.rate})
+			statuses = append(statuses, Status{Name: d.name, Rate: d.rate})
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	results, err := rates.AllowRates(ctx, keys)
+	if err != nil {
+		return nil, err
 	}
-	if entry.Count >= 100 {
-		return false
+	for i := range statuses {
+		statuses[i].RateResult = results[i]
 	}
-	entry.Count++
-	s.data[ip] = entry
-	return true
+	return statuses, nil
 }
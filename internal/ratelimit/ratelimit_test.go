@@ -0,0 +1,213 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"janus/internal/config"
+	"janus/internal/store"
+)
+
+func newLimiter(t *testing.T, name string, cfg config.RateLimitConfig) *Limiter {
+	t.Helper()
+	l, err := New(name, cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return l
+}
+
+// check runs Check on a single-status request and returns its status.
+func check(t *testing.T, s store.RateLimits, req *Request, limiters ...*Limiter) Status {
+	t.Helper()
+	statuses, err := Check(context.Background(), s, req, limiters...)
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if len(statuses) != 1 {
+		t.Fatalf("Check = %+v, want one status", statuses)
+	}
+	return statuses[0]
+}
+
+func TestBurst(t *testing.T) {
+	s := store.NewMemory(0)
+	defer s.Close()
+	l := newLimiter(t, "global", config.RateLimitConfig{RequestsPerMinute: 60, Burst: 4})
+	req := &Request{IP: "192.0.2.1"}
+
+	// One request plus the burst go through at once.
+	for i := 0; i < 5; i++ {
+		st := check(t, s, req, l)
+		if !st.Allowed || st.Remaining != 4-i {
+			t.Fatalf("request %d: %+v, want allowed with %d remaining", i, st, 4-i)
+		}
+	}
+	st := check(t, s, req, l)
+	if st.Allowed {
+		t.Fatalf("request past the burst allowed: %+v", st)
+	}
+	if st.Name != "global/ip" || st.Rate.Limit != 60 || st.Rate.Burst != 4 {
+		t.Errorf("status %+v, want global/ip at 60/min with burst 4", st)
+	}
+	if st.RetryAfter <= 0 || st.RetryAfter > time.Second {
+		t.Errorf("RetryAfter = %v, want at most one interval", st.RetryAfter)
+	}
+	// Other clients have their own bucket.
+	if st := check(t, s, &Request{IP: "192.0.2.2"}, l); !st.Allowed {
+		t.Errorf("other IP refused: %+v", st)
+	}
+}
+
+// TestRefill checks the bucket refills one request per interval rather
+// than all at once at a window boundary.
+func TestRefill(t *testing.T) {
+	s := store.NewMemory(0)
+	defer s.Close()
+	// One request every 20ms.
+	l := newLimiter(t, "global", config.RateLimitConfig{RequestsPerMinute: 3000})
+	req := &Request{IP: "192.0.2.1"}
+
+	if st := check(t, s, req, l); !st.Allowed {
+		t.Fatalf("first request refused: %+v", st)
+	}
+	st := check(t, s, req, l)
+	if st.Allowed {
+		t.Fatalf("second request allowed without a burst: %+v", st)
+	}
+	time.Sleep(st.RetryAfter + 5*time.Millisecond)
+	if st := check(t, s, req, l); !st.Allowed {
+		t.Fatalf("request after RetryAfter refused: %+v", st)
+	}
+	if st := check(t, s, req, l); st.Allowed {
+		t.Fatalf("refill allowed more than one request: %+v", st)
+	}
+}
+
+func TestCheckCountsAgainstNoneWhenRefused(t *testing.T) {
+	s := store.NewMemory(0)
+	defer s.Close()
+	global := newLimiter(t, "global", config.RateLimitConfig{RequestsPerMinute: 60, Burst: 5})
+	login := newLimiter(t, "route:login", config.RateLimitConfig{RequestsPerMinute: 60})
+	req := &Request{IP: "192.0.2.1"}
+
+	statuses, err := Check(context.Background(), s, req, global, login)
+	if err != nil || len(statuses) != 2 || !statuses[0].Allowed || !statuses[1].Allowed {
+		t.Fatalf("first request = %+v, %v", statuses, err)
+	}
+	for i := 0; i < 3; i++ {
+		statuses, _ = Check(context.Background(), s, req, global, login)
+		if statuses[1].Allowed {
+			t.Fatalf("request %d allowed past the route limit", i)
+		}
+	}
+	// Refused requests did not use up the global burst.
+	if st := check(t, s, req, global); st.Remaining != 4 {
+		t.Errorf("global remaining %d after refused requests, want 4", st.Remaining)
+	}
+}
+
+func TestNewRejectsBadConfig(t *testing.T) {
+	for name, cfg := range map[string]config.RateLimitConfig{
+		"no limits":   {},
+		"no rate":     {Keys: []config.RateLimitKeyConfig{{Key: KeyJA4}}},
+		"unknown key": {Keys: []config.RateLimitKeyConfig{{Key: "cookie", RequestsPerMinute: 10}}},
+		"bad header":  {Keys: []config.RateLimitKeyConfig{{Key: "header:", RequestsPerMinute: 10}}},
+		"bad prefix":  {Keys: []config.RateLimitKeyConfig{{Key: KeySubnet, RequestsPerMinute: 10, IPv4Prefix: 40}}},
+	} {
+		if _, err := New("global", cfg); err == nil {
+			t.Errorf("%s: New succeeded", name)
+		}
+	}
+}
+
+func TestKeyValues(t *testing.T) {
+	l := newLimiter(t, "global", config.RateLimitConfig{Keys: []config.RateLimitKeyConfig{
+		{Key: KeyIP, RequestsPerMinute: 1},
+		{Key: KeySubnet, RequestsPerMinute: 1},
+		{Key: KeySubnet, RequestsPerMinute: 1, IPv4Prefix: 16, IPv6Prefix: 48},
+		{Key: KeyASN, RequestsPerMinute: 1},
+		{Key: KeyJA3, RequestsPerMinute: 1},
+		{Key: KeyJA4, RequestsPerMinute: 1},
+		{Key: KeyToken, RequestsPerMinute: 1},
+		{Key: KeyFingerprint, RequestsPerMinute: 1},
+		{Key: "header:x-api-key", RequestsPerMinute: 1},
+	}})
+	header := http.Header{}
+	header.Set("X-Api-Key", "key-1")
+	req := &Request{IP: "::ffff:192.0.2.77", ASN: 64496, JA3: "ja3", JA4: "ja4", Token: "tok", Fingerprint: "fp", Header: header}
+	want := []string{"::ffff:192.0.2.77", "192.0.2.0/24", "192.0.0.0/16", "64496", "ja3", "ja4", "tok", "fp", "key-1"}
+	for i, d := range l.dims {
+		if got := d.value(req); got != want[i] {
+			t.Errorf("%s value = %q, want %q", d.name, got, want[i])
+		}
+	}
+	if !l.Uses(KeyHeader) || !l.Uses(KeyASN) {
+		t.Error("Uses misses configured keys")
+	}
+
+	req = &Request{IP: "2001:db8:1:2:3::9", Header: http.Header{}}
+	want = []string{"2001:db8:1:2:3::9", "2001:db8:1:2::/64", "2001:db8:1::/48", "", "", "", "", "", ""}
+	for i, d := range l.dims {
+		if got := d.value(req); got != want[i] {
+			t.Errorf("%s value for an IPv6 request without extras = %q, want %q", d.name, got, want[i])
+		}
+	}
+}
+
+func TestLongHeaderHashed(t *testing.T) {
+	l := newLimiter(t, "global", config.RateLimitConfig{Keys: []config.RateLimitKeyConfig{{Key: "header:Authorization", RequestsPerMinute: 1}}})
+	long := "Bearer " + strings.Repeat("x", 200)
+	header := http.Header{"Authorization": {long}}
+	v := l.dims[0].value(&Request{Header: header})
+	if len(v) > maxValue || v == long[:len(v)] {
+		t.Errorf("long header stored as %q, want a hash", v)
+	}
+	header.Set("Authorization", long+"y")
+	if l.dims[0].value(&Request{Header: header}) == v {
+		t.Error("different long headers share a key")
+	}
+}
+
+// TestKeysLimitIndependently shares a subnet limit between addresses while
+// each keeps its own IP limit, and leaves requests without a token out of
+// the token limit.
+func TestKeysLimitIndependently(t *testing.T) {
+	s := store.NewMemory(0)
+	defer s.Close()
+	l := newLimiter(t, "global", config.RateLimitConfig{RequestsPerMinute: 60, Burst: 5, Keys: []config.RateLimitKeyConfig{
+		{Key: KeySubnet, RequestsPerMinute: 60, Burst: 1},
+		{Key: KeyToken, RequestsPerMinute: 60},
+	}})
+	allowed := func(req *Request) bool {
+		statuses, err := Check(context.Background(), s, req, l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return Tightest(statuses).Allowed
+	}
+
+	if !allowed(&Request{IP: "192.0.2.1"}) || !allowed(&Request{IP: "192.0.2.2"}) {
+		t.Fatal("first two requests from the subnet refused")
+	}
+	if allowed(&Request{IP: "192.0.2.3"}) {
+		t.Error("third address in the subnet allowed past the subnet burst")
+	}
+	if !allowed(&Request{IP: "198.51.100.1", Token: "tok"}) {
+		t.Fatal("request from another subnet refused")
+	}
+	if allowed(&Request{IP: "203.0.113.1", Token: "tok"}) {
+		t.Error("token reused from another network allowed past its limit")
+	}
+
+	statuses, err := Check(context.Background(), s, &Request{IP: "203.0.113.2"}, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 {
+		t.Errorf("request without a token checked against %d limits, want ip and subnet", len(statuses))
+	}
+}
@@ -0,0 +1,176 @@
+// Package routes matches requests to the policies in the routes config
+// section. Path patterns are compiled into a segment trie, so a lookup
+// walks the request path once however many routes there are.
+package routes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"janus/internal/config"
+)
+
+// Route is one compiled routes entry.
+type Route struct {
+	config.RouteConfig
+	// Index is the route's position in the config; the first matching
+	// route wins.
+	Index int
+}
+
+// Table is a compiled set of routes.
+type Table struct {
+	root   *node
+	routes []*Route
+}
+
+// node is a path segment. exact lists routes whose pattern ends here; rest
+// those ending in "**" here, which also match everything below.
+type node struct {
+	children map[string]*node
+	wildcard *node
+	exact    []int
+	rest     []int
+}
+
+func newNode() *node { return &node{children: make(map[string]*node)} }
+
+// Compile validates cfgs and builds their matcher. Paths are "/"-separated
+// patterns where "*" matches one segment and a final "**" any number,
+// including none; an empty path matches everything.
+func Compile(cfgs []config.RouteConfig) (*Table, error) {
+	t := &Table{root: newNode()}
+	names := make(map[string]bool)
+	for i, rc := range cfgs {
+		if rc.Path == "" {
+			rc.Path = "/**"
+		}
+		if !strings.HasPrefix(rc.Path, "/") {
+			return nil, fmt.Errorf("routes: path %q must start with /", rc.Path)
+		}
+		if rc.Name == "" {
+			rc.Name = rc.Path
+		}
+		if names[rc.Name] {
+			return nil, fmt.Errorf("routes: duplicate route %q; give routes sharing a path distinct names", rc.Name)
+		}
+		names[rc.Name] = true
+		methods := make([]string, len(rc.Methods))
+		for m, method := range rc.Methods {
+			methods[m] = strings.ToUpper(method)
+		}
+		rc.Methods = methods
+		if rc.Difficulty < 0 {
+			return nil, fmt.Errorf("routes: route %q: negative difficulty", rc.Name)
+		}
+
+		segs := split(rc.Path)
+		n := t.root
+		for s, seg := range segs {
+			if seg == "**" {
+				if s != len(segs)-1 {
+					return nil, fmt.Errorf("routes: route %q: ** must be the last segment", rc.Name)
+				}
+				n.rest = append(n.rest, i)
+				n = nil
+				break
+			}
+			var next *node
+			if seg == "*" {
+				if n.wildcard == nil {
+					n.wildcard = newNode()
+				}
+				next = n.wildcard
+			} else {
+				if n.children[seg] == nil {
+					n.children[seg] = newNode()
+				}
+				next = n.children[seg]
+			}
+			n = next
+		}
+		if n != nil {
+			n.exact = append(n.exact, i)
+		}
+		t.routes = append(t.routes, &Route{RouteConfig: rc, Index: i})
+	}
+	return t, nil
+}
+
+// Routes returns the compiled routes in config order.
+func (t *Table) Routes() []*Route { return t.routes }
+
+// Match returns the first route matching the request, or nil. host may
+// carry a port; an empty method matches routes for any method.
+func (t *Table) Match(method, host, path string) *Route {
+	if t == nil || len(t.routes) == 0 {
+		return nil
+	}
+	if i := strings.LastIndexByte(host, ':'); i >= 0 && !strings.HasSuffix(host, "]") {
+		host = host[:i]
+	}
+	host = strings.Trim(host, "[]")
+
+	var candidates []int
+	t.root.collect(split(path), &candidates)
+	sort.Ints(candidates)
+	for _, i := range candidates {
+		r := t.routes[i]
+		if r.matchMethod(method) && r.matchHost(host) {
+			return r
+		}
+	}
+	return nil
+}
+
+func (n *node) collect(segs []string, out *[]int) {
+	*out = append(*out, n.rest...)
+	if len(segs) == 0 {
+		*out = append(*out, n.exact...)
+		return
+	}
+	if child := n.children[segs[0]]; child != nil {
+		child.collect(segs[1:], out)
+	}
+	if n.wildcard != nil {
+		n.wildcard.collect(segs[1:], out)
+	}
+}
+
+func (r *Route) matchMethod(method string) bool {
+	if len(r.Methods) == 0 || method == "" {
+		return true
+	}
+	for _, m := range r.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// matchHost accepts exact host names and "*.example.com" for subdomains.
+func (r *Route) matchHost(host string) bool {
+	if len(r.Hosts) == 0 {
+		return true
+	}
+	for _, h := range r.Hosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+		if strings.HasPrefix(h, "*.") && len(host) > len(h)-1 && strings.EqualFold(host[len(host)-len(h)+1:], h[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// split breaks a path into segments, ignoring a trailing slash.
+func split(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
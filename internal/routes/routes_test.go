@@ -0,0 +1,93 @@
+package routes
+
+import (
+	"testing"
+
+	"janus/internal/config"
+)
+
+func TestMatch(t *testing.T) {
+	table, err := Compile([]config.RouteConfig{
+		{Name: "login", Path: "/login", Methods: []string{"post"}},
+		{Name: "api-admin", Path: "/api/admin/**", Hosts: []string{"*.example.com"}},
+		{Name: "user", Path: "/users/*/profile"},
+		{Name: "api", Path: "/api/**"},
+		{Name: "static", Path: "/static/**"},
+		{Name: "login-page", Path: "/login"},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	tests := []struct {
+		method, host, path string
+		want               string
+	}{
+		{"POST", "example.com", "/login", "login"},
+		{"GET", "example.com", "/login", "login-page"},
+		{"GET", "example.com", "/login/", "login-page"},
+		{"", "example.com", "/login", "login"},
+		{"GET", "eu.example.com:8443", "/api/admin/users", "api-admin"},
+		{"GET", "example.com", "/api/admin/users", "api"},
+		{"GET", "example.com", "/api", "api"},
+		{"GET", "example.com", "/users/42/profile", "user"},
+		{"GET", "example.com", "/users/42/settings", ""},
+		{"GET", "example.com", "/users/profile", ""},
+		{"GET", "[2001:db8::1]:443", "/static/app.js", "static"},
+		{"GET", "example.com", "/", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if r := table.Match(tt.method, tt.host, tt.path); r != nil {
+			got = r.Name
+		}
+		if got != tt.want {
+			t.Errorf("Match(%s %s%s) = %q, want %q", tt.method, tt.host, tt.path, got, tt.want)
+		}
+	}
+}
+
+// TestMatchConfigOrder checks that the first matching route wins even when
+// a later route matches more specifically.
+func TestMatchConfigOrder(t *testing.T) {
+	table, err := Compile([]config.RouteConfig{
+		{Name: "everything"},
+		{Name: "login", Path: "/login"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := table.Match("GET", "example.com", "/login"); r == nil || r.Name != "everything" || r.Index != 0 {
+		t.Errorf("Match = %+v, want the first route", r)
+	}
+	if r := table.Routes()[0]; r.Path != "/**" {
+		t.Errorf("empty path compiled to %q, want /**", r.Path)
+	}
+}
+
+func TestMatchEmptyTable(t *testing.T) {
+	var nilTable *Table
+	if r := nilTable.Match("GET", "example.com", "/"); r != nil {
+		t.Errorf("nil table matched %+v", r)
+	}
+	table, err := Compile(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := table.Match("GET", "example.com", "/"); r != nil {
+		t.Errorf("empty table matched %+v", r)
+	}
+}
+
+func TestCompileRejectsBadConfig(t *testing.T) {
+	for name, cfgs := range map[string][]config.RouteConfig{
+		"relative path":   {{Path: "login"}},
+		"duplicate name":  {{Name: "a", Path: "/a"}, {Name: "a", Path: "/b"}},
+		"duplicate path":  {{Path: "/a"}, {Path: "/a"}},
+		"inner **":        {{Path: "/a/**/b"}},
+		"negative effort": {{Path: "/a", Difficulty: -1}},
+	} {
+		if _, err := Compile(cfgs); err == nil {
+			t.Errorf("%s: Compile succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+// Package signing holds the keys that sign and verify janus_token JWTs: one
+// active signing key plus any number of verification-only keys, each with a
+// kid, so tokens signed before a rotation keep verifying until they expire.
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"janus/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported algorithms.
+const (
+	HS256 = "HS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+// minSecret is the shortest HS256 secret accepted (RFC 7518 3.2).
+const minSecret = 32
+
+// Key is one signing or verification key.
+type Key struct {
+	ID  string
+	Alg string
+	// sign is nil for verification-only keys.
+	sign   interface{}
+	verify interface{}
+}
+
+// CanSign reports whether the key holds private (or secret) material.
+func (k *Key) CanSign() bool { return k.sign != nil }
+
+// Keyring selects keys by kid.
+type Keyring struct {
+	active *Key
+	keys   map[string]*Key
+}
+
+// Load builds a keyring from cfg. cfg.Active must name a key that can sign;
+// when it is empty the only configured key is used.
+func Load(cfg config.SigningConfig) (*Keyring, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, fmt.Errorf("signing: no keys configured")
+	}
+	kr := &Keyring{keys: make(map[string]*Key)}
+	for _, kc := range cfg.Keys {
+		k, err := loadKey(kc)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := kr.keys[k.ID]; dup {
+			return nil, fmt.Errorf("signing: key %q configured twice", k.ID)
+		}
+		kr.keys[k.ID] = k
+	}
+	active := cfg.Active
+	if active == "" {
+		if len(cfg.Keys) > 1 {
+			return nil, fmt.Errorf("signing: %d keys configured but no active key", len(cfg.Keys))
+		}
+		active = cfg.Keys[0].ID
+	}
+	kr.active = kr.keys[active]
+	if kr.active == nil {
+		return nil, fmt.Errorf("signing: active key %q not configured", active)
+	}
+	if !kr.active.CanSign() {
+		return nil, fmt.Errorf("signing: active key %q has no private key", active)
+	}
+	return kr, nil
+}
+
+// NewHMAC returns a keyring holding a single HS256 key.
+func NewHMAC(id string, secret []byte) (*Keyring, error) {
+	if len(secret) < minSecret {
+		return nil, fmt.Errorf("signing: HS256 secret %q is %d bytes, need at least %d", id, len(secret), minSecret)
+	}
+	k := &Key{ID: id, Alg: HS256, sign: secret, verify: secret}
+	return &Keyring{active: k, keys: map[string]*Key{id: k}}, nil
+}
+
+// Ephemeral returns a keyring with a random HS256 key. Its tokens do not
+// survive a restart and are not accepted by other replicas.
+func Ephemeral() (*Keyring, error) {
+	secret := make([]byte, minSecret)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return NewHMAC("ephemeral", secret)
+}
+
+func loadKey(kc config.SigningKeyConfig) (*Key, error) {
+	if kc.ID == "" {
+		return nil, fmt.Errorf("signing: key without id")
+	}
+	k := &Key{ID: kc.ID, Alg: kc.Alg}
+	switch kc.Alg {
+	case HS256:
+		secret, err := readSource("secret", kc.Secret, kc.SecretEnv, kc.SecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("signing: key %q: %w", kc.ID, err)
+		}
+		if len(secret) < minSecret {
+			return nil, fmt.Errorf("signing: key %q: HS256 secret is %d bytes, need at least %d", kc.ID, len(secret), minSecret)
+		}
+		k.sign, k.verify = secret, secret
+	case ES256, EdDSA:
+		if kc.PrivateKeyFile == "" && kc.PrivateKeyEnv == "" {
+			pemData, err := readSource("public key", "", "", kc.PublicKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("signing: key %q: %w", kc.ID, err)
+			}
+			if k.verify, err = parsePublic(kc.Alg, pemData); err != nil {
+				return nil, fmt.Errorf("signing: key %q: %w", kc.ID, err)
+			}
+			break
+		}
+		pemData, err := readSource("private key", "", kc.PrivateKeyEnv, kc.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("signing: key %q: %w", kc.ID, err)
+		}
+		if k.sign, k.verify, err = parsePrivate(kc.Alg, pemData); err != nil {
+			return nil, fmt.Errorf("signing: key %q: %w", kc.ID, err)
+		}
+	default:
+		return nil, fmt.Errorf("signing: key %q: unsupported algorithm %q (want HS256, ES256 or EdDSA)", kc.ID, kc.Alg)
+	}
+	return k, nil
+}
+
+// readSource returns the first of an inline value, an environment variable
+// or a file that is set.
+func readSource(what, inline, env, file string) ([]byte, error) {
+	switch {
+	case inline != "":
+		return []byte(inline), nil
+	case env != "":
+		v := os.Getenv(env)
+		if v == "" {
+			return nil, fmt.Errorf("%s: environment variable %s is empty", what, env)
+		}
+		return []byte(v), nil
+	case file != "":
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", what, err)
+		}
+		// Secrets written with echo carry a trailing newline.
+		return []byte(strings.TrimRight(string(data), "\r\n")), nil
+	}
+	return nil, fmt.Errorf("no %s configured", what)
+}
+
+func parsePrivate(alg string, pemData []byte) (sign, verify interface{}, err error) {
+	if alg == ES256 {
+		key, err := jwt.ParseECPrivateKeyFromPEM(pemData)
+		if err != nil {
+			return nil, nil, err
+		}
+		if key.Curve != elliptic.P256() {
+			return nil, nil, fmt.Errorf("ES256 needs a P-256 key, got %s", key.Curve.Params().Name)
+		}
+		return key, &key.PublicKey, nil
+	}
+	key, err := jwt.ParseEdPrivateKeyFromPEM(pemData)
+	if err != nil {
+		return nil, nil, err
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("EdDSA needs an Ed25519 key")
+	}
+	return priv, priv.Public(), nil
+}
+
+func parsePublic(alg string, pemData []byte) (interface{}, error) {
+	if alg == ES256 {
+		key, err := jwt.ParseECPublicKeyFromPEM(pemData)
+		if err != nil {
+			return nil, err
+		}
+		if key.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("ES256 needs a P-256 key, got %s", key.Curve.Params().Name)
+		}
+		return key, nil
+	}
+	key, err := jwt.ParseEdPublicKeyFromPEM(pemData)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := key.(ed25519.PublicKey); !ok {
+		return nil, fmt.Errorf("EdDSA needs an Ed25519 key")
+	}
+	return key, nil
+}
+
+// Active returns the key new tokens are signed with.
+func (kr *Keyring) Active() *Key { return kr.active }
+
+// Sign signs claims with the active key, naming it in the kid header.
+func (kr *Keyring) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(kr.active.Alg), claims)
+	token.Header["kid"] = kr.active.ID
+	return token.SignedString(kr.active.sign)
+}
+
+// Keyfunc is a jwt.Keyfunc that picks the verification key by kid and
+// rejects tokens whose alg differs from the key's.
+func (kr *Keyring) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, fmt.Errorf("token has no kid")
+	}
+	k, ok := kr.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	if token.Method.Alg() != k.Alg {
+		return nil, fmt.Errorf("kid %q is %s, token is %s", kid, k.Alg, token.Method.Alg())
+	}
+	return k.verify, nil
+}
+
+// Algorithms lists the algorithms of all keys, for jwt.WithValidMethods.
+func (kr *Keyring) Algorithms() []string {
+	seen := make(map[string]bool)
+	var algs []string
+	for _, k := range kr.keys {
+		if !seen[k.Alg] {
+			seen[k.Alg] = true
+			algs = append(algs, k.Alg)
+		}
+	}
+	sort.Strings(algs)
+	return algs
+}
+
+// JWK is a public key in RFC 7517 form.
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y,omitempty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+}
+
+// JWKSet is the body of a jwks.json document.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public halves of all asymmetric keys, including
+// verification-only ones. HS256 secrets are never published.
+func (kr *Keyring) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	b64 := base64.RawURLEncoding.EncodeToString
+	for _, k := range kr.keys {
+		switch pub := k.verify.(type) {
+		case *ecdsa.PublicKey:
+			ecdhKey, err := pub.ECDH()
+			if err != nil {
+				continue
+			}
+			// Uncompressed point: 0x04 || X || Y.
+			point := ecdhKey.Bytes()
+			set.Keys = append(set.Keys, JWK{Kty: "EC", Crv: "P-256", X: b64(point[1:33]), Y: b64(point[33:]), Kid: k.ID, Alg: k.Alg, Use: "sig"})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{Kty: "OKP", Crv: "Ed25519", X: b64(pub), Kid: k.ID, Alg: k.Alg, Use: "sig"})
+		}
+	}
+	sort.Slice(set.Keys, func(a, b int) bool { return set.Keys[a].Kid < set.Keys[b].Kid })
+	return set
+}
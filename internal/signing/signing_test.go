@@ -0,0 +1,267 @@
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"janus/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var secret = strings.Repeat("s", minSecret)
+
+// writePEM writes der as a PEM block of type typ and returns the path.
+func writePEM(t *testing.T, name, typ string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// ecKey writes a new ECDSA key on curve and returns the key and the paths
+// of its private and public PEM files.
+func ecKey(t *testing.T, curve elliptic.Curve) (*ecdsa.PrivateKey, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, writePEM(t, "ec.pem", "EC PRIVATE KEY", priv), writePEM(t, "ec.pub", "PUBLIC KEY", pub)
+}
+
+// edKey writes a new Ed25519 key and returns it and the paths of its
+// private and public PEM files.
+func edKey(t *testing.T) (ed25519.PublicKey, string, string) {
+	t.Helper()
+	pubKey, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(pubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pubKey, writePEM(t, "ed.pem", "PRIVATE KEY", priv), writePEM(t, "ed.pub", "PUBLIC KEY", pub)
+}
+
+func load(t *testing.T, cfg config.SigningConfig) *Keyring {
+	t.Helper()
+	kr, err := Load(cfg)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return kr
+}
+
+func parse(kr *Keyring, token string) error {
+	_, err := jwt.Parse(token, kr.Keyfunc, jwt.WithValidMethods(kr.Algorithms()))
+	return err
+}
+
+func TestLoadRejectsBadConfig(t *testing.T) {
+	_, _, p384 := ecKey(t, elliptic.P384())
+	_, _, edPub := edKey(t)
+	hs := config.SigningKeyConfig{ID: "hs", Alg: HS256, Secret: secret}
+	for name, cfg := range map[string]config.SigningConfig{
+		"no keys":          {},
+		"no id":            {Keys: []config.SigningKeyConfig{{Alg: HS256, Secret: secret}}},
+		"short secret":     {Keys: []config.SigningKeyConfig{{ID: "hs", Alg: HS256, Secret: "short"}}},
+		"unknown alg":      {Keys: []config.SigningKeyConfig{{ID: "rs", Alg: "RS256", Secret: secret}}},
+		"duplicate":        {Active: "hs", Keys: []config.SigningKeyConfig{hs, hs}},
+		"no active":        {Keys: []config.SigningKeyConfig{hs, {ID: "ed", Alg: EdDSA, PublicKeyFile: edPub}}},
+		"missing active":   {Active: "other", Keys: []config.SigningKeyConfig{hs}},
+		"public active":    {Keys: []config.SigningKeyConfig{{ID: "ed", Alg: EdDSA, PublicKeyFile: edPub}}},
+		"wrong curve":      {Keys: []config.SigningKeyConfig{{ID: "ec", Alg: ES256, PublicKeyFile: p384}}},
+		"wrong key type":   {Keys: []config.SigningKeyConfig{{ID: "ec", Alg: ES256, PublicKeyFile: edPub}}},
+		"empty secret env": {Keys: []config.SigningKeyConfig{{ID: "hs", Alg: HS256, SecretEnv: "JANUS_TEST_UNSET"}}},
+	} {
+		if _, err := Load(cfg); err == nil {
+			t.Errorf("%s: Load succeeded", name)
+		}
+	}
+}
+
+func TestSecretSources(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte(secret+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("JANUS_TEST_SECRET", secret)
+	inline := load(t, config.SigningConfig{Keys: []config.SigningKeyConfig{{ID: "k", Alg: HS256, Secret: secret}}})
+	token, err := inline.Sign(jwt.MapClaims{"sub": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, kc := range map[string]config.SigningKeyConfig{
+		"env":  {ID: "k", Alg: HS256, SecretEnv: "JANUS_TEST_SECRET"},
+		"file": {ID: "k", Alg: HS256, SecretFile: path},
+	} {
+		kr := load(t, config.SigningConfig{Keys: []config.SigningKeyConfig{kc}})
+		if err := parse(kr, token); err != nil {
+			t.Errorf("%s: token signed with the inline secret rejected: %v", name, err)
+		}
+	}
+}
+
+func TestSignSetsKid(t *testing.T) {
+	_, edPriv, _ := edKey(t)
+	kr := load(t, config.SigningConfig{Keys: []config.SigningKeyConfig{{ID: "ed-1", Alg: EdDSA, PrivateKeyFile: edPriv}}})
+	s, err := kr.Sign(jwt.MapClaims{"sub": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.Parse(s, kr.Keyfunc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Header["kid"] != "ed-1" || token.Method.Alg() != EdDSA {
+		t.Errorf("header %v, want kid ed-1 and alg EdDSA", token.Header)
+	}
+}
+
+// TestRotation moves from an ES256 key to an EdDSA one: tokens signed
+// before the switch verify until the old key is removed.
+func TestRotation(t *testing.T) {
+	_, ecPriv, ecPub := ecKey(t, elliptic.P256())
+	_, edPriv, _ := edKey(t)
+	before := load(t, config.SigningConfig{Keys: []config.SigningKeyConfig{{ID: "old", Alg: ES256, PrivateKeyFile: ecPriv}}})
+	old, err := before.Sign(jwt.MapClaims{"sub": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after := load(t, config.SigningConfig{Active: "new", Keys: []config.SigningKeyConfig{
+		{ID: "old", Alg: ES256, PublicKeyFile: ecPub},
+		{ID: "new", Alg: EdDSA, PrivateKeyFile: edPriv},
+	}})
+	if err := parse(after, old); err != nil {
+		t.Errorf("token from before the rotation rejected: %v", err)
+	}
+	fresh, err := after.Sign(jwt.MapClaims{"sub": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parse(after, fresh); err != nil {
+		t.Errorf("token from the new key rejected: %v", err)
+	}
+	if err := parse(before, fresh); err == nil {
+		t.Error("token from the new key accepted by a keyring without it")
+	}
+
+	retired := load(t, config.SigningConfig{Keys: []config.SigningKeyConfig{{ID: "new", Alg: EdDSA, PrivateKeyFile: edPriv}}})
+	if err := parse(retired, old); err == nil {
+		t.Error("token accepted after its key was removed")
+	}
+}
+
+func TestKeyfuncRejectsAlgMismatch(t *testing.T) {
+	_, ecPriv, _ := ecKey(t, elliptic.P256())
+	kr := load(t, config.SigningConfig{Active: "ec", Keys: []config.SigningKeyConfig{
+		{ID: "ec", Alg: ES256, PrivateKeyFile: ecPriv},
+		{ID: "hs", Alg: HS256, Secret: secret},
+	}})
+	// An HS256 token naming the ES256 key must not be checked against it.
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "x"})
+	token.Header["kid"] = "ec"
+	s, err := token.SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parse(kr, s); err == nil {
+		t.Error("HS256 token accepted under an ES256 kid")
+	}
+
+	noKid := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "x"})
+	s, err = noKid.SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parse(kr, s); err == nil {
+		t.Error("token without kid accepted")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	ec, ecPriv, _ := ecKey(t, elliptic.P256())
+	ed, _, edPub := edKey(t)
+	kr := load(t, config.SigningConfig{Active: "ec", Keys: []config.SigningKeyConfig{
+		{ID: "ec", Alg: ES256, PrivateKeyFile: ecPriv},
+		{ID: "ed", Alg: EdDSA, PublicKeyFile: edPub},
+		{ID: "hs", Alg: HS256, Secret: secret},
+	}})
+	set := kr.JWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("JWKS = %+v, want the two asymmetric keys only", set)
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+	ecJWK, edJWK := set.Keys[0], set.Keys[1]
+	if ecJWK.Kid != "ec" || ecJWK.Kty != "EC" || ecJWK.Crv != "P-256" || ecJWK.Alg != ES256 || ecJWK.Use != "sig" {
+		t.Errorf("EC JWK = %+v", ecJWK)
+	}
+	x, y := new(big.Int), new(big.Int)
+	xb, _ := base64.RawURLEncoding.DecodeString(ecJWK.X)
+	yb, _ := base64.RawURLEncoding.DecodeString(ecJWK.Y)
+	if len(xb) != 32 || len(yb) != 32 || x.SetBytes(xb).Cmp(ec.X) != 0 || y.SetBytes(yb).Cmp(ec.Y) != 0 {
+		t.Errorf("EC JWK point (%s, %s) does not match the key", ecJWK.X, ecJWK.Y)
+	}
+	if edJWK.Kid != "ed" || edJWK.Kty != "OKP" || edJWK.Crv != "Ed25519" || edJWK.X != b64(ed) || edJWK.Y != "" {
+		t.Errorf("Ed25519 JWK = %+v", edJWK)
+	}
+
+	hmac, err := NewHMAC("default", []byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := hmac.JWKS().Keys; keys == nil || len(keys) != 0 {
+		t.Errorf("HS256-only JWKS = %#v, want an empty list", keys)
+	}
+}
+
+func TestNewHMACRejectsShortSecret(t *testing.T) {
+	if _, err := NewHMAC("default", []byte("short")); err == nil {
+		t.Error("NewHMAC accepted a short secret")
+	}
+}
+
+func TestEphemeral(t *testing.T) {
+	a, err := Ephemeral()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Ephemeral()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := a.Sign(jwt.MapClaims{"sub": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parse(b, s); err == nil {
+		t.Error("ephemeral keys are shared between keyrings")
+	}
+}
@@ -0,0 +1,327 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"janus/internal/types"
+
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketSessions     = []byte("sessions")
+	bucketNonces       = []byte("nonces")
+	bucketChallenges   = []byte("challenges")
+	bucketFingerprints = []byte("fingerprints")
+	bucketReputation   = []byte("reputation")
+	bucketRevocations  = []byte("revocations")
+
+	allBuckets = [][]byte{bucketSessions, bucketNonces, bucketChallenges, bucketFingerprints, bucketReputation, bucketRevocations}
+
+	// oldBuckets held counters and rate limits before they moved to memory.
+	oldBuckets = [][]byte{[]byte("counters"), []byte("rates")}
+)
+
+// Bolt is an embedded on-disk backend for single-node installs that should
+// keep state across restarts without running Redis. Counters and rate
+// limits are written on every request and only matter for seconds to
+// minutes, so they are kept in memory instead of costing an fsync each;
+// a restart forgets them.
+type Bolt struct {
+	db    *bolt.DB
+	rates *Memory
+}
+
+// record wraps every stored value with its expiry (Unix nanoseconds).
+type record struct {
+	Value   json.RawMessage `json:"v"`
+	Expires int64           `json:"e"`
+}
+
+// OpenBolt opens or creates the database file at path.
+func OpenBolt(path string) (*Bolt, error) {
+	if path == "" {
+		path = "janus.db"
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range allBuckets {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		for _, b := range oldBuckets {
+			if err := tx.DeleteBucket(b); err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Bolt{db: db, rates: NewMemory(0)}, nil
+}
+
+// load decodes the live record at key into v, reporting false for missing
+// or expired keys.
+func load(b *bolt.Bucket, key string, now time.Time, v interface{}) (bool, error) {
+	raw := b.Get([]byte(key))
+	if raw == nil {
+		return false, nil
+	}
+	var rec record
+	if err := json.Unmarshal(raw, &rec); err != nil {
+		return false, err
+	}
+	if now.UnixNano() > rec.Expires {
+		return false, nil
+	}
+	return true, json.Unmarshal(rec.Value, v)
+}
+
+func save(b *bolt.Bucket, key string, v interface{}, expires time.Time) error {
+	val, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(record{Value: val, Expires: expires.UnixNano()})
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(key), raw)
+}
+
+func (s *Bolt) get(ctx context.Context, bucket []byte, key string, v interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.View(func(tx *bolt.Tx) error {
+		ok, err := load(tx.Bucket(bucket), key, time.Now(), v)
+		if err == nil && !ok {
+			err = ErrNotFound
+		}
+		return err
+	})
+}
+
+func (s *Bolt) put(ctx context.Context, bucket []byte, key string, v interface{}, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return save(tx.Bucket(bucket), key, v, time.Now().Add(ttl))
+	})
+}
+
+// take loads and deletes key in one transaction.
+func (s *Bolt) take(ctx context.Context, bucket []byte, key string, v interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		ok, err := load(b, key, time.Now(), v)
+		if derr := b.Delete([]byte(key)); derr != nil {
+			return derr
+		}
+		if err == nil && !ok {
+			err = ErrNotFound
+		}
+		return err
+	})
+}
+
+func (s *Bolt) GetSession(ctx context.Context, token string) (*Session, error) {
+	var session Session
+	if err := s.get(ctx, bucketSessions, token, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *Bolt) SetSession(ctx context.Context, token string, session *Session, ttl time.Duration) error {
+	return s.put(ctx, bucketSessions, token, session, ttl)
+}
+
+func (s *Bolt) UpdateSession(ctx context.Context, token string, ttl time.Duration, fn func(*Session) error) (*Session, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var session Session
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketSessions)
+		now := time.Now()
+		ok, err := load(b, token, now, &session)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrNotFound
+		}
+		if err := fn(&session); err != nil {
+			return err
+		}
+		return save(b, token, &session, now.Add(ttl))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *Bolt) DeleteSession(ctx context.Context, token string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSessions).Delete([]byte(token))
+	})
+}
+
+func (s *Bolt) CreateNonce(ctx context.Context, ttl time.Duration) (string, error) {
+	nonce := uuid.New().String()
+	return nonce, s.put(ctx, bucketNonces, nonce, true, ttl)
+}
+
+func (s *Bolt) ValidateNonce(ctx context.Context, nonce string) (bool, error) {
+	var valid bool
+	err := s.take(ctx, bucketNonces, nonce, &valid)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *Bolt) PutChallenge(ctx context.Context, key string, c *types.Challenge, ttl time.Duration) error {
+	return s.put(ctx, bucketChallenges, key, c, ttl)
+}
+
+func (s *Bolt) TakeChallenge(ctx context.Context, key string) (*types.Challenge, error) {
+	var c types.Challenge
+	if err := s.take(ctx, bucketChallenges, key, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s *Bolt) PutFingerprint(ctx context.Context, id string, fp *types.Fingerprint, ttl time.Duration) error {
+	return s.put(ctx, bucketFingerprints, id, fp, ttl)
+}
+
+func (s *Bolt) GetFingerprint(ctx context.Context, id string) (*types.Fingerprint, error) {
+	var fp types.Fingerprint
+	if err := s.get(ctx, bucketFingerprints, id, &fp); err != nil {
+		return nil, err
+	}
+	return &fp, nil
+}
+
+func (s *Bolt) Incr(ctx context.Context, key string, window time.Duration) (int64, error) {
+	return s.rates.Incr(ctx, key, window)
+}
+
+func (s *Bolt) AllowRates(ctx context.Context, keys []RateKey) ([]RateResult, error) {
+	return s.rates.AllowRates(ctx, keys)
+}
+
+func (s *Bolt) GetReputation(ctx context.Context, key string) (int, error) {
+	var score int
+	err := s.get(ctx, bucketReputation, key, &score)
+	if errors.Is(err, ErrNotFound) {
+		return 0, nil
+	}
+	return score, err
+}
+
+func (s *Bolt) AddReputation(ctx context.Context, key string, delta int, ttl time.Duration) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	var score int
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketReputation)
+		now := time.Now()
+		if _, err := load(b, key, now, &score); err != nil {
+			return err
+		}
+		score += delta
+		return save(b, key, score, now.Add(ttl))
+	})
+	return score, err
+}
+
+func (s *Bolt) Revoke(ctx context.Context, scope string, at time.Time, ttl time.Duration) error {
+	return s.put(ctx, bucketRevocations, scope, at.UnixNano(), ttl)
+}
+
+func (s *Bolt) RevokedAt(ctx context.Context, scopes ...string) (time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, err
+	}
+	var latest time.Time
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketRevocations)
+		now := time.Now()
+		for _, scope := range scopes {
+			var n int64
+			ok, err := load(b, scope, now, &n)
+			if err != nil {
+				return err
+			}
+			if at := time.Unix(0, n); ok && at.After(latest) {
+				latest = at
+			}
+		}
+		return nil
+	})
+	return latest, err
+}
+
+func (s *Bolt) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (s *Bolt) Close() error {
+	return s.db.Close()
+}
+
+func (s *Bolt) Sweep(ctx context.Context) error {
+	if err := s.rates.Sweep(ctx); err != nil {
+		return err
+	}
+	now := time.Now().UnixNano()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range allBuckets {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			b := tx.Bucket(name)
+			var expired [][]byte
+			err := b.ForEach(func(k, v []byte) error {
+				var rec record
+				if err := json.Unmarshal(v, &rec); err != nil || now > rec.Expires {
+					expired = append(expired, append([]byte(nil), k...))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, k := range expired {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
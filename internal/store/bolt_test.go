@@ -0,0 +1,50 @@
+package store_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"janus/internal/store"
+	"janus/internal/store/storetest"
+)
+
+func TestBolt(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		s, err := store.OpenBolt(filepath.Join(t.TempDir(), "janus.db"))
+		if err != nil {
+			t.Fatalf("OpenBolt: %v", err)
+		}
+		return s
+	})
+}
+
+func TestBoltPersists(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "janus.db")
+	s, err := store.OpenBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetSession(ctx, "tok", &store.Session{PagesViewed: 2}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Incr(ctx, "c", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = store.OpenBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got, err := s.GetSession(ctx, "tok"); err != nil || got.PagesViewed != 2 {
+		t.Errorf("session after reopen = %+v, %v", got, err)
+	}
+	// Counters live in memory and start over.
+	if n, _ := s.Incr(ctx, "c", time.Minute); n != 1 {
+		t.Errorf("Incr after reopen = %d, want 1", n)
+	}
+}
@@ -0,0 +1,221 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"janus/internal/types"
+)
+
+// ErrUnavailable is returned by a Breaker while its backend is down.
+var ErrUnavailable = errors.New("store: backend unavailable")
+
+// BreakerOptions configures NewBreaker.
+type BreakerOptions struct {
+	// Failures is how many operations in a row must fail before the
+	// backend counts as down (default 5).
+	Failures int
+	// ProbeInterval is how often a down backend is pinged (default 2s).
+	ProbeInterval time.Duration
+	// OnChange is called when the backend goes down, with the error that
+	// tripped the breaker, and when it answers again, with nil.
+	OnChange func(healthy bool, err error)
+}
+
+// Breaker is a circuit breaker around a network backend. Once the backend
+// is down every call fails at once with ErrUnavailable, instead of each
+// request waiting out the operation timeout, until a background Ping
+// succeeds.
+type Breaker struct {
+	Store
+	opts     BreakerOptions
+	failures atomic.Int64
+	down     atomic.Bool
+	done     chan struct{}
+	wg       sync.WaitGroup
+	// mu orders starting a probe against Close, so no probe is added to
+	// wg once Close is waiting on it.
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewBreaker wraps st.
+func NewBreaker(st Store, opts BreakerOptions) *Breaker {
+	if opts.Failures <= 0 {
+		opts.Failures = 5
+	}
+	if opts.ProbeInterval <= 0 {
+		opts.ProbeInterval = 2 * time.Second
+	}
+	return &Breaker{Store: st, opts: opts, done: make(chan struct{})}
+}
+
+// Healthy reports whether calls currently reach the backend.
+func (b *Breaker) Healthy() bool {
+	return !b.down.Load()
+}
+
+// record counts err against the backend. Missing keys are answers, and a
+// caller giving up says nothing about the backend.
+func (b *Breaker) record(err error) {
+	if err == nil || errors.Is(err, ErrNotFound) {
+		b.failures.Store(0)
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if b.failures.Add(1) >= int64(b.opts.Failures) {
+		b.Trip(err)
+	}
+}
+
+// Trip marks the backend down because of err, as if Failures operations
+// in a row had failed, and starts probing it. It is how a backend that
+// does not answer at startup begins.
+func (b *Breaker) Trip(err error) {
+	if !b.down.CompareAndSwap(false, true) {
+		return
+	}
+	if b.opts.OnChange != nil {
+		b.opts.OnChange(false, err)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.wg.Add(1)
+		go b.probe()
+	}
+}
+
+// probe pings the backend until it answers, then closes the breaker.
+func (b *Breaker) probe() {
+	defer b.wg.Done()
+	ticker := time.NewTicker(b.opts.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), b.opts.ProbeInterval)
+			err := b.Store.Ping(ctx)
+			cancel()
+			if err != nil {
+				continue
+			}
+			b.failures.Store(0)
+			b.down.Store(false)
+			if b.opts.OnChange != nil {
+				b.opts.OnChange(true, nil)
+			}
+			return
+		}
+	}
+}
+
+func call[T any](b *Breaker, fn func() (T, error)) (T, error) {
+	if b.down.Load() {
+		var zero T
+		return zero, ErrUnavailable
+	}
+	v, err := fn()
+	b.record(err)
+	return v, err
+}
+
+func exec(b *Breaker, fn func() error) error {
+	_, err := call(b, func() (struct{}, error) { return struct{}{}, fn() })
+	return err
+}
+
+func (b *Breaker) GetSession(ctx context.Context, token string) (*Session, error) {
+	return call(b, func() (*Session, error) { return b.Store.GetSession(ctx, token) })
+}
+
+func (b *Breaker) SetSession(ctx context.Context, token string, session *Session, ttl time.Duration) error {
+	return exec(b, func() error { return b.Store.SetSession(ctx, token, session, ttl) })
+}
+
+// UpdateSession does not count fn's own errors against the backend.
+func (b *Breaker) UpdateSession(ctx context.Context, token string, ttl time.Duration, fn func(*Session) error) (*Session, error) {
+	if b.down.Load() {
+		return nil, ErrUnavailable
+	}
+	var fnErr error
+	s, err := b.Store.UpdateSession(ctx, token, ttl, func(s *Session) error {
+		fnErr = fn(s)
+		return fnErr
+	})
+	if err == nil || err != fnErr {
+		b.record(err)
+	}
+	return s, err
+}
+
+func (b *Breaker) DeleteSession(ctx context.Context, token string) error {
+	return exec(b, func() error { return b.Store.DeleteSession(ctx, token) })
+}
+
+func (b *Breaker) CreateNonce(ctx context.Context, ttl time.Duration) (string, error) {
+	return call(b, func() (string, error) { return b.Store.CreateNonce(ctx, ttl) })
+}
+
+func (b *Breaker) ValidateNonce(ctx context.Context, nonce string) (bool, error) {
+	return call(b, func() (bool, error) { return b.Store.ValidateNonce(ctx, nonce) })
+}
+
+func (b *Breaker) PutChallenge(ctx context.Context, key string, c *types.Challenge, ttl time.Duration) error {
+	return exec(b, func() error { return b.Store.PutChallenge(ctx, key, c, ttl) })
+}
+
+func (b *Breaker) TakeChallenge(ctx context.Context, key string) (*types.Challenge, error) {
+	return call(b, func() (*types.Challenge, error) { return b.Store.TakeChallenge(ctx, key) })
+}
+
+func (b *Breaker) PutFingerprint(ctx context.Context, id string, fp *types.Fingerprint, ttl time.Duration) error {
+	return exec(b, func() error { return b.Store.PutFingerprint(ctx, id, fp, ttl) })
+}
+
+func (b *Breaker) GetFingerprint(ctx context.Context, id string) (*types.Fingerprint, error) {
+	return call(b, func() (*types.Fingerprint, error) { return b.Store.GetFingerprint(ctx, id) })
+}
+
+func (b *Breaker) Incr(ctx context.Context, key string, window time.Duration) (int64, error) {
+	return call(b, func() (int64, error) { return b.Store.Incr(ctx, key, window) })
+}
+
+func (b *Breaker) AllowRates(ctx context.Context, keys []RateKey) ([]RateResult, error) {
+	return call(b, func() ([]RateResult, error) { return b.Store.AllowRates(ctx, keys) })
+}
+
+func (b *Breaker) GetReputation(ctx context.Context, key string) (int, error) {
+	return call(b, func() (int, error) { return b.Store.GetReputation(ctx, key) })
+}
+
+func (b *Breaker) AddReputation(ctx context.Context, key string, delta int, ttl time.Duration) (int, error) {
+	return call(b, func() (int, error) { return b.Store.AddReputation(ctx, key, delta, ttl) })
+}
+
+func (b *Breaker) Revoke(ctx context.Context, scope string, at time.Time, ttl time.Duration) error {
+	return exec(b, func() error { return b.Store.Revoke(ctx, scope, at, ttl) })
+}
+
+func (b *Breaker) RevokedAt(ctx context.Context, scopes ...string) (time.Time, error) {
+	return call(b, func() (time.Time, error) { return b.Store.RevokedAt(ctx, scopes...) })
+}
+
+// Close stops probing and closes the backend.
+func (b *Breaker) Close() error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.done)
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+	return b.Store.Close()
+}
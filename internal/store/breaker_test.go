@@ -0,0 +1,148 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"janus/internal/store"
+)
+
+var errDown = errors.New("connection refused")
+
+// flaky is a memory store whose sessions and pings fail while down is set,
+// standing in for an unreachable Redis.
+type flaky struct {
+	*store.Memory
+	down atomic.Bool
+}
+
+func newFlaky(down bool) *flaky {
+	f := &flaky{Memory: store.NewMemory(0)}
+	f.down.Store(down)
+	return f
+}
+
+func (f *flaky) Ping(ctx context.Context) error {
+	if f.down.Load() {
+		return errDown
+	}
+	return f.Memory.Ping(ctx)
+}
+
+func (f *flaky) GetSession(ctx context.Context, token string) (*store.Session, error) {
+	if f.down.Load() {
+		return nil, errDown
+	}
+	return f.Memory.GetSession(ctx, token)
+}
+
+func (f *flaky) UpdateSession(ctx context.Context, token string, ttl time.Duration, fn func(*store.Session) error) (*store.Session, error) {
+	if f.down.Load() {
+		return nil, errDown
+	}
+	return f.Memory.UpdateSession(ctx, token, ttl, fn)
+}
+
+// waitHealthy waits for b's probe to find the backend again.
+func waitHealthy(t *testing.T, b *store.Breaker) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !b.Healthy() {
+		if time.Now().After(deadline) {
+			t.Fatal("breaker did not close after the backend came back")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	ctx := context.Background()
+	f := newFlaky(true)
+	changes := make(chan bool, 2)
+	b := store.NewBreaker(f, store.BreakerOptions{
+		Failures:      3,
+		ProbeInterval: 10 * time.Millisecond,
+		OnChange:      func(healthy bool, err error) { changes <- healthy },
+	})
+	defer b.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := b.GetSession(ctx, "tok"); !errors.Is(err, errDown) {
+			t.Fatalf("call %d: error = %v, want the backend's", i, err)
+		}
+	}
+	if b.Healthy() {
+		t.Fatal("breaker still closed after 3 failures")
+	}
+	if _, err := b.GetSession(ctx, "tok"); !errors.Is(err, store.ErrUnavailable) {
+		t.Fatalf("error while down = %v, want ErrUnavailable", err)
+	}
+
+	f.down.Store(false)
+	waitHealthy(t, b)
+	if _, err := b.GetSession(ctx, "tok"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("error after recovery = %v, want ErrNotFound", err)
+	}
+	if down, up := <-changes, <-changes; down || !up {
+		t.Errorf("OnChange calls = [%v %v], want [false true]", down, up)
+	}
+}
+
+func TestBreakerCountsOnlyBackendErrors(t *testing.T) {
+	ctx := context.Background()
+	b := store.NewBreaker(newFlaky(false), store.BreakerOptions{Failures: 1, ProbeInterval: time.Hour})
+	defer b.Close()
+
+	if _, err := b.GetSession(ctx, "missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatal(err)
+	}
+	if err := b.SetSession(ctx, "tok", &store.Session{}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	errStop := errors.New("stop")
+	if _, err := b.UpdateSession(ctx, "tok", time.Minute, func(*store.Session) error { return errStop }); err != errStop {
+		t.Fatalf("UpdateSession error = %v, want fn's", err)
+	}
+	if !b.Healthy() {
+		t.Error("breaker opened on a missing key or an UpdateSession callback error")
+	}
+}
+
+func TestBreakerTrip(t *testing.T) {
+	ctx := context.Background()
+	f := newFlaky(true)
+	b := store.NewBreaker(f, store.BreakerOptions{ProbeInterval: 10 * time.Millisecond})
+	defer b.Close()
+
+	b.Trip(errDown)
+	if _, err := b.CreateNonce(ctx, time.Minute); !errors.Is(err, store.ErrUnavailable) {
+		t.Fatalf("CreateNonce after Trip: error = %v, want ErrUnavailable", err)
+	}
+	f.down.Store(false)
+	waitHealthy(t, b)
+	if _, err := b.CreateNonce(ctx, time.Minute); err != nil {
+		t.Fatalf("CreateNonce after recovery: %v", err)
+	}
+}
+
+// TestBreakerTripDuringClose trips breakers while they close, which must
+// neither start a probe Close does not wait for nor race on its WaitGroup.
+func TestBreakerTripDuringClose(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		b := store.NewBreaker(newFlaky(true), store.BreakerOptions{ProbeInterval: time.Millisecond})
+		tripped := make(chan struct{})
+		go func() {
+			defer close(tripped)
+			b.Trip(errDown)
+		}()
+		if err := b.Close(); err != nil {
+			t.Fatal(err)
+		}
+		<-tripped
+		// Tripping a closed breaker starts nothing.
+		b.Trip(errDown)
+	}
+}
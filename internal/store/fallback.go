@@ -0,0 +1,171 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"janus/internal/types"
+)
+
+// Fallback keeps a store usable while its primary backend is down: any
+// call the primary fails is served by Local instead. Reads that miss in
+// the primary also try Local, so challenges, fingerprints and sessions
+// written during an outage keep working after the primary is back, on the
+// replica that wrote them.
+type Fallback struct {
+	Store
+	Local *Memory
+	// OnFallback, if set, is called with each error that sent a call to
+	// Local.
+	OnFallback func(err error)
+}
+
+// failed reports whether err should send a call to Local. Missing keys are
+// answers, and a caller that gave up wants no answer at all.
+func (f *Fallback) failed(ctx context.Context, err error) bool {
+	if err == nil || errors.Is(err, ErrNotFound) || ctx.Err() != nil {
+		return false
+	}
+	if f.OnFallback != nil {
+		f.OnFallback(err)
+	}
+	return true
+}
+
+// either runs fn on the primary and, if that fails, on Local.
+func either[T any](ctx context.Context, f *Fallback, fn func(Store) (T, error)) (T, error) {
+	v, err := fn(f.Store)
+	if f.failed(ctx, err) {
+		return fn(f.Local)
+	}
+	return v, err
+}
+
+// lookup is either for reads: a key the primary lacks may be in Local.
+func lookup[T any](ctx context.Context, f *Fallback, fn func(Store) (T, error)) (T, error) {
+	v, err := fn(f.Store)
+	if errors.Is(err, ErrNotFound) || f.failed(ctx, err) {
+		return fn(f.Local)
+	}
+	return v, err
+}
+
+func (f *Fallback) GetSession(ctx context.Context, token string) (*Session, error) {
+	return lookup(ctx, f, func(s Store) (*Session, error) { return s.GetSession(ctx, token) })
+}
+
+func (f *Fallback) SetSession(ctx context.Context, token string, session *Session, ttl time.Duration) error {
+	_, err := either(ctx, f, func(s Store) (struct{}, error) { return struct{}{}, s.SetSession(ctx, token, session, ttl) })
+	return err
+}
+
+// UpdateSession returns fn's own errors as they are, without trying Local.
+func (f *Fallback) UpdateSession(ctx context.Context, token string, ttl time.Duration, fn func(*Session) error) (*Session, error) {
+	var fnErr error
+	wrapped := func(s *Session) error {
+		fnErr = fn(s)
+		return fnErr
+	}
+	session, err := f.Store.UpdateSession(ctx, token, ttl, wrapped)
+	if err == nil || (fnErr != nil && err == fnErr) {
+		return session, err
+	}
+	if errors.Is(err, ErrNotFound) || f.failed(ctx, err) {
+		return f.Local.UpdateSession(ctx, token, ttl, fn)
+	}
+	return nil, err
+}
+
+// DeleteSession deletes the session from both stores.
+func (f *Fallback) DeleteSession(ctx context.Context, token string) error {
+	err := f.Store.DeleteSession(ctx, token)
+	lerr := f.Local.DeleteSession(ctx, token)
+	if f.failed(ctx, err) {
+		return lerr
+	}
+	return err
+}
+
+func (f *Fallback) CreateNonce(ctx context.Context, ttl time.Duration) (string, error) {
+	return either(ctx, f, func(s Store) (string, error) { return s.CreateNonce(ctx, ttl) })
+}
+
+func (f *Fallback) ValidateNonce(ctx context.Context, nonce string) (bool, error) {
+	ok, err := f.Store.ValidateNonce(ctx, nonce)
+	if (err == nil && !ok) || f.failed(ctx, err) {
+		return f.Local.ValidateNonce(ctx, nonce)
+	}
+	return ok, err
+}
+
+func (f *Fallback) PutChallenge(ctx context.Context, key string, c *types.Challenge, ttl time.Duration) error {
+	_, err := either(ctx, f, func(s Store) (struct{}, error) { return struct{}{}, s.PutChallenge(ctx, key, c, ttl) })
+	return err
+}
+
+func (f *Fallback) TakeChallenge(ctx context.Context, key string) (*types.Challenge, error) {
+	return lookup(ctx, f, func(s Store) (*types.Challenge, error) { return s.TakeChallenge(ctx, key) })
+}
+
+func (f *Fallback) PutFingerprint(ctx context.Context, id string, fp *types.Fingerprint, ttl time.Duration) error {
+	_, err := either(ctx, f, func(s Store) (struct{}, error) { return struct{}{}, s.PutFingerprint(ctx, id, fp, ttl) })
+	return err
+}
+
+func (f *Fallback) GetFingerprint(ctx context.Context, id string) (*types.Fingerprint, error) {
+	return lookup(ctx, f, func(s Store) (*types.Fingerprint, error) { return s.GetFingerprint(ctx, id) })
+}
+
+func (f *Fallback) Incr(ctx context.Context, key string, window time.Duration) (int64, error) {
+	return either(ctx, f, func(s Store) (int64, error) { return s.Incr(ctx, key, window) })
+}
+
+func (f *Fallback) AllowRates(ctx context.Context, keys []RateKey) ([]RateResult, error) {
+	return either(ctx, f, func(s Store) ([]RateResult, error) { return s.AllowRates(ctx, keys) })
+}
+
+func (f *Fallback) GetReputation(ctx context.Context, key string) (int, error) {
+	return either(ctx, f, func(s Store) (int, error) { return s.GetReputation(ctx, key) })
+}
+
+func (f *Fallback) AddReputation(ctx context.Context, key string, delta int, ttl time.Duration) (int, error) {
+	return either(ctx, f, func(s Store) (int, error) { return s.AddReputation(ctx, key, delta, ttl) })
+}
+
+func (f *Fallback) Revoke(ctx context.Context, scope string, at time.Time, ttl time.Duration) error {
+	_, err := either(ctx, f, func(s Store) (struct{}, error) { return struct{}{}, s.Revoke(ctx, scope, at, ttl) })
+	return err
+}
+
+// RevokedAt also counts revocations made locally during an outage.
+func (f *Fallback) RevokedAt(ctx context.Context, scopes ...string) (time.Time, error) {
+	at, err := f.Store.RevokedAt(ctx, scopes...)
+	if err != nil && !f.failed(ctx, err) {
+		return at, err
+	}
+	local, lerr := f.Local.RevokedAt(ctx, scopes...)
+	if err != nil {
+		return local, lerr
+	}
+	if local.After(at) {
+		at = local
+	}
+	return at, nil
+}
+
+// Sweep drops expired entries from Local and, if it sweeps, the primary.
+func (f *Fallback) Sweep(ctx context.Context) error {
+	if err := f.Local.Sweep(ctx); err != nil {
+		return err
+	}
+	if sw, ok := f.Store.(Sweeper); ok {
+		return sw.Sweep(ctx)
+	}
+	return nil
+}
+
+func (f *Fallback) Close() error {
+	f.Local.Close()
+	return f.Store.Close()
+}
@@ -0,0 +1,69 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"janus/internal/store"
+	"janus/internal/store/storetest"
+	"janus/internal/types"
+)
+
+// TestFallback runs the suite with the primary down throughout, so every
+// call is served by Local.
+func TestFallback(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		b := store.NewBreaker(newFlaky(true), store.BreakerOptions{ProbeInterval: time.Hour})
+		b.Trip(errDown)
+		return &store.Fallback{Store: b, Local: store.NewMemory(0)}
+	})
+}
+
+func TestFallbackAfterRecovery(t *testing.T) {
+	ctx := context.Background()
+	f := newFlaky(true)
+	b := store.NewBreaker(f, store.BreakerOptions{ProbeInterval: 10 * time.Millisecond})
+	fallbacks := 0
+	s := &store.Fallback{Store: b, Local: store.NewMemory(0), OnFallback: func(error) { fallbacks++ }}
+	defer s.Close()
+
+	b.Trip(errDown)
+	if err := s.SetSession(ctx, "tok", &store.Session{PagesViewed: 1}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutChallenge(ctx, "sid", &types.Challenge{Nonce: "c"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	nonce, err := s.CreateNonce(ctx, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fallbacks != 3 {
+		t.Errorf("OnFallback calls = %d, want 3", fallbacks)
+	}
+
+	// State written during the outage is still found once Redis is back.
+	f.down.Store(false)
+	waitHealthy(t, b)
+	session, err := s.UpdateSession(ctx, "tok", time.Minute, func(s *store.Session) error {
+		s.PagesViewed++
+		return nil
+	})
+	if err != nil || session.PagesViewed != 2 {
+		t.Fatalf("UpdateSession after recovery = %+v, %v", session, err)
+	}
+	if c, err := s.TakeChallenge(ctx, "sid"); err != nil || c.Nonce != "c" {
+		t.Fatalf("TakeChallenge after recovery = %+v, %v", c, err)
+	}
+	if ok, err := s.ValidateNonce(ctx, nonce); err != nil || !ok {
+		t.Fatalf("ValidateNonce after recovery = %v, %v", ok, err)
+	}
+	// New state goes to Redis again.
+	if err := s.SetSession(ctx, "new", &store.Session{}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.GetSession(ctx, "new"); err != nil {
+		t.Errorf("session written after recovery not in the primary: %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package store
+
+import "time"
+
+// gcra applies one request at now to a bucket whose theoretical arrival
+// time is tat. It returns the outcome and the new tat, which is unchanged
+// when the request is refused. Backends without scripting share it; the
+// Redis backend runs the same steps in Lua.
+func gcra(tat, now time.Time, rate Rate) (RateResult, time.Time) {
+	interval := rate.Interval()
+	capacity := interval * time.Duration(rate.Burst+1)
+	if tat.Before(now) {
+		tat = now
+	}
+	next := tat.Add(interval)
+	if allowAt := next.Add(-capacity); now.Before(allowAt) {
+		return RateResult{ResetAfter: tat.Sub(now), RetryAfter: allowAt.Sub(now)}, tat
+	}
+	return RateResult{
+		Allowed:    true,
+		Remaining:  int((capacity - next.Sub(now)) / interval),
+		ResetAfter: next.Sub(now),
+	}, next
+}
+
+// gcraAll applies gcra to every key at now, reading stored arrival times
+// with tat. ok reports whether all keys allowed the request, in which case
+// the returned times must be stored; otherwise nothing is.
+func gcraAll(keys []RateKey, now time.Time, tat func(key string) (time.Time, error)) (results []RateResult, tats []time.Time, ok bool, err error) {
+	results = make([]RateResult, len(keys))
+	tats = make([]time.Time, len(keys))
+	ok = true
+	for i, k := range keys {
+		t, err := tat(k.Key)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		results[i], tats[i] = gcra(t, now, k.Rate)
+		ok = ok && results[i].Allowed
+	}
+	return results, tats, ok, nil
+}
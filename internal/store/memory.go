@@ -0,0 +1,362 @@
+package store
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"janus/internal/types"
+
+	"github.com/google/uuid"
+)
+
+type expiring[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// ttlMap is a map whose entries disappear after their deadline. It is not
+// safe for concurrent use; Memory guards it.
+type ttlMap[V any] map[string]expiring[V]
+
+func (m ttlMap[V]) get(key string, now time.Time) (V, bool) {
+	e, ok := m[key]
+	if !ok || now.After(e.expires) {
+		delete(m, key)
+		var zero V
+		return zero, false
+	}
+	return e.value, true
+}
+
+func (m ttlMap[V]) set(key string, v V, expires time.Time) {
+	m[key] = expiring[V]{value: v, expires: expires}
+}
+
+func (m ttlMap[V]) sweep(now time.Time) {
+	for key, e := range m {
+		if now.After(e.expires) {
+			delete(m, key)
+		}
+	}
+}
+
+// Memory is the in-process backend for single-instance deployments.
+// Fingerprints are additionally capped, evicting the least recently used.
+type Memory struct {
+	mu           sync.Mutex
+	sessions     ttlMap[*Session]
+	nonces       ttlMap[struct{}]
+	challenges   ttlMap[*types.Challenge]
+	counters     ttlMap[int64]
+	rates        ttlMap[time.Time]
+	reputation   ttlMap[int]
+	revocations  ttlMap[time.Time]
+	fingerprints *fingerprintLRU
+}
+
+func NewMemory(maxFingerprints int) *Memory {
+	return &Memory{
+		sessions:     make(ttlMap[*Session]),
+		nonces:       make(ttlMap[struct{}]),
+		challenges:   make(ttlMap[*types.Challenge]),
+		counters:     make(ttlMap[int64]),
+		rates:        make(ttlMap[time.Time]),
+		reputation:   make(ttlMap[int]),
+		revocations:  make(ttlMap[time.Time]),
+		fingerprints: newFingerprintLRU(maxFingerprints),
+	}
+}
+
+func (m *Memory) GetSession(ctx context.Context, token string) (*Session, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions.get(token, time.Now())
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *s
+	return &copied, nil
+}
+
+func (m *Memory) SetSession(ctx context.Context, token string, session *Session, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	copied := *session
+	m.sessions.set(token, &copied, time.Now().Add(ttl))
+	return nil
+}
+
+func (m *Memory) UpdateSession(ctx context.Context, token string, ttl time.Duration, fn func(*Session) error) (*Session, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	s, ok := m.sessions.get(token, now)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	updated := *s
+	updated.NavigationPath = append([]string(nil), s.NavigationPath...)
+	if err := fn(&updated); err != nil {
+		return nil, err
+	}
+	stored := updated
+	m.sessions.set(token, &stored, now.Add(ttl))
+	return &updated, nil
+}
+
+func (m *Memory) DeleteSession(ctx context.Context, token string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, token)
+	return nil
+}
+
+func (m *Memory) CreateNonce(ctx context.Context, ttl time.Duration) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	nonce := uuid.New().String()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nonces.set(nonce, struct{}{}, time.Now().Add(ttl))
+	return nonce, nil
+}
+
+func (m *Memory) ValidateNonce(ctx context.Context, nonce string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.nonces.get(nonce, time.Now())
+	delete(m.nonces, nonce)
+	return ok, nil
+}
+
+func (m *Memory) PutChallenge(ctx context.Context, key string, c *types.Challenge, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.challenges.set(key, c, time.Now().Add(ttl))
+	return nil
+}
+
+func (m *Memory) TakeChallenge(ctx context.Context, key string) (*types.Challenge, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.challenges.get(key, time.Now())
+	delete(m.challenges, key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return c, nil
+}
+
+func (m *Memory) PutFingerprint(ctx context.Context, id string, fp *types.Fingerprint, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fingerprints.put(id, fp, time.Now().Add(ttl))
+	return nil
+}
+
+func (m *Memory) GetFingerprint(ctx context.Context, id string) (*types.Fingerprint, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fp, ok := m.fingerprints.get(id, time.Now())
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return fp, nil
+}
+
+func (m *Memory) Incr(ctx context.Context, key string, window time.Duration) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	e, ok := m.counters[key]
+	if !ok || now.After(e.expires) {
+		e = expiring[int64]{expires: now.Add(window)}
+	}
+	e.value++
+	m.counters[key] = e
+	return e.value, nil
+}
+
+func (m *Memory) AllowRates(ctx context.Context, keys []RateKey) ([]RateResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	results, tats, ok, _ := gcraAll(keys, now, func(key string) (time.Time, error) {
+		tat, _ := m.rates.get(key, now)
+		return tat, nil
+	})
+	if ok {
+		for i, k := range keys {
+			m.rates.set(k.Key, tats[i], tats[i])
+		}
+	}
+	return results, nil
+}
+
+func (m *Memory) GetReputation(ctx context.Context, key string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	score, _ := m.reputation.get(key, time.Now())
+	return score, nil
+}
+
+func (m *Memory) AddReputation(ctx context.Context, key string, delta int, ttl time.Duration) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	score, _ := m.reputation.get(key, now)
+	score += delta
+	m.reputation.set(key, score, now.Add(ttl))
+	return score, nil
+}
+
+func (m *Memory) Revoke(ctx context.Context, scope string, at time.Time, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.revocations.set(scope, at, time.Now().Add(ttl))
+	return nil
+}
+
+func (m *Memory) RevokedAt(ctx context.Context, scopes ...string) (time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	var latest time.Time
+	for _, scope := range scopes {
+		if at, ok := m.revocations.get(scope, now); ok && at.After(latest) {
+			latest = at
+		}
+	}
+	return latest, nil
+}
+
+func (m *Memory) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+func (m *Memory) Sweep(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.sessions.sweep(now)
+	m.nonces.sweep(now)
+	m.challenges.sweep(now)
+	m.counters.sweep(now)
+	m.rates.sweep(now)
+	m.reputation.sweep(now)
+	m.revocations.sweep(now)
+	m.fingerprints.sweep(now)
+	return nil
+}
+
+// fingerprintLRU holds at most max fingerprints (unbounded if max <= 0),
+// evicting the least recently used first.
+type fingerprintLRU struct {
+	max   int
+	order *list.List
+	items map[string]*list.Element
+}
+
+type lruEntry struct {
+	id      string
+	fp      *types.Fingerprint
+	expires time.Time
+}
+
+func newFingerprintLRU(max int) *fingerprintLRU {
+	return &fingerprintLRU{max: max, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (l *fingerprintLRU) put(id string, fp *types.Fingerprint, expires time.Time) {
+	entry := &lruEntry{id: id, fp: fp, expires: expires}
+	if el, ok := l.items[id]; ok {
+		el.Value = entry
+		l.order.MoveToFront(el)
+		return
+	}
+	l.items[id] = l.order.PushFront(entry)
+	for l.max > 0 && l.order.Len() > l.max {
+		l.remove(l.order.Back())
+	}
+}
+
+func (l *fingerprintLRU) get(id string, now time.Time) (*types.Fingerprint, bool) {
+	el, ok := l.items[id]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*lruEntry)
+	if now.After(entry.expires) {
+		l.remove(el)
+		return nil, false
+	}
+	l.order.MoveToFront(el)
+	return entry.fp, true
+}
+
+func (l *fingerprintLRU) sweep(now time.Time) {
+	for el := l.order.Back(); el != nil; {
+		prev := el.Prev()
+		if now.After(el.Value.(*lruEntry).expires) {
+			l.remove(el)
+		}
+		el = prev
+	}
+}
+
+func (l *fingerprintLRU) remove(el *list.Element) {
+	l.order.Remove(el)
+	delete(l.items, el.Value.(*lruEntry).id)
+}
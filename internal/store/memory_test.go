@@ -0,0 +1,37 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"janus/internal/store"
+	"janus/internal/store/storetest"
+	"janus/internal/types"
+)
+
+func TestMemory(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store { return store.NewMemory(0) })
+}
+
+func TestMemoryFingerprintLimit(t *testing.T) {
+	ctx := context.Background()
+	m := store.NewMemory(2)
+	for _, id := range []string{"a", "b"} {
+		if err := m.PutFingerprint(ctx, id, &types.Fingerprint{}, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Reading a makes b the least recently used.
+	if _, err := m.GetFingerprint(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.PutFingerprint(ctx, "c", &types.Fingerprint{}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, err := m.GetFingerprint(ctx, id); (err == nil) != want {
+			t.Errorf("fingerprint %s kept = %v, want %v", id, err == nil, want)
+		}
+	}
+}
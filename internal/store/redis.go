@@ -0,0 +1,328 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"janus/internal/types"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// Redis is the shared backend for multi-instance deployments.
+type Redis struct {
+	rdb     *redis.Client
+	timeout time.Duration
+}
+
+// NewRedis connects lazily to addr. Every operation is bounded by timeout
+// (default 500ms) on top of the caller's context.
+func NewRedis(addr string, timeout time.Duration) *Redis {
+	if timeout <= 0 {
+		timeout = 500 * time.Millisecond
+	}
+	rdb := redis.NewClient(&redis.Options{
+		Addr: addr,
+	})
+	return &Redis{rdb: rdb, timeout: timeout}
+}
+
+func (st *Redis) ctx(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, st.timeout)
+}
+
+func (st *Redis) getJSON(ctx context.Context, key string, v interface{}) error {
+	ctx, cancel := st.ctx(ctx)
+	defer cancel()
+	val, err := st.rdb.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(val, v)
+}
+
+func (st *Redis) setJSON(ctx context.Context, key string, v interface{}, ttl time.Duration) error {
+	val, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := st.ctx(ctx)
+	defer cancel()
+	return st.rdb.Set(ctx, key, val, ttl).Err()
+}
+
+func (st *Redis) GetSession(ctx context.Context, token string) (*Session, error) {
+	var session Session
+	if err := st.getJSON(ctx, "session:"+token, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (st *Redis) SetSession(ctx context.Context, token string, session *Session, ttl time.Duration) error {
+	return st.setJSON(ctx, "session:"+token, session, ttl)
+}
+
+// sessionRetries bounds how often UpdateSession starts over after another
+// client changed the session between its read and its write.
+const sessionRetries = 50
+
+func (st *Redis) UpdateSession(ctx context.Context, token string, ttl time.Duration, fn func(*Session) error) (*Session, error) {
+	ctx, cancel := st.ctx(ctx)
+	defer cancel()
+	key := "session:" + token
+	var session *Session
+	update := func(tx *redis.Tx) error {
+		val, err := tx.Get(ctx, key).Bytes()
+		if err == redis.Nil {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		session = &Session{}
+		if err := json.Unmarshal(val, session); err != nil {
+			return err
+		}
+		if err := fn(session); err != nil {
+			return err
+		}
+		val, err = json.Marshal(session)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, val, ttl)
+			return nil
+		})
+		return err
+	}
+	for i := 0; i < sessionRetries; i++ {
+		err := st.rdb.Watch(ctx, update, key)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return session, nil
+	}
+	return nil, fmt.Errorf("store: session %s changed %d times during update", token, sessionRetries)
+}
+
+func (st *Redis) DeleteSession(ctx context.Context, token string) error {
+	ctx, cancel := st.ctx(ctx)
+	defer cancel()
+	return st.rdb.Del(ctx, "session:"+token).Err()
+}
+
+func (st *Redis) CreateNonce(ctx context.Context, ttl time.Duration) (string, error) {
+	nonce := uuid.New().String()
+	ctx, cancel := st.ctx(ctx)
+	defer cancel()
+	err := st.rdb.Set(ctx, "nonce:"+nonce, "valid", ttl).Err()
+	return nonce, err
+}
+
+func (st *Redis) ValidateNonce(ctx context.Context, nonce string) (bool, error) {
+	ctx, cancel := st.ctx(ctx)
+	defer cancel()
+	err := st.rdb.GetDel(ctx, "nonce:"+nonce).Err()
+	if err == redis.Nil {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (st *Redis) PutChallenge(ctx context.Context, key string, c *types.Challenge, ttl time.Duration) error {
+	return st.setJSON(ctx, "challenge:"+key, c, ttl)
+}
+
+func (st *Redis) TakeChallenge(ctx context.Context, key string) (*types.Challenge, error) {
+	ctx, cancel := st.ctx(ctx)
+	defer cancel()
+	val, err := st.rdb.GetDel(ctx, "challenge:"+key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c types.Challenge
+	if err := json.Unmarshal(val, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (st *Redis) PutFingerprint(ctx context.Context, id string, fp *types.Fingerprint, ttl time.Duration) error {
+	return st.setJSON(ctx, "fingerprint:"+id, fp, ttl)
+}
+
+func (st *Redis) GetFingerprint(ctx context.Context, id string) (*types.Fingerprint, error) {
+	var fp types.Fingerprint
+	if err := st.getJSON(ctx, "fingerprint:"+id, &fp); err != nil {
+		return nil, err
+	}
+	return &fp, nil
+}
+
+func (st *Redis) Incr(ctx context.Context, key string, window time.Duration) (int64, error) {
+	ctx, cancel := st.ctx(ctx)
+	defer cancel()
+	key = "ratelimit:" + key
+	pipe := st.rdb.Pipeline()
+	count := pipe.Incr(ctx, key)
+	// go-redis v8 has no PExpireNX; milliseconds keep sub-second windows.
+	pipe.Do(ctx, "PEXPIRE", key, window.Milliseconds(), "NX")
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return count.Val(), nil
+}
+
+// gcraScript is gcraAll in Lua, on microseconds of the Redis server's
+// clock so replicas agree on time. ARGV holds an interval and capacity per
+// key; it returns allowed (0/1), remaining, reset after and retry after
+// for each key in turn.
+var gcraScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local out, tats, ok = {}, {}, true
+for i, key in ipairs(KEYS) do
+	local interval = tonumber(ARGV[2 * i - 1])
+	local capacity = tonumber(ARGV[2 * i])
+	local tat = tonumber(redis.call('GET', key) or now)
+	if tat < now then
+		tat = now
+	end
+	local next = tat + interval
+	local allow_at = next - capacity
+	if now < allow_at then
+		ok = false
+		table.insert(out, 0)
+		table.insert(out, 0)
+		table.insert(out, tat - now)
+		table.insert(out, allow_at - now)
+	else
+		tats[i] = next
+		table.insert(out, 1)
+		table.insert(out, math.floor((capacity - (next - now)) / interval))
+		table.insert(out, next - now)
+		table.insert(out, 0)
+	end
+end
+if ok then
+	for i, key in ipairs(KEYS) do
+		redis.call('SET', key, string.format('%.0f', tats[i]), 'PX', math.ceil((tats[i] - now) / 1000))
+	end
+end
+return out
+`)
+
+func (st *Redis) AllowRates(ctx context.Context, keys []RateKey) ([]RateResult, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	names := make([]string, len(keys))
+	args := make([]interface{}, 0, 2*len(keys))
+	for i, k := range keys {
+		names[i] = "rate:" + k.Key
+		interval := k.Rate.Interval().Microseconds()
+		args = append(args, interval, interval*int64(k.Rate.Burst+1))
+	}
+	ctx, cancel := st.ctx(ctx)
+	defer cancel()
+	vals, err := gcraScript.Run(ctx, st.rdb, names, args...).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	results := make([]RateResult, len(keys))
+	for i := range results {
+		v := vals[4*i : 4*i+4]
+		results[i] = RateResult{
+			Allowed:    v[0] == 1,
+			Remaining:  int(v[1]),
+			ResetAfter: time.Duration(v[2]) * time.Microsecond,
+			RetryAfter: time.Duration(v[3]) * time.Microsecond,
+		}
+	}
+	return results, nil
+}
+
+func (st *Redis) GetReputation(ctx context.Context, key string) (int, error) {
+	ctx, cancel := st.ctx(ctx)
+	defer cancel()
+	n, err := st.rdb.Get(ctx, "reputation:"+key).Int()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return n, err
+}
+
+func (st *Redis) AddReputation(ctx context.Context, key string, delta int, ttl time.Duration) (int, error) {
+	ctx, cancel := st.ctx(ctx)
+	defer cancel()
+	key = "reputation:" + key
+	pipe := st.rdb.TxPipeline()
+	score := pipe.IncrBy(ctx, key, int64(delta))
+	pipe.PExpire(ctx, key, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return int(score.Val()), nil
+}
+
+func (st *Redis) Revoke(ctx context.Context, scope string, at time.Time, ttl time.Duration) error {
+	ctx, cancel := st.ctx(ctx)
+	defer cancel()
+	return st.rdb.Set(ctx, "revoked:"+scope, at.UnixNano(), ttl).Err()
+}
+
+func (st *Redis) RevokedAt(ctx context.Context, scopes ...string) (time.Time, error) {
+	if len(scopes) == 0 {
+		return time.Time{}, nil
+	}
+	keys := make([]string, len(scopes))
+	for i, scope := range scopes {
+		keys[i] = "revoked:" + scope
+	}
+	ctx, cancel := st.ctx(ctx)
+	defer cancel()
+	vals, err := st.rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return time.Time{}, err
+	}
+	var latest time.Time
+	for _, v := range vals {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if at := time.Unix(0, n); at.After(latest) {
+			latest = at
+		}
+	}
+	return latest, nil
+}
+
+func (st *Redis) Ping(ctx context.Context) error {
+	ctx, cancel := st.ctx(ctx)
+	defer cancel()
+	return st.rdb.Ping(ctx).Err()
+}
+
+func (st *Redis) Close() error {
+	return st.rdb.Close()
+}
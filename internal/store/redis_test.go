@@ -0,0 +1,35 @@
+package store_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"janus/internal/store"
+	"janus/internal/store/storetest"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// TestRedis runs against the server at $JANUS_TEST_REDIS, which it
+// flushes, so point it at a disposable instance.
+func TestRedis(t *testing.T) {
+	addr := os.Getenv("JANUS_TEST_REDIS")
+	if addr == "" {
+		t.Skip("JANUS_TEST_REDIS not set")
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	defer rdb.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		t.Skipf("no Redis at %s: %v", addr, err)
+	}
+	storetest.Run(t, func(t *testing.T) store.Store {
+		if err := rdb.FlushDB(context.Background()).Err(); err != nil {
+			t.Fatalf("FlushDB: %v", err)
+		}
+		return store.NewRedis(addr, time.Second)
+	})
+}
@@ -2,14 +2,15 @@ package store
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 
-	"github.com/go-redis/redis/v8"
-	"github.com/google/uuid"
+	"janus/internal/types"
 )
 
-var ctx = context.Background()
+// ErrNotFound is returned when a key is missing or expired.
+var ErrNotFound = errors.New("store: not found")
 
 type Session struct {
 	VerifiedAt              time.Time `json:"verifiedAt"`
@@ -18,70 +19,151 @@ type Session struct {
 	HasNaturalMouseMovement bool      `json:"hasNaturalMouseMovement"`
 	PagesViewed             int       `json:"pagesViewed"`
 	NavigationPath          []string  `json:"navigationPath"`
+	// Revoked sessions no longer vouch for their token.
+	Revoked bool `json:"revoked,omitempty"`
+	// Device hashes the verifying browser's canvas and WebGL fingerprint
+	// for token binding.
+	Device string `json:"device,omitempty"`
 }
 
-type Store struct {
-	rdb *redis.Client
+// Sessions keeps verified visitors' sessions. UpdateSession reads,
+// changes and saves a session atomically, so concurrent updates from
+// several requests or replicas are not lost: fn may run more than once and
+// should only change the session it is given. If fn fails nothing is
+// saved and its error is returned.
+type Sessions interface {
+	GetSession(ctx context.Context, token string) (*Session, error)
+	SetSession(ctx context.Context, token string, session *Session, ttl time.Duration) error
+	UpdateSession(ctx context.Context, token string, ttl time.Duration, fn func(*Session) error) (*Session, error)
+	DeleteSession(ctx context.Context, token string) error
 }
 
-func New(redisAddr string) *Store {
-	rdb := redis.NewClient(&redis.Options{
-		Addr: redisAddr,
-	})
-	return &Store{rdb: rdb}
+// Nonces issues single-use random tokens. ValidateNonce consumes the nonce
+// and reports whether it existed.
+type Nonces interface {
+	CreateNonce(ctx context.Context, ttl time.Duration) (string, error)
+	ValidateNonce(ctx context.Context, nonce string) (bool, error)
 }
 
-func (st *Store) GetSession(token string) (*Session, bool) {
-	key := "session:" + token
-	val, err := st.rdb.Get(ctx, key).Bytes()
-	if err != nil {
-		return nil, false
-	}
-	var session Session
-	if err := json.Unmarshal(val, &session); err != nil {
-		return nil, false
-	}
-	return &session, true
+// Challenges keeps issued challenges until they are verified or expire.
+// TakeChallenge removes the challenge atomically, so each one can be
+// verified at most once, even across replicas.
+type Challenges interface {
+	PutChallenge(ctx context.Context, key string, c *types.Challenge, ttl time.Duration) error
+	TakeChallenge(ctx context.Context, key string) (*types.Challenge, error)
 }
 
-func (st *Store) SetSession(token string, session *Session, timeout time.Duration) error {
-	key := "session:" + token
-	val, err := json.Marshal(session)
-	if err != nil {
-		return err
-	}
-	return st.rdb.Set(ctx, key, val, timeout).Err()
+// Fingerprints keeps browser fingerprints per visitor session for the
+// length of a challenge.
+type Fingerprints interface {
+	PutFingerprint(ctx context.Context, id string, fp *types.Fingerprint, ttl time.Duration) error
+	GetFingerprint(ctx context.Context, id string) (*types.Fingerprint, error)
 }
 
-func (st *Store) DeleteSession(token string) error {
-	key := "session:" + token
-	return st.rdb.Del(ctx, key).Err()
+// Counters are fixed-window counters: Incr adds one and returns the new
+// value, starting a window of the given length on the first increment.
+type Counters interface {
+	Incr(ctx context.Context, key string, window time.Duration) (int64, error)
 }
 
-func (st *Store) CreateNonce(ttl time.Duration) (string, error) {
-	nonce := uuid.New().String()
-	key := "nonce:" + nonce
-	err := st.rdb.Set(ctx, key, "valid", ttl).Err()
-	return nonce, err
+// Rate is a GCRA limit: Limit requests per Period on average, of which up
+// to Burst beyond the first may arrive at once.
+type Rate struct {
+	Limit  int
+	Period time.Duration
+	Burst  int
 }
 
-func (st *Store) ValidateNonce(nonce string) bool {
-	key := "nonce:" + nonce
-	err := st.rdb.GetDel(ctx, key).Err()
-	return err == nil
+// Interval is the time one request uses up.
+func (r Rate) Interval() time.Duration {
+	return r.Period / time.Duration(r.Limit)
 }
 
-func (st *Store) IsRateLimited(identifier string, limit int) (bool, error) {
-	key := "ratelimit:" + identifier
+// RateResult is the outcome of one request against a Rate. Remaining is
+// how many more requests would be allowed right now, ResetAfter when the
+// bucket is full again and RetryAfter, for refused requests, when the next
+// one will be allowed.
+type RateResult struct {
+	Allowed    bool
+	Remaining  int
+	ResetAfter time.Duration
+	RetryAfter time.Duration
+}
 
-	pipe := st.rdb.Pipeline()
-	count := pipe.Incr(ctx, key)
-	pipe.ExpireNX(ctx, key, 1*time.Minute)
+// RateKey is one key a request is counted against.
+type RateKey struct {
+	Key  string
+	Rate Rate
+}
 
-	_, err := pipe.Exec(ctx)
-	if err != nil {
-		return true, err
-	}
+// RateLimits applies GCRA rate limits atomically, so replicas sharing a
+// backend share the limit. AllowRates checks every key in one step and
+// returns a result per key; if any key refuses the request, it counts
+// against none of them.
+type RateLimits interface {
+	AllowRates(ctx context.Context, keys []RateKey) ([]RateResult, error)
+}
+
+// Reputation accumulates a score per key (IP, ASN, session). Each update
+// extends the key's lifetime to ttl; missing keys score zero.
+type Reputation interface {
+	GetReputation(ctx context.Context, key string) (int, error)
+	AddReputation(ctx context.Context, key string, delta int, ttl time.Duration) (int, error)
+}
 
-	return count.Val() > int64(limit), nil
+// Revocations keeps revocation cut-offs per scope ("all", "ip:<addr>",
+// "visitor:<id>"): tokens first issued at or before a scope's cut-off are
+// no longer valid. Revoke replaces the cut-off and keeps it for ttl;
+// RevokedAt returns the latest cut-off among scopes in one round trip, or
+// the zero time.
+type Revocations interface {
+	Revoke(ctx context.Context, scope string, at time.Time, ttl time.Duration) error
+	RevokedAt(ctx context.Context, scopes ...string) (time.Time, error)
+}
+
+// Store is the full set a backend provides.
+type Store interface {
+	Sessions
+	Nonces
+	Challenges
+	Fingerprints
+	Counters
+	RateLimits
+	Reputation
+	Revocations
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// Sweeper is implemented by backends without native expiry; Sweep drops
+// expired entries and should be called periodically.
+type Sweeper interface {
+	Sweep(ctx context.Context) error
+}
+
+// Options configures Open.
+type Options struct {
+	// Backend is "redis", "memory" or "bolt".
+	Backend   string
+	RedisAddr string
+	// Path is the database file for the bolt backend.
+	Path string
+	// Timeout bounds each operation against a network backend.
+	Timeout time.Duration
+	// MaxFingerprints caps the memory backend's fingerprint entries.
+	MaxFingerprints int
+}
+
+// Open creates the backend selected by opts.Backend.
+func Open(opts Options) (Store, error) {
+	switch opts.Backend {
+	case "", "redis":
+		return NewRedis(opts.RedisAddr, opts.Timeout), nil
+	case "memory":
+		return NewMemory(opts.MaxFingerprints), nil
+	case "bolt":
+		return OpenBolt(opts.Path)
+	default:
+		return nil, fmt.Errorf("store: unknown backend %q", opts.Backend)
+	}
 }
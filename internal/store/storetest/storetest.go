@@ -0,0 +1,398 @@
+// Package storetest is the conformance suite every store backend must pass.
+// Call Run from a backend's tests with a constructor returning a fresh,
+// empty store.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"janus/internal/store"
+	"janus/internal/types"
+)
+
+// shortTTL is long enough to survive a round trip and short enough to wait
+// out in expiry checks.
+const shortTTL = 150 * time.Millisecond
+
+// Run exercises every store.Store method against stores built by open.
+func Run(t *testing.T, open func(t *testing.T) store.Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s store.Store)
+	}{
+		{"Sessions", testSessions},
+		{"UpdateSession", testUpdateSession},
+		{"ConcurrentSessionUpdates", testConcurrentSessionUpdates},
+		{"Nonces", testNonces},
+		{"Challenges", testChallenges},
+		{"ChallengeTakenOnce", testChallengeTakenOnce},
+		{"Fingerprints", testFingerprints},
+		{"Counters", testCounters},
+		{"RateLimits", testRateLimits},
+		{"Reputation", testReputation},
+		{"Revocations", testRevocations},
+		{"Expiry", testExpiry},
+		{"CanceledContext", testCanceledContext},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := open(t)
+			t.Cleanup(func() { s.Close() })
+			tt.fn(t, s)
+		})
+	}
+}
+
+func testSessions(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	if _, err := s.GetSession(ctx, "missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("GetSession(missing) error = %v, want ErrNotFound", err)
+	}
+	want := &store.Session{PagesViewed: 3, HasScrolled: true, NavigationPath: []string{"/", "/a"}}
+	if err := s.SetSession(ctx, "tok", want, time.Minute); err != nil {
+		t.Fatalf("SetSession: %v", err)
+	}
+	got, err := s.GetSession(ctx, "tok")
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if got.PagesViewed != 3 || !got.HasScrolled || len(got.NavigationPath) != 2 {
+		t.Fatalf("GetSession = %+v, want %+v", got, want)
+	}
+	if err := s.DeleteSession(ctx, "tok"); err != nil {
+		t.Fatalf("DeleteSession: %v", err)
+	}
+	if _, err := s.GetSession(ctx, "tok"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("GetSession after delete error = %v, want ErrNotFound", err)
+	}
+}
+
+func testUpdateSession(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	inc := func(session *store.Session) error {
+		session.PagesViewed++
+		session.NavigationPath = append(session.NavigationPath, "/next")
+		return nil
+	}
+	if _, err := s.UpdateSession(ctx, "missing", time.Minute, inc); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("UpdateSession(missing) error = %v, want ErrNotFound", err)
+	}
+	mustNil(t, s.SetSession(ctx, "tok", &store.Session{PagesViewed: 1, NavigationPath: []string{"/"}}, time.Minute))
+	got, err := s.UpdateSession(ctx, "tok", time.Minute, inc)
+	if err != nil {
+		t.Fatalf("UpdateSession: %v", err)
+	}
+	if got.PagesViewed != 2 || len(got.NavigationPath) != 2 {
+		t.Fatalf("UpdateSession = %+v, want 2 pages", got)
+	}
+	if stored, _ := s.GetSession(ctx, "tok"); stored == nil || stored.PagesViewed != 2 {
+		t.Fatalf("stored session = %+v, want 2 pages", stored)
+	}
+
+	refused := errors.New("refused")
+	_, err = s.UpdateSession(ctx, "tok", time.Minute, func(session *store.Session) error {
+		session.PagesViewed = 100
+		return refused
+	})
+	if !errors.Is(err, refused) {
+		t.Fatalf("UpdateSession error = %v, want fn's error", err)
+	}
+	if stored, _ := s.GetSession(ctx, "tok"); stored == nil || stored.PagesViewed != 2 {
+		t.Fatalf("session after refused update = %+v, want 2 pages", stored)
+	}
+}
+
+// testConcurrentSessionUpdates checks that no update is lost when many
+// requests change one session at once.
+func testConcurrentSessionUpdates(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	mustNil(t, s.SetSession(ctx, "tok", &store.Session{}, time.Minute))
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := s.UpdateSession(ctx, "tok", time.Minute, func(session *store.Session) error {
+				session.PagesViewed++
+				if i == 0 {
+					session.HasScrolled = true
+				}
+				return nil
+			})
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		mustNil(t, err)
+	}
+	got, err := s.GetSession(ctx, "tok")
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if got.PagesViewed != n || !got.HasScrolled {
+		t.Fatalf("after %d concurrent updates: %+v", n, got)
+	}
+}
+
+func testNonces(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	a, err := s.CreateNonce(ctx, time.Minute)
+	if err != nil {
+		t.Fatalf("CreateNonce: %v", err)
+	}
+	b, _ := s.CreateNonce(ctx, time.Minute)
+	if a == "" || a == b {
+		t.Fatalf("CreateNonce returned %q and %q, want distinct non-empty nonces", a, b)
+	}
+	if ok, err := s.ValidateNonce(ctx, a); err != nil || !ok {
+		t.Fatalf("ValidateNonce first use = %v, %v, want true", ok, err)
+	}
+	if ok, err := s.ValidateNonce(ctx, a); err != nil || ok {
+		t.Fatalf("ValidateNonce reuse = %v, %v, want false", ok, err)
+	}
+	if ok, err := s.ValidateNonce(ctx, "never-issued"); err != nil || ok {
+		t.Fatalf("ValidateNonce unknown = %v, %v, want false", ok, err)
+	}
+}
+
+func testChallenges(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	want := &types.Challenge{Nonce: "n", Iterations: 10, Seed: "seed", Type: "image", Difficulty: 4,
+		Prompt: "Type the characters shown in the picture.", Answer: "ACDEF"}
+	if err := s.PutChallenge(ctx, "k", want, time.Minute); err != nil {
+		t.Fatalf("PutChallenge: %v", err)
+	}
+	got, err := s.TakeChallenge(ctx, "k")
+	if err != nil {
+		t.Fatalf("TakeChallenge: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("TakeChallenge = %+v, want %+v", got, want)
+	}
+	if _, err := s.TakeChallenge(ctx, "k"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("second TakeChallenge error = %v, want ErrNotFound", err)
+	}
+}
+
+// testChallengeTakenOnce races concurrent takers; exactly one may win.
+func testChallengeTakenOnce(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	if err := s.PutChallenge(ctx, "race", &types.Challenge{Nonce: "race"}, time.Minute); err != nil {
+		t.Fatalf("PutChallenge: %v", err)
+	}
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		wins int
+	)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.TakeChallenge(ctx, "race"); err == nil {
+				mu.Lock()
+				wins++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if wins != 1 {
+		t.Fatalf("%d concurrent TakeChallenge calls succeeded, want 1", wins)
+	}
+}
+
+func testFingerprints(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	if _, err := s.GetFingerprint(ctx, "missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("GetFingerprint(missing) error = %v, want ErrNotFound", err)
+	}
+	fp := &types.Fingerprint{ClientIP: "192.0.2.1", CanvasHash: "abc", IsMobile: true}
+	if err := s.PutFingerprint(ctx, "sid", fp, time.Minute); err != nil {
+		t.Fatalf("PutFingerprint: %v", err)
+	}
+	got, err := s.GetFingerprint(ctx, "sid")
+	if err != nil {
+		t.Fatalf("GetFingerprint: %v", err)
+	}
+	if got.CanvasHash != "abc" || !got.IsMobile || got.ClientIP != "192.0.2.1" {
+		t.Fatalf("GetFingerprint = %+v, want %+v", got, fp)
+	}
+	// Fingerprints are read repeatedly during a challenge.
+	if _, err := s.GetFingerprint(ctx, "sid"); err != nil {
+		t.Fatalf("second GetFingerprint: %v", err)
+	}
+}
+
+func testCounters(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	for i := int64(1); i <= 3; i++ {
+		n, err := s.Incr(ctx, "c", time.Minute)
+		if err != nil {
+			t.Fatalf("Incr: %v", err)
+		}
+		if n != i {
+			t.Fatalf("Incr #%d = %d", i, n)
+		}
+	}
+	if n, _ := s.Incr(ctx, "other", time.Minute); n != 1 {
+		t.Fatalf("Incr(other) = %d, want 1", n)
+	}
+}
+
+func testRateLimits(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	rate := store.Rate{Limit: 10, Period: time.Minute, Burst: 2}
+	allow := func(keys ...string) []store.RateResult {
+		t.Helper()
+		rks := make([]store.RateKey, len(keys))
+		for i, k := range keys {
+			rks[i] = store.RateKey{Key: k, Rate: rate}
+		}
+		res, err := s.AllowRates(ctx, rks)
+		if err != nil {
+			t.Fatalf("AllowRates: %v", err)
+		}
+		if len(res) != len(keys) {
+			t.Fatalf("AllowRates returned %d results for %d keys", len(res), len(keys))
+		}
+		return res
+	}
+	for want := 2; want >= 0; want-- {
+		if res := allow("ip")[0]; !res.Allowed || res.Remaining != want {
+			t.Fatalf("AllowRates = %+v, want allowed with %d remaining", res, want)
+		}
+	}
+	res := allow("ip")[0]
+	// Burst spent: the next request is one interval (6s) away and the
+	// bucket refills in three.
+	if res.Allowed || res.Remaining != 0 {
+		t.Fatalf("AllowRates past burst = %+v, want refused", res)
+	}
+	if res.RetryAfter <= 5*time.Second || res.RetryAfter > 6*time.Second {
+		t.Fatalf("RetryAfter = %v, want about 6s", res.RetryAfter)
+	}
+	if res.ResetAfter <= 17*time.Second || res.ResetAfter > 18*time.Second {
+		t.Fatalf("ResetAfter = %v, want about 18s", res.ResetAfter)
+	}
+	// A refusal on one key means the request counts against no key.
+	if res := allow("other", "ip"); !res[0].Allowed || res[1].Allowed {
+		t.Fatalf("AllowRates(other, ip) = %+v, want other allowed and ip refused", res)
+	}
+	if res := allow("other")[0]; !res.Allowed || res.Remaining != 2 {
+		t.Fatalf("AllowRates(other) = %+v, want allowed with 2 remaining", res)
+	}
+}
+
+func testReputation(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	if n, err := s.GetReputation(ctx, "ip"); err != nil || n != 0 {
+		t.Fatalf("GetReputation(missing) = %d, %v, want 0", n, err)
+	}
+	if n, err := s.AddReputation(ctx, "ip", 10, time.Minute); err != nil || n != 10 {
+		t.Fatalf("AddReputation = %d, %v, want 10", n, err)
+	}
+	if n, _ := s.AddReputation(ctx, "ip", -3, time.Minute); n != 7 {
+		t.Fatalf("AddReputation = %d, want 7", n)
+	}
+	if n, _ := s.GetReputation(ctx, "ip"); n != 7 {
+		t.Fatalf("GetReputation = %d, want 7", n)
+	}
+}
+
+func testRevocations(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	if at, err := s.RevokedAt(ctx, "all", "ip:192.0.2.1"); err != nil || !at.IsZero() {
+		t.Fatalf("RevokedAt(none) = %v, %v, want zero time", at, err)
+	}
+	early := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	late := time.Now().Truncate(time.Millisecond)
+	mustNil(t, s.Revoke(ctx, "ip:192.0.2.1", early, time.Minute))
+	mustNil(t, s.Revoke(ctx, "visitor:v", late, time.Minute))
+	if at, err := s.RevokedAt(ctx, "all", "ip:192.0.2.1"); err != nil || !at.Equal(early) {
+		t.Fatalf("RevokedAt(ip) = %v, %v, want %v", at, err, early)
+	}
+	if at, _ := s.RevokedAt(ctx, "ip:192.0.2.1", "visitor:v"); !at.Equal(late) {
+		t.Fatalf("RevokedAt(ip, visitor) = %v, want latest %v", at, late)
+	}
+	// A later revocation of the same scope replaces the cut-off.
+	mustNil(t, s.Revoke(ctx, "ip:192.0.2.1", late, time.Minute))
+	if at, _ := s.RevokedAt(ctx, "ip:192.0.2.1"); !at.Equal(late) {
+		t.Fatalf("RevokedAt after re-revoke = %v, want %v", at, late)
+	}
+}
+
+func testExpiry(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	nonce, _ := s.CreateNonce(ctx, shortTTL)
+	mustNil(t, s.SetSession(ctx, "tok", &store.Session{}, shortTTL))
+	mustNil(t, s.PutChallenge(ctx, "k", &types.Challenge{}, shortTTL))
+	mustNil(t, s.PutFingerprint(ctx, "sid", &types.Fingerprint{}, shortTTL))
+	if _, err := s.AddReputation(ctx, "ip", 5, shortTTL); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Incr(ctx, "c", shortTTL); err != nil {
+		t.Fatal(err)
+	}
+	mustNil(t, s.Revoke(ctx, "all", time.Now(), shortTTL))
+
+	time.Sleep(2 * shortTTL)
+	if sw, ok := s.(store.Sweeper); ok {
+		mustNil(t, sw.Sweep(ctx))
+	}
+
+	if ok, _ := s.ValidateNonce(ctx, nonce); ok {
+		t.Error("expired nonce validated")
+	}
+	if _, err := s.GetSession(ctx, "tok"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expired session: error = %v, want ErrNotFound", err)
+	}
+	if _, err := s.TakeChallenge(ctx, "k"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expired challenge: error = %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetFingerprint(ctx, "sid"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expired fingerprint: error = %v, want ErrNotFound", err)
+	}
+	if n, _ := s.GetReputation(ctx, "ip"); n != 0 {
+		t.Errorf("expired reputation = %d, want 0", n)
+	}
+	if n, _ := s.Incr(ctx, "c", time.Minute); n != 1 {
+		t.Errorf("Incr after window = %d, want 1", n)
+	}
+	if at, _ := s.RevokedAt(ctx, "all"); !at.IsZero() {
+		t.Errorf("expired revocation = %v, want zero time", at)
+	}
+}
+
+func testCanceledContext(t *testing.T, s store.Store) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	checks := map[string]error{
+		"SetSession":   s.SetSession(ctx, "tok", &store.Session{}, time.Minute),
+		"PutChallenge": s.PutChallenge(ctx, "k", &types.Challenge{}, time.Minute),
+	}
+	_, checks["Incr"] = s.Incr(ctx, "c", time.Minute)
+	_, checks["AllowRates"] = s.AllowRates(ctx, []store.RateKey{{Key: "ip", Rate: store.Rate{Limit: 1, Period: time.Second}}})
+	_, checks["GetFingerprint"] = s.GetFingerprint(ctx, "sid")
+	for name, err := range checks {
+		if err == nil || errors.Is(err, store.ErrNotFound) {
+			t.Errorf("%s with canceled context: error = %v, want context error", name, err)
+		}
+	}
+}
+
+func mustNil(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
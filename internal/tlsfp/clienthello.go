@@ -0,0 +1,222 @@
+package tlsfp
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	recordTypeHandshake      = 0x16
+	handshakeTypeClientHello = 0x01
+
+	extServerName          = 0x0000
+	extSupportedGroups     = 0x000a
+	extECPointFormats      = 0x000b
+	extSignatureAlgorithms = 0x000d
+	extALPN                = 0x0010
+	extSupportedVersions   = 0x002b
+)
+
+var (
+	errIncomplete   = errors.New("tlsfp: incomplete client hello")
+	errNotHandshake = errors.New("tlsfp: not a TLS handshake")
+	errMalformed    = errors.New("tlsfp: malformed client hello")
+)
+
+// ClientHello holds the fields of a TLS ClientHello needed for JA3 and JA4,
+// in wire order and with GREASE values still present.
+type ClientHello struct {
+	Version             uint16
+	CipherSuites        []uint16
+	Extensions          []uint16
+	SupportedGroups     []uint16
+	ECPointFormats      []uint8
+	SignatureAlgorithms []uint16
+	SupportedVersions   []uint16
+	ALPN                []string
+	ServerName          string
+}
+
+// isGREASE reports whether v is one of the RFC 8701 reserved values
+// (0x0a0a, 0x1a1a, ... 0xfafa).
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// parseRecords extracts a ClientHello from the start of a TLS stream. It
+// returns errIncomplete while more bytes are needed.
+func parseRecords(data []byte) (*ClientHello, error) {
+	var msg []byte
+	for {
+		if len(data) < 5 {
+			return nil, errIncomplete
+		}
+		if data[0] != recordTypeHandshake {
+			return nil, errNotHandshake
+		}
+		n := int(binary.BigEndian.Uint16(data[3:5]))
+		if len(data) < 5+n {
+			return nil, errIncomplete
+		}
+		msg = append(msg, data[5:5+n]...)
+		data = data[5+n:]
+
+		if len(msg) >= 4 {
+			if msg[0] != handshakeTypeClientHello {
+				return nil, errNotHandshake
+			}
+			length := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])
+			if len(msg) >= 4+length {
+				return parseClientHello(msg[4 : 4+length])
+			}
+		}
+	}
+}
+
+func parseClientHello(b []byte) (*ClientHello, error) {
+	r := reader(b)
+	ch := &ClientHello{}
+
+	var ok bool
+	if ch.Version, ok = r.u16(); !ok {
+		return nil, errMalformed
+	}
+	if !r.skip(32) {
+		return nil, errMalformed
+	}
+	if _, ok = r.vec8(); !ok {
+		return nil, errMalformed
+	}
+	suites, ok := r.vec16()
+	if !ok {
+		return nil, errMalformed
+	}
+	if ch.CipherSuites, ok = suites.u16s(); !ok {
+		return nil, errMalformed
+	}
+	if _, ok = r.vec8(); !ok {
+		return nil, errMalformed
+	}
+	if len(r) == 0 {
+		return ch, nil
+	}
+
+	exts, ok := r.vec16()
+	if !ok {
+		return nil, errMalformed
+	}
+	for len(exts) > 0 {
+		typ, ok := exts.u16()
+		if !ok {
+			return nil, errMalformed
+		}
+		body, ok := exts.vec16()
+		if !ok {
+			return nil, errMalformed
+		}
+		ch.Extensions = append(ch.Extensions, typ)
+
+		switch typ {
+		case extServerName:
+			list, _ := body.vec16()
+			for len(list) > 0 {
+				nameType, ok := list.u8()
+				if !ok {
+					break
+				}
+				name, ok := list.vec16()
+				if !ok {
+					break
+				}
+				if nameType == 0 {
+					ch.ServerName = string(name)
+				}
+			}
+		case extSupportedGroups:
+			if list, ok := body.vec16(); ok {
+				ch.SupportedGroups, _ = list.u16s()
+			}
+		case extECPointFormats:
+			if list, ok := body.vec8(); ok {
+				ch.ECPointFormats = append([]uint8(nil), list...)
+			}
+		case extSignatureAlgorithms:
+			if list, ok := body.vec16(); ok {
+				ch.SignatureAlgorithms, _ = list.u16s()
+			}
+		case extALPN:
+			list, _ := body.vec16()
+			for len(list) > 0 {
+				proto, ok := list.vec8()
+				if !ok {
+					break
+				}
+				ch.ALPN = append(ch.ALPN, string(proto))
+			}
+		case extSupportedVersions:
+			if list, ok := body.vec8(); ok {
+				ch.SupportedVersions, _ = list.u16s()
+			}
+		}
+	}
+	return ch, nil
+}
+
+type reader []byte
+
+func (r *reader) u8() (uint8, bool) {
+	if len(*r) < 1 {
+		return 0, false
+	}
+	v := (*r)[0]
+	*r = (*r)[1:]
+	return v, true
+}
+
+func (r *reader) u16() (uint16, bool) {
+	if len(*r) < 2 {
+		return 0, false
+	}
+	v := binary.BigEndian.Uint16(*r)
+	*r = (*r)[2:]
+	return v, true
+}
+
+func (r *reader) skip(n int) bool {
+	if len(*r) < n {
+		return false
+	}
+	*r = (*r)[n:]
+	return true
+}
+
+func (r *reader) vec8() (reader, bool) {
+	n, ok := r.u8()
+	if !ok || len(*r) < int(n) {
+		return nil, false
+	}
+	v := (*r)[:n]
+	*r = (*r)[n:]
+	return v, true
+}
+
+func (r *reader) vec16() (reader, bool) {
+	n, ok := r.u16()
+	if !ok || len(*r) < int(n) {
+		return nil, false
+	}
+	v := (*r)[:n]
+	*r = (*r)[n:]
+	return v, true
+}
+
+func (r reader) u16s() ([]uint16, bool) {
+	if len(r)%2 != 0 {
+		return nil, false
+	}
+	out := make([]uint16, 0, len(r)/2)
+	for i := 0; i < len(r); i += 2 {
+		out = append(out, binary.BigEndian.Uint16(r[i:]))
+	}
+	return out, true
+}
@@ -0,0 +1,195 @@
+package tlsfp
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Entry labels a TLS client stack. Kind is "browser" or "tool"; an empty
+// Family on a browser entry means "some browser" and skips family checks.
+type Entry struct {
+	JA3        []string `yaml:"ja3"`
+	JA4        []string `yaml:"ja4"`
+	Kind       string   `yaml:"kind"`
+	Family     string   `yaml:"family"`
+	MinVersion int      `yaml:"min_version"`
+	MaxVersion int      `yaml:"max_version"`
+	OS         []string `yaml:"os"`
+	Note       string   `yaml:"note"`
+}
+
+type dbFile struct {
+	Fingerprints []Entry `yaml:"fingerprints"`
+}
+
+type index struct {
+	ja3 map[string]*Entry
+	ja4 map[string]*Entry
+}
+
+// DB is a labelled fingerprint database loaded from a YAML file. It is
+// safe for concurrent use and can be reloaded while serving.
+type DB struct {
+	path    string
+	idx     atomic.Pointer[index]
+	modTime time.Time
+}
+
+// LoadDB reads the database at path.
+func LoadDB(path string) (*DB, error) {
+	db := &DB{path: path}
+	if err := db.Reload(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Reload re-reads the database file, keeping the previous contents if it
+// cannot be parsed.
+func (db *DB) Reload() error {
+	info, err := os.Stat(db.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(db.path)
+	if err != nil {
+		return err
+	}
+	var f dbFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("tlsfp: parse %s: %w", db.path, err)
+	}
+	idx := &index{ja3: map[string]*Entry{}, ja4: map[string]*Entry{}}
+	for i := range f.Fingerprints {
+		e := &f.Fingerprints[i]
+		e.Kind = strings.ToLower(e.Kind)
+		e.Family = strings.ToLower(e.Family)
+		for _, v := range e.JA3 {
+			idx.ja3[normaliseJA3(v)] = e
+		}
+		for _, v := range e.JA4 {
+			idx.ja4[v] = e
+		}
+	}
+	db.idx.Store(idx)
+	db.modTime = info.ModTime()
+	return nil
+}
+
+// normaliseJA3 accepts either a JA3 hash or a raw JA3 string.
+func normaliseJA3(v string) string {
+	v = strings.TrimSpace(v)
+	if len(v) == 32 && !strings.Contains(v, ",") {
+		return strings.ToLower(v)
+	}
+	sum := md5.Sum([]byte(v))
+	return hex.EncodeToString(sum[:])
+}
+
+// Watch reloads the database whenever the file's modification time
+// changes, until done is closed.
+func (db *DB) Watch(done <-chan struct{}, interval time.Duration, logger *log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(db.path)
+			if err != nil || info.ModTime().Equal(db.modTime) {
+				continue
+			}
+			if err := db.Reload(); err != nil {
+				logger.Printf("Watch: Failed to reload TLS fingerprint database %s: %v", db.path, err)
+				continue
+			}
+			logger.Printf("Watch: Reloaded TLS fingerprint database %s", db.path)
+		}
+	}
+}
+
+// Lookup finds the entry for fp, preferring the JA4 match since JA3 is
+// unstable for browsers that permute extensions.
+func (db *DB) Lookup(fp *Fingerprint) (*Entry, bool) {
+	idx := db.idx.Load()
+	if e, ok := idx.ja4[fp.JA4]; ok {
+		return e, true
+	}
+	if e, ok := idx.ja4[fp.JA4O]; ok {
+		return e, true
+	}
+	if e, ok := idx.ja3[fp.JA3Hash]; ok {
+		return e, true
+	}
+	return nil, false
+}
+
+// Verdict is the outcome of checking a TLS fingerprint against the
+// User-Agent the client sent.
+type Verdict struct {
+	Entry *Entry
+	// Unknown is set when the fingerprint is not in the database.
+	Unknown bool
+	// Automation is set when the fingerprint belongs to a known tool.
+	Automation bool
+	// Mismatch is set when the User-Agent claims a browser the TLS stack
+	// cannot be.
+	Mismatch bool
+	Reason   string
+}
+
+// Check classifies fp and tests it for consistency with ua.
+func (db *DB) Check(fp *Fingerprint, ua string) Verdict {
+	e, ok := db.Lookup(fp)
+	if !ok {
+		return Verdict{Unknown: true, Reason: "fingerprint not in database"}
+	}
+	v := Verdict{Entry: e}
+	claimed := ParseUserAgent(ua)
+
+	if e.Kind == "tool" {
+		v.Automation = true
+		v.Reason = "tls stack is " + e.Family
+		if claimed.Browser {
+			v.Mismatch = true
+			v.Reason = fmt.Sprintf("browser user agent (%s) on %s tls stack", claimed.Family, e.Family)
+		}
+		return v
+	}
+	if !claimed.Browser {
+		return v
+	}
+	if e.Family != "" && e.Family != claimed.Family {
+		v.Mismatch = true
+		v.Reason = fmt.Sprintf("user agent claims %s, tls stack is %s", claimed.Family, e.Family)
+		return v
+	}
+	if claimed.Major > 0 && (e.MinVersion > 0 && claimed.Major < e.MinVersion || e.MaxVersion > 0 && claimed.Major > e.MaxVersion) {
+		v.Mismatch = true
+		v.Reason = fmt.Sprintf("user agent version %d outside %d-%d", claimed.Major, e.MinVersion, e.MaxVersion)
+		return v
+	}
+	if claimed.OS != "" && len(e.OS) > 0 && !containsString(e.OS, claimed.OS) {
+		v.Mismatch = true
+		v.Reason = fmt.Sprintf("user agent os %s not in %v", claimed.OS, e.OS)
+	}
+	return v
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package tlsfp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	chromeUA  = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36"
+	firefoxUA = "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0"
+	safariUA  = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Safari/605.1.15"
+)
+
+func TestShippedDB(t *testing.T) {
+	db, err := LoadDB("../../tls_fingerprints.yaml")
+	if err != nil {
+		t.Fatalf("LoadDB: %v", err)
+	}
+	cases := []struct {
+		name       string
+		fp         Fingerprint
+		ua         string
+		family     string
+		automation bool
+		mismatch   bool
+	}{
+		{"curl", Fingerprint{JA4: "t13d3112h2_e8f1e7e78f70_b26ce05bbdd6"}, "curl/8.5.0", "curl", true, false},
+		{"curl by ja3", Fingerprint{JA3Hash: "0149f47eabf9a20d0893e2a44e5a6323"}, "curl/8.5.0", "curl", true, false},
+		{"curl posing as chrome", Fingerprint{JA4: "t13d3112h1_e8f1e7e78f70_b26ce05bbdd6"}, chromeUA, "curl", true, true},
+		{"python-requests", Fingerprint{JA4: "t13d1812h1_85036bcba153_b26ce05bbdd6"}, "python-requests/2.31.0", "python-requests", true, false},
+		{"python-requests by ja3", Fingerprint{JA3Hash: "a48c0d5f95b1ef98f560f324fd275da1"}, chromeUA, "python-requests", true, true},
+		{"firefox", Fingerprint{JA4: "t13d1715h2_5b57614c22b0_5c2c66f702b0"}, firefoxUA, "firefox", false, false},
+		{"firefox posing as chrome", Fingerprint{JA4: "t13d1715h2_5b57614c22b0_3d5424432f57"}, chromeUA, "firefox", false, true},
+		{"safari", Fingerprint{JA4: "t13d2014h2_a09f3c656075_14788d8d241b"}, safariUA, "safari", false, false},
+		{"safari on windows", Fingerprint{JA3Hash: "773906b0efdefa24a7f2b8eb6985bf37"}, "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Safari/605.1.15", "safari", false, true},
+		{"chrome", Fingerprint{JA4: "t13d1516h2_8daaf6152771_02713d6af862"}, chromeUA, "chromium", false, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			v := db.Check(&c.fp, c.ua)
+			if v.Unknown {
+				t.Fatal("fingerprint not in database")
+			}
+			if v.Entry.Family != c.family || v.Automation != c.automation || v.Mismatch != c.mismatch {
+				t.Errorf("Check = family %q, automation %v, mismatch %v (%s); want %q, %v, %v",
+					v.Entry.Family, v.Automation, v.Mismatch, v.Reason, c.family, c.automation, c.mismatch)
+			}
+		})
+	}
+	if v := db.Check(&Fingerprint{JA4: "t13d0000h2_000000000000_000000000000"}, chromeUA); !v.Unknown {
+		t.Errorf("unlisted fingerprint matched %+v", v.Entry)
+	}
+}
+
+const testDB = `
+fingerprints:
+  - kind: browser
+    family: chromium
+    ja4: [t13d1516h2_aaaaaaaaaaaa_bbbbbbbbbbbb]
+    min_version: 110
+    max_version: 130
+    os: [windows, macos]
+  - kind: browser
+    ja3: ["771,4865-4866,0-23,29-23,0"]
+  - kind: tool
+    family: curl
+    ja4: [t13d3112h2_cccccccccccc_dddddddddddd]
+`
+
+func writeDB(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tls.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCheck(t *testing.T) {
+	db, err := LoadDB(writeDB(t, testDB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chrome := Fingerprint{JA4: "t13d1516h2_aaaaaaaaaaaa_bbbbbbbbbbbb"}
+	cases := []struct {
+		name       string
+		fp         Fingerprint
+		ua         string
+		automation bool
+		mismatch   bool
+	}{
+		{"matching browser", chrome, chromeUA, false, false},
+		{"matched by JA4O", Fingerprint{JA4: "t13d1516h2_000000000000_000000000000", JA4O: chrome.JA4}, chromeUA, false, false},
+		{"other family", chrome, firefoxUA, false, true},
+		{"version too old", chrome, "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/99.0.0.0 Safari/537.36", false, true},
+		{"version too new", chrome, "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36", false, true},
+		{"wrong os", chrome, "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36", false, true},
+		{"non-browser user agent", chrome, "curl/8.5.0", false, false},
+		{"any browser by raw JA3", Fingerprint{JA3Hash: normaliseJA3("771,4865-4866,0-23,29-23,0")}, firefoxUA, false, false},
+		{"tool", Fingerprint{JA4: "t13d3112h2_cccccccccccc_dddddddddddd"}, "curl/8.5.0", true, false},
+		{"tool posing as browser", Fingerprint{JA4: "t13d3112h2_cccccccccccc_dddddddddddd"}, safariUA, true, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			v := db.Check(&c.fp, c.ua)
+			if v.Unknown || v.Automation != c.automation || v.Mismatch != c.mismatch {
+				t.Errorf("Check = unknown %v, automation %v, mismatch %v (%s); want false, %v, %v",
+					v.Unknown, v.Automation, v.Mismatch, v.Reason, c.automation, c.mismatch)
+			}
+		})
+	}
+}
+
+func TestReloadKeepsDBOnError(t *testing.T) {
+	path := writeDB(t, testDB)
+	db, err := LoadDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("fingerprints: [unclosed"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Reload(); err == nil {
+		t.Fatal("Reload accepted a broken file")
+	}
+	if _, ok := db.Lookup(&Fingerprint{JA4: "t13d3112h2_cccccccccccc_dddddddddddd"}); !ok {
+		t.Error("failed reload dropped the previous entries")
+	}
+}
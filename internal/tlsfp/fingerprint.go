@@ -0,0 +1,174 @@
+package tlsfp
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Fingerprint is the set of TLS client fingerprints computed for one
+// connection.
+type Fingerprint struct {
+	// JA3 is the raw JA3 string and JA3Hash its MD5.
+	JA3     string
+	JA3Hash string
+	// JA4 sorts ciphers and extensions; JA4O keeps wire order. JA4R is the
+	// unhashed form of JA4.
+	JA4  string
+	JA4O string
+	JA4R string
+}
+
+// Compute derives JA3 and JA4 fingerprints from ch. GREASE values are
+// ignored throughout.
+func Compute(ch *ClientHello) *Fingerprint {
+	ja3 := ja3String(ch)
+	sum := md5.Sum([]byte(ja3))
+	fp := &Fingerprint{
+		JA3:     ja3,
+		JA3Hash: hex.EncodeToString(sum[:]),
+	}
+	fp.JA4, fp.JA4O, fp.JA4R = ja4(ch)
+	return fp
+}
+
+func ja3String(ch *ClientHello) string {
+	points := make([]uint16, 0, len(ch.ECPointFormats))
+	for _, p := range ch.ECPointFormats {
+		points = append(points, uint16(p))
+	}
+	return strings.Join([]string{
+		strconv.Itoa(int(ch.Version)),
+		joinDecimal(ch.CipherSuites),
+		joinDecimal(ch.Extensions),
+		joinDecimal(ch.SupportedGroups),
+		joinDecimal(points),
+	}, ",")
+}
+
+func joinDecimal(vals []uint16) string {
+	parts := make([]string, 0, len(vals))
+	for _, v := range vals {
+		if isGREASE(v) {
+			continue
+		}
+		parts = append(parts, strconv.Itoa(int(v)))
+	}
+	return strings.Join(parts, "-")
+}
+
+func ja4(ch *ClientHello) (sorted, original, raw string) {
+	ciphers := hexList(ch.CipherSuites)
+	exts := hexList(ch.Extensions)
+	sigs := hexList(ch.SignatureAlgorithms)
+
+	sni := "i"
+	if ch.ServerName != "" || contains(ch.Extensions, extServerName) {
+		sni = "d"
+	}
+	prefix := fmt.Sprintf("t%s%s%02d%02d%s", ja4Version(ch), sni, min(len(ciphers), 99), min(len(exts), 99), ja4ALPN(ch.ALPN))
+
+	sortedCiphers := append([]string(nil), ciphers...)
+	sort.Strings(sortedCiphers)
+	// The sorted variant drops SNI and ALPN since they are already
+	// reflected in the prefix.
+	sortedExts := make([]string, 0, len(exts))
+	for _, e := range exts {
+		if e == "0000" || e == "0010" {
+			continue
+		}
+		sortedExts = append(sortedExts, e)
+	}
+	sort.Strings(sortedExts)
+
+	sortedExtPart := extPart(sortedExts, sigs)
+	origExtPart := extPart(exts, sigs)
+
+	sorted = prefix + "_" + truncHash(strings.Join(sortedCiphers, ",")) + "_" + truncHash(sortedExtPart)
+	original = prefix + "_" + truncHash(strings.Join(ciphers, ",")) + "_" + truncHash(origExtPart)
+	raw = prefix + "_" + strings.Join(sortedCiphers, ",") + "_" + sortedExtPart
+	return sorted, original, raw
+}
+
+func extPart(exts, sigs []string) string {
+	s := strings.Join(exts, ",")
+	if len(sigs) > 0 {
+		s += "_" + strings.Join(sigs, ",")
+	}
+	return s
+}
+
+func ja4Version(ch *ClientHello) string {
+	v := ch.Version
+	var best uint16
+	for _, sv := range ch.SupportedVersions {
+		if !isGREASE(sv) && sv > best {
+			best = sv
+		}
+	}
+	if best != 0 {
+		v = best
+	}
+	switch v {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	}
+	return "00"
+}
+
+func ja4ALPN(protos []string) string {
+	if len(protos) == 0 || protos[0] == "" {
+		return "00"
+	}
+	p := protos[0]
+	first, last := p[0], p[len(p)-1]
+	if isAlnum(first) && isAlnum(last) {
+		return string([]byte{first, last})
+	}
+	h := hex.EncodeToString([]byte(p))
+	return string([]byte{h[0], h[len(h)-1]})
+}
+
+func isAlnum(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func hexList(vals []uint16) []string {
+	out := make([]string, 0, len(vals))
+	for _, v := range vals {
+		if isGREASE(v) {
+			continue
+		}
+		out = append(out, fmt.Sprintf("%04x", v))
+	}
+	return out
+}
+
+func truncHash(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func contains(vals []uint16, v uint16) bool {
+	for _, x := range vals {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+package tlsfp
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// clientHello returns the first flight crypto/tls sends with cfg.
+func clientHello(t *testing.T, cfg *tls.Config) []byte {
+	t.Helper()
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		tls.Client(client, cfg).Handshake()
+		client.Close()
+	}()
+	var data []byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := server.Read(buf)
+		data = append(data, buf[:n]...)
+		if _, perr := parseRecords(data); perr != errIncomplete {
+			return data
+		}
+		if err != nil {
+			t.Fatalf("reading ClientHello: %v", err)
+		}
+	}
+}
+
+func TestParseClientHello(t *testing.T) {
+	data := clientHello(t, &tls.Config{
+		ServerName:   "example.com",
+		NextProtos:   []string{"h2", "http/1.1"},
+		MinVersion:   tls.VersionTLS12,
+		CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+	})
+	ch, err := parseRecords(data)
+	if err != nil {
+		t.Fatalf("parseRecords: %v", err)
+	}
+	if ch.ServerName != "example.com" {
+		t.Errorf("ServerName = %q", ch.ServerName)
+	}
+	if strings.Join(ch.ALPN, ",") != "h2,http/1.1" {
+		t.Errorf("ALPN = %v", ch.ALPN)
+	}
+	if !contains(ch.CipherSuites, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256) {
+		t.Errorf("CipherSuites = %v lack the configured suite", ch.CipherSuites)
+	}
+	if !contains(ch.SupportedVersions, tls.VersionTLS13) {
+		t.Errorf("SupportedVersions = %v lack TLS 1.3", ch.SupportedVersions)
+	}
+
+	fp := Compute(ch)
+	if !strings.HasPrefix(fp.JA4, "t13d") || !strings.Contains(fp.JA4, "h2_") {
+		t.Errorf("JA4 = %q, want t13d...h2_...", fp.JA4)
+	}
+	if !strings.HasPrefix(fp.JA3, "771,") {
+		t.Errorf("JA3 = %q, want legacy version 771 first", fp.JA3)
+	}
+}
+
+func TestConnRecordsAcrossReads(t *testing.T) {
+	data := clientHello(t, &tls.Config{ServerName: "example.com"})
+	c := &Conn{}
+	for i := range data {
+		c.record(data[i : i+1])
+	}
+	if ch := c.ClientHello(); ch == nil || ch.ServerName != "example.com" {
+		t.Fatalf("ClientHello fed a byte at a time = %+v", ch)
+	}
+	// Later application data is not recorded.
+	c.record([]byte{0x17, 0x03, 0x03, 0x00, 0x01, 0x00})
+	if c.buf != nil {
+		t.Error("Conn kept buffering after the ClientHello")
+	}
+}
+
+func TestParseRejectsOtherProtocols(t *testing.T) {
+	if _, err := parseRecords([]byte("GET / HTTP/1.1\r\n")); err != errNotHandshake {
+		t.Errorf("plain HTTP: error = %v, want errNotHandshake", err)
+	}
+	if _, err := parseRecords([]byte{0x16, 0x03, 0x01, 0x00, 0x40, 0x01}); err != errIncomplete {
+		t.Errorf("truncated record: error = %v, want errIncomplete", err)
+	}
+}
+
+// TestJA3 checks the worked example from the JA3 announcement.
+func TestJA3(t *testing.T) {
+	ch := &ClientHello{
+		Version:         769,
+		CipherSuites:    []uint16{47, 53, 5, 10, 49161, 49162, 49171, 49172, 50, 56, 19, 4},
+		Extensions:      []uint16{0x0a0a, 0, 10, 11},
+		SupportedGroups: []uint16{23, 24, 25},
+		ECPointFormats:  []uint8{0},
+	}
+	fp := Compute(ch)
+	if want := "769,47-53-5-10-49161-49162-49171-49172-50-56-19-4,0-10-11,23-24-25,0"; fp.JA3 != want {
+		t.Errorf("JA3 = %q, want %q", fp.JA3, want)
+	}
+	if want := "ada70206e40642a3e4461f35503241d5"; fp.JA3Hash != want {
+		t.Errorf("JA3Hash = %q, want %q", fp.JA3Hash, want)
+	}
+}
+
+// TestJA4 checks the Chrome example from the JA4 specification.
+func TestJA4(t *testing.T) {
+	ch := &ClientHello{
+		Version: 0x0303,
+		CipherSuites: []uint16{0x2a2a, 0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030,
+			0xcca9, 0xcca8, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035},
+		Extensions: []uint16{0x3a3a, 0x0000, 0x0017, 0xff01, 0x000a, 0x000b, 0x0023, 0x0010, 0x0005,
+			0x000d, 0x0012, 0x0033, 0x002d, 0x002b, 0x001b, 0x4469, 0x0015},
+		SignatureAlgorithms: []uint16{0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601},
+		SupportedVersions:   []uint16{0x5a5a, 0x0304, 0x0303},
+		ALPN:                []string{"h2", "http/1.1"},
+		ServerName:          "example.com",
+	}
+	fp := Compute(ch)
+	wantRaw := "t13d1516h2_002f,0035,009c,009d,1301,1302,1303,c013,c014,c02b,c02c,c02f,c030,cca8,cca9_" +
+		"0005,000a,000b,000d,0012,0015,0017,001b,0023,002b,002d,0033,4469,ff01_" +
+		"0403,0804,0401,0503,0805,0501,0806,0601"
+	if fp.JA4R != wantRaw {
+		t.Errorf("JA4R = %q, want %q", fp.JA4R, wantRaw)
+	}
+	if want := "t13d1516h2_8daaf6152771_e5627efa2ab1"; fp.JA4 != want {
+		t.Errorf("JA4 = %q, want %q", fp.JA4, want)
+	}
+	// JA4O hashes the wire order instead.
+	ciphers := "1301,1302,1303,c02b,c02f,c02c,c030,cca9,cca8,c013,c014,009c,009d,002f,0035"
+	sum := sha256.Sum256([]byte(ciphers))
+	if want := "t13d1516h2_" + hex.EncodeToString(sum[:])[:12] + "_"; !strings.HasPrefix(fp.JA4O, want) {
+		t.Errorf("JA4O = %q, want prefix %q", fp.JA4O, want)
+	}
+}
+
+func TestJA4NoSNINoALPN(t *testing.T) {
+	fp := Compute(&ClientHello{Version: 0x0303, CipherSuites: []uint16{0x1301}})
+	if want := "t12i010000_"; !strings.HasPrefix(fp.JA4, want) {
+		t.Errorf("JA4 = %q, want prefix %q", fp.JA4, want)
+	}
+	if !strings.HasSuffix(fp.JA4, "_000000000000") {
+		t.Errorf("JA4 = %q, want an empty extension hash", fp.JA4)
+	}
+}
+
+// TestListener serves HTTPS through NewListener and reads the visitor's
+// fingerprint back from the request context.
+func TestListener(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fp, ok := FromContext(r.Context())
+		if !ok {
+			http.Error(w, "no fingerprint", http.StatusInternalServerError)
+			return
+		}
+		io.WriteString(w, fp.JA4)
+	}))
+	srv.Listener = NewListener(srv.Listener)
+	srv.Config.ConnContext = ConnContext
+	srv.StartTLS()
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(string(body), "t13i") {
+		t.Errorf("response %d %q, want a JA4 for a TLS 1.3 client without SNI", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,130 @@
+package tlsfp
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+)
+
+// maxHelloBytes bounds how much of a connection is buffered while waiting
+// for a complete ClientHello.
+const maxHelloBytes = 64 * 1024
+
+type contextKey struct{}
+
+// Conn records the bytes read from a connection until the TLS ClientHello
+// has been parsed.
+type Conn struct {
+	net.Conn
+
+	mu    sync.Mutex
+	buf   []byte
+	done  bool
+	hello *ClientHello
+	fp    *Fingerprint
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.record(b[:n])
+	}
+	return n, err
+}
+
+func (c *Conn) record(b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done {
+		return
+	}
+	c.buf = append(c.buf, b...)
+	ch, err := parseRecords(c.buf)
+	if err == errIncomplete && len(c.buf) < maxHelloBytes {
+		return
+	}
+	c.done = true
+	c.buf = nil
+	if err == nil {
+		c.hello = ch
+	}
+}
+
+// ClientHello returns the parsed ClientHello, or nil if none was seen.
+func (c *Conn) ClientHello() *ClientHello {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hello
+}
+
+// Fingerprint returns the connection's JA3/JA4, computing it on first use.
+func (c *Conn) Fingerprint() *Fingerprint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fp == nil && c.hello != nil {
+		c.fp = Compute(c.hello)
+	}
+	return c.fp
+}
+
+// NetConn returns the underlying connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.Conn
+}
+
+type listener struct {
+	net.Listener
+}
+
+// NewListener wraps inner so every accepted connection captures its
+// ClientHello. Wrap it with TLS afterwards (e.g. http.Server.ServeTLS).
+func NewListener(inner net.Listener) net.Listener {
+	return &listener{Listener: inner}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: c}, nil
+}
+
+// ConnContext is an http.Server.ConnContext hook that makes the capturing
+// Conn reachable from request contexts via FromContext.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if conn := Unwrap(c); conn != nil {
+		return context.WithValue(ctx, contextKey{}, conn)
+	}
+	return ctx
+}
+
+// Unwrap finds the capturing Conn beneath c, looking through *tls.Conn and
+// any wrapper exposing NetConn.
+func Unwrap(c net.Conn) *Conn {
+	for c != nil {
+		switch v := c.(type) {
+		case *Conn:
+			return v
+		case *tls.Conn:
+			c = v.NetConn()
+		case interface{ NetConn() net.Conn }:
+			c = v.NetConn()
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// FromContext returns the TLS fingerprint of the connection that carried
+// the request, if it was accepted through NewListener.
+func FromContext(ctx context.Context) (*Fingerprint, bool) {
+	conn, ok := ctx.Value(contextKey{}).(*Conn)
+	if !ok {
+		return nil, false
+	}
+	fp := conn.Fingerprint()
+	return fp, fp != nil
+}
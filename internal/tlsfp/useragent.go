@@ -0,0 +1,103 @@
+package tlsfp
+
+import (
+	"strconv"
+	"strings"
+)
+
+// UserAgent is the client a User-Agent header claims to be.
+type UserAgent struct {
+	// Family is the TLS stack the client would use: "chromium", "firefox",
+	// "safari" for browsers, or a tool label such as "curl".
+	Family  string
+	Browser bool
+	Major   int
+	OS      string
+}
+
+var uaTools = []struct{ token, family string }{
+	{"curl/", "curl"},
+	{"wget/", "wget"},
+	{"python-requests/", "python-requests"},
+	{"python-urllib", "python-urllib"},
+	{"aiohttp/", "aiohttp"},
+	{"httpx/", "httpx"},
+	{"go-http-client/", "go-http-client"},
+	{"okhttp/", "okhttp"},
+	{"java/", "java"},
+	{"node-fetch/", "node-fetch"},
+	{"axios/", "axios"},
+}
+
+var uaBrowsers = []struct{ token, family string }{
+	{"edg/", "chromium"},
+	{"opr/", "chromium"},
+	{"crios/", "safari"},
+	{"fxios/", "safari"},
+	{"chrome/", "chromium"},
+	{"firefox/", "firefox"},
+	{"version/", "safari"},
+}
+
+// ParseUserAgent classifies ua by TLS stack, major version and OS. iOS
+// browsers report the Safari stack since they must use WebKit.
+func ParseUserAgent(ua string) UserAgent {
+	lower := strings.ToLower(ua)
+	out := UserAgent{OS: uaOS(lower)}
+
+	for _, t := range uaTools {
+		if strings.Contains(lower, t.token) {
+			out.Family = t.family
+			out.Major = majorAfter(lower, t.token)
+			return out
+		}
+	}
+	for _, b := range uaBrowsers {
+		if !strings.Contains(lower, b.token) {
+			continue
+		}
+		if b.token == "version/" && !strings.Contains(lower, "safari/") {
+			continue
+		}
+		out.Family = b.family
+		out.Browser = true
+		out.Major = majorAfter(lower, b.token)
+		if out.OS == "ios" {
+			out.Family = "safari"
+		}
+		return out
+	}
+	return out
+}
+
+func uaOS(lower string) string {
+	switch {
+	case strings.Contains(lower, "iphone") || strings.Contains(lower, "ipad"):
+		return "ios"
+	case strings.Contains(lower, "android"):
+		return "android"
+	case strings.Contains(lower, "windows"):
+		return "windows"
+	case strings.Contains(lower, "mac os x") || strings.Contains(lower, "macintosh"):
+		return "macos"
+	case strings.Contains(lower, "cros"):
+		return "chromeos"
+	case strings.Contains(lower, "linux"):
+		return "linux"
+	}
+	return ""
+}
+
+func majorAfter(s, token string) int {
+	i := strings.Index(s, token)
+	if i < 0 {
+		return 0
+	}
+	rest := s[i+len(token):]
+	end := 0
+	for end < len(rest) && rest[end] >= '0' && rest[end] <= '9' {
+		end++
+	}
+	n, _ := strconv.Atoi(rest[:end])
+	return n
+}
@@ -0,0 +1,30 @@
+package tlsfp
+
+import "testing"
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		ua   string
+		want UserAgent
+	}{
+		{chromeUA, UserAgent{Family: "chromium", Browser: true, Major: 126, OS: "windows"}},
+		{firefoxUA, UserAgent{Family: "firefox", Browser: true, Major: 128, OS: "linux"}},
+		{safariUA, UserAgent{Family: "safari", Browser: true, Major: 17, OS: "macos"}},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36 Edg/126.0.0.0",
+			UserAgent{Family: "chromium", Browser: true, Major: 126, OS: "windows"}},
+		// Every iOS browser runs on WebKit.
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/126.0.6478.54 Mobile/15E148 Safari/604.1",
+			UserAgent{Family: "safari", Browser: true, Major: 126, OS: "ios"}},
+		{"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Mobile Safari/537.36",
+			UserAgent{Family: "chromium", Browser: true, Major: 126, OS: "android"}},
+		{"curl/8.5.0", UserAgent{Family: "curl", Major: 8}},
+		{"python-requests/2.31.0", UserAgent{Family: "python-requests", Major: 2}},
+		{"Go-http-client/1.1", UserAgent{Family: "go-http-client", Major: 1}},
+		{"", UserAgent{}},
+	}
+	for _, tt := range tests {
+		if got := ParseUserAgent(tt.ua); got != tt.want {
+			t.Errorf("ParseUserAgent(%q) = %+v, want %+v", tt.ua, got, tt.want)
+		}
+	}
+}
@@ -1,9 +1,5 @@
 package types
 
-import (
-	"sync"
-)
-
 type Fingerprint struct {
 	ClientIP      string `json:"client_ip"`
 	Plugins       string `json:"plugins"`
@@ -23,11 +19,30 @@ type Fingerprint struct {
 	Timezone  string `json:"timezone"`
 	JSEnabled bool   `json:"jsEnabled"`
 	IsMobile  bool   `json:"isMobile"`
+	// Behavior holds pointer and key timing samples recorded on the
+	// challenge page before the fingerprint was posted.
+	Behavior *Behavior `json:"behavior,omitempty"`
+}
+
+// Behavior is a compact record of how the visitor moved and typed. Times
+// are milliseconds since the page loaded.
+type Behavior struct {
+	Pointer []PointerSample `json:"pointer"`
+	Keys    []KeySample     `json:"keys"`
+}
+
+// PointerSample is one mouse or touch position.
+type PointerSample struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	T float64 `json:"t"`
 }
 
-type FingerprintStore struct {
-	sync.RWMutex
-	Data map[string]Fingerprint
+// KeySample is one keystroke: T is the key-down time and D how long the key
+// was held. Which key was pressed is never recorded.
+type KeySample struct {
+	T float64 `json:"t"`
+	D float64 `json:"d"`
 }
 
 type Verification struct {
@@ -41,4 +56,14 @@ type Challenge struct {
 	Seed       string
 	Type       string
 	Difficulty int
+	// Action is the action the challenge was issued for and Route the
+	// route it was started on; a solved challenge's token carries both.
+	Action string
+	Route  string
+	// Prompt and Image describe an interactive challenge's puzzle to the
+	// visitor; Image is a data: URL sent with the challenge but not
+	// stored. Answer is never sent to the client.
+	Prompt string
+	Image  string `json:"-"`
+	Answer string
 }
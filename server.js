@@ -6,6 +6,7 @@ const htmlContent = `
 <head>
     <meta charset="UTF-8">
     <title>Protected Page</title>
+    <script src="/janus/telemetry.js" defer></script>
 </head>
 <body>
     <h1>Hello from the REAL server!</h1>